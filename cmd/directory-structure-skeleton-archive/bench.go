@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/function61/gokit/app/byteshuman"
+	"github.com/function61/gokit/app/cli"
+	"github.com/joonas-fi/file-structure-skeleton-archive/pkg/skeleton"
+	"github.com/spf13/cobra"
+)
+
+func benchEntrypoint() *cobra.Command {
+	opts := defaultOptions()
+	opts.Progress = skeleton.ProgressNone // redrawing would be measured as well
+	asJSON := false
+
+	cmd := &cobra.Command{
+		Use:   "bench [dir...]",
+		Short: "Measures walk-and-archive throughput on this hardware, without writing anything",
+		Args:  cobra.MinimumNArgs(1),
+		Run: cli.Runner(func(ctx context.Context, args []string, logger *log.Logger) error {
+			return exitIfSpecialCode(bench(ctx, args, opts, asJSON, logger, os.Stdout))
+		}),
+	}
+
+	cmd.Flags().BoolVarP(&asJSON, "json", "", asJSON, "Output as JSON")
+	cmd.Flags().StringVarP(&opts.Format, "format", "", opts.Format, "Output format to benchmark (see the main command's --format)")
+	cmd.Flags().StringVarP(&opts.Compression, "compression", "", opts.Compression, "Compression for zip: deflate|zstd")
+	cmd.Flags().IntVarP(&opts.CompressionLevel, "compression-level", "", opts.CompressionLevel, "Compression level: 0-9 for deflate, 1-22 for zstd. -1 = the compression's default")
+	cmd.Flags().StringVarP(&opts.Hash, "hash", "", opts.Hash, "Hash each file's real content (reads all files): sha256")
+	cmd.Flags().BoolVarP(&opts.DetectType, "detect-type", "", opts.DetectType, "Detect each file's MIME type (reads the first 512 bytes of each file)")
+	cmd.Flags().StringVarP(&opts.Progress, "progress", "", opts.Progress, "Running counters on stderr: none|line|bar")
+	addFilterFlags(cmd.Flags(), &opts)
+	addWalkFlags(cmd.Flags(), &opts)
+
+	return cmd
+}
+
+type benchJSON struct {
+	Files            int     `json:"files"`
+	Directories      int     `json:"directories"`
+	LogicalBytes     int64   `json:"logicalBytes"`
+	ArchiveBytes     int64   `json:"archiveBytes"`
+	Seconds          float64 `json:"seconds"` // wall time
+	EntriesPerSecond float64 `json:"entriesPerSecond"`
+	BytesPerSecond   float64 `json:"bytesPerSecond"` // of logical data
+	PeakHeapBytes    uint64  `json:"peakHeapBytes"`
+	SysBytes         uint64  `json:"sysBytes"` // obtained from the OS
+}
+
+func bench(
+	ctx context.Context,
+	dirs []string,
+	opts options,
+	asJSON bool,
+	logger *log.Logger,
+	output io.Writer,
+) error {
+	if err := opts.parseSizeRange(); err != nil {
+		return err
+	}
+
+	if err := opts.parseAgeRange(time.Now()); err != nil {
+		return err
+	}
+
+	if err := opts.parseRates(); err != nil {
+		return err
+	}
+
+	opts.Logger = logger
+	opts.Quiet = true // logging every path would be measured as well
+
+	runtime.GC() // so that the peak isn't of whatever came before
+	stopSampling := samplePeakHeap(100 * time.Millisecond)
+
+	started := time.Now()
+	stats, err := skeleton.Archive(ctx, io.Discard, dirs, opts.Options)
+	took := time.Since(started)
+
+	peakHeap, sys := stopSampling()
+
+	if err != nil && !stats.Interrupted {
+		return err
+	}
+
+	perSecond := func(n float64) float64 {
+		if took <= 0 {
+			return 0
+		}
+
+		return n / took.Seconds()
+	}
+
+	result := benchJSON{
+		Files:            stats.Files,
+		Directories:      stats.Dirs,
+		LogicalBytes:     stats.LogicalBytes,
+		ArchiveBytes:     stats.ArchiveBytes,
+		Seconds:          took.Seconds(),
+		EntriesPerSecond: perSecond(float64(stats.Files + stats.Dirs)),
+		BytesPerSecond:   perSecond(float64(stats.LogicalBytes)),
+		PeakHeapBytes:    peakHeap,
+		SysBytes:         sys,
+	}
+
+	if asJSON {
+		encoder := json.NewEncoder(output)
+		encoder.SetIndent("", "  ")
+
+		if err := encoder.Encode(result); err != nil {
+			return err
+		}
+	} else {
+		printBench(output, result, opts)
+	}
+
+	if stats.Interrupted {
+		return errors.New("interrupted. the measurements are of a partial walk")
+	}
+
+	if len(stats.Errors) > 0 {
+		return &completedWithErrorsError{len(stats.Errors)}
+	}
+
+	return nil
+}
+
+func printBench(output io.Writer, result benchJSON, opts options) {
+	format := opts.Format
+	if format == skeleton.FormatZip {
+		format += ", " + opts.Compression
+		if opts.CompressionLevel != skeleton.CompressionLevelDefault {
+			format += fmt.Sprintf(" level %d", opts.CompressionLevel)
+		}
+	}
+
+	fmt.Fprintf(output, "%d files and %d directories (%s) in %s\n",
+		result.Files,
+		result.Directories,
+		byteshuman.Humanize(uint64(result.LogicalBytes)),
+		time.Duration(result.Seconds*float64(time.Second)).Round(time.Millisecond))
+	fmt.Fprintf(output, "Throughput: %.0f entries/s, %s/s of logical data\n", result.EntriesPerSecond, byteshuman.Humanize(uint64(result.BytesPerSecond)))
+	fmt.Fprintf(output, "Archive: %s (%s, --concurrency %d)\n", byteshuman.Humanize(uint64(result.ArchiveBytes)), format, opts.Concurrency)
+	fmt.Fprintf(output, "Peak heap: %s (obtained from the OS: %s)\n", byteshuman.Humanize(result.PeakHeapBytes), byteshuman.Humanize(result.SysBytes))
+}
+
+// runtime.MemStats only tells the current state, so the heap is sampled while the benchmark runs.
+// the returned func stops the sampling, and returns the peak heap and the memory obtained from the OS.
+func samplePeakHeap(interval time.Duration) func() (uint64, uint64) {
+	var memStats runtime.MemStats
+	peak := uint64(0)
+	sample := func() {
+		runtime.ReadMemStats(&memStats)
+		if memStats.HeapAlloc > peak {
+			peak = memStats.HeapAlloc
+		}
+	}
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			sample()
+
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return func() (uint64, uint64) {
+		close(stop)
+		<-stopped
+
+		sample() // the sampler is done, so memStats is ours now
+
+		return peak, memStats.Sys
+	}
+}
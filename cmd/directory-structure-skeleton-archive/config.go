@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// looked up from the working directory, then from the home directory, if --config isn't given
+const defaultConfigName = ".skeletonrc"
+
+// config file (YAML) keys are the long flag names, e.g. "exclude: [node_modules, '*.tmp']" or
+// "format: tar". precedence: flags given on the command line > config file > built-in defaults.
+// one file serves all commands, so a key is applied only to the commands that have such a flag.
+func addConfigFlag(app *cobra.Command) {
+	configPath := ""
+
+	app.PersistentFlags().StringVarP(&configPath, "config", "", configPath, "YAML file of default flag values (keys are flag names). Flags given on the command line override it. (default: "+defaultConfigName+" in the working directory or home directory, if it exists)")
+
+	app.PersistentPreRunE = func(cmd *cobra.Command, _ []string) error {
+		if err := applyConfigFile(cmd, configPath); err != nil {
+			// it's not a usage error, and main() prints the error
+			cmd.SilenceUsage, cmd.SilenceErrors = true, true
+			return err
+		}
+
+		return nil
+	}
+}
+
+func applyConfigFile(cmd *cobra.Command, configPath string) error {
+	if configPath == "" {
+		configPath = findDefaultConfig()
+		if configPath == "" {
+			return nil
+		}
+	}
+
+	content, err := os.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("--config: %w", err)
+	}
+
+	values := map[string]interface{}{}
+	if err := yaml.Unmarshal(content, &values); err != nil {
+		return fmt.Errorf("%s: %w", configPath, err)
+	}
+
+	known := allFlagNames(cmd.Root())
+
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys) // so that errors are deterministic
+
+	for _, key := range keys {
+		switch {
+		case key == "config":
+			return fmt.Errorf("%s: config can't refer to another config", configPath)
+		case !known[key]: // probably a typo, which shouldn't go unnoticed
+			return fmt.Errorf("%s: unknown option: %s", configPath, key)
+		}
+
+		flag := cmd.Flags().Lookup(key)
+		if flag == nil || flag.Changed { // other command's, or overridden by the command line
+			continue
+		}
+
+		if err := setFlagFromConfig(cmd.Flags(), key, values[key]); err != nil {
+			return fmt.Errorf("%s: %s: %w", configPath, key, err)
+		}
+	}
+
+	return nil
+}
+
+// a list sets a repeatable flag once per item, like repeating it on the command line would
+func setFlagFromConfig(flags *pflag.FlagSet, name string, value interface{}) error {
+	items, isList := value.([]interface{})
+	if !isList {
+		items = []interface{}{value}
+	}
+
+	for _, item := range items {
+		if _, isNested := item.(map[string]interface{}); isNested || item == nil {
+			return errors.New("expecting a value or a list of values")
+		}
+
+		if err := flags.Set(name, fmt.Sprint(item)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func findDefaultConfig() string {
+	candidates := []string{defaultConfigName}
+	if home, err := os.UserHomeDir(); err == nil {
+		candidates = append(candidates, filepath.Join(home, defaultConfigName))
+	}
+
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+
+	return ""
+}
+
+func allFlagNames(cmd *cobra.Command) map[string]bool {
+	names := map[string]bool{}
+
+	var collect func(cmd *cobra.Command)
+	collect = func(cmd *cobra.Command) {
+		cmd.Flags().VisitAll(func(flag *pflag.Flag) { names[flag.Name] = true })
+		for _, sub := range cmd.Commands() {
+			collect(sub)
+		}
+	}
+	collect(cmd)
+
+	return names
+}
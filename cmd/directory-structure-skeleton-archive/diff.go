@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/function61/gokit/app/cli"
+	"github.com/joonas-fi/file-structure-skeleton-archive/pkg/skeleton"
+	"github.com/spf13/cobra"
+)
+
+func diffEntrypoint() *cobra.Command {
+	asJSON := false
+	passwordFile := ""
+
+	cmd := &cobra.Command{
+		Use:   "diff [old.zip] [new.zip]",
+		Short: "Compares two skeleton archives. Exits non-zero if they differ.",
+		Args:  cobra.ExactArgs(2),
+		Run: cli.Runner(func(ctx context.Context, args []string, _ *log.Logger) error {
+			return diff(args[0], args[1], passwordFile, asJSON, os.Stdout)
+		}),
+	}
+
+	cmd.Flags().BoolVarP(&asJSON, "json", "", asJSON, "Output differences as JSON")
+	cmd.Flags().StringVarP(&passwordFile, "password-file", "", passwordFile, "Password for encrypted archives (prompted if not given)")
+
+	return cmd
+}
+
+func diff(oldArchivePath string, newArchivePath string, passwordFile string, asJSON bool, output io.Writer) error {
+	diffs, err := skeleton.Diff(oldArchivePath, newArchivePath, passwordFrom(passwordFile))
+	if err != nil {
+		return err
+	}
+
+	if err := printDifferences(output, diffs, asJSON); err != nil {
+		return err
+	}
+
+	if n := diffs.Count(); n > 0 {
+		return fmt.Errorf("%d difference(s) found", n)
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/function61/gokit/app/byteshuman"
+	"github.com/function61/gokit/app/cli"
+	"github.com/joonas-fi/file-structure-skeleton-archive/pkg/skeleton"
+	"github.com/spf13/cobra"
+)
+
+func dupesEntrypoint() *cobra.Command {
+	asJSON := false
+	passwordFile := ""
+
+	cmd := &cobra.Command{
+		Use:   "dupes [archive.zip...]",
+		Short: "Reports duplicate files of a skeleton archive (by size, and content hash if archived with --hash), most wasted bytes first",
+		Args:  cobra.MinimumNArgs(1),
+		Run: cli.Runner(func(ctx context.Context, args []string, _ *log.Logger) error {
+			return dupes(args, passwordFile, asJSON, os.Stdout)
+		}),
+	}
+
+	cmd.Flags().BoolVarP(&asJSON, "json", "", asJSON, "Output as JSON")
+	cmd.Flags().StringVarP(&passwordFile, "password-file", "", passwordFile, "Password for an encrypted archive (prompted if not given)")
+
+	return cmd
+}
+
+func dupes(archivePaths []string, passwordFile string, asJSON bool, output io.Writer) error {
+	entries, err := skeleton.List(archivePaths, passwordFrom(passwordFile))
+	if err != nil {
+		return err
+	}
+
+	duplicates := skeleton.FindDuplicates(entries)
+
+	if asJSON {
+		encoder := json.NewEncoder(output)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(duplicates)
+	}
+
+	if !duplicates.ByContent {
+		fmt.Fprintln(output, "Candidate duplicates: grouped by size only, because the archive has no hashes (archive with --hash to compare content).")
+		fmt.Fprintln(output)
+	}
+
+	for _, group := range duplicates.Groups {
+		hash := ""
+		if group.SHA256 != "" {
+			hash = " sha256 " + group.SHA256
+		}
+
+		fmt.Fprintf(output, "%s wasted: %d x %s%s\n",
+			byteshuman.Humanize(uint64(group.WastedBytes)),
+			len(group.Paths),
+			byteshuman.Humanize(uint64(group.Size)),
+			hash)
+
+		for _, path := range group.Paths {
+			fmt.Fprintf(output, "  %s\n", path)
+		}
+	}
+
+	_, err = fmt.Fprintf(output, "\n%d group(s), %s wasted in total\n", len(duplicates.Groups), byteshuman.Humanize(uint64(duplicates.WastedBytes)))
+	return err
+}
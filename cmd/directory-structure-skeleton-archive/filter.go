@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// human-friendly flag values are parsed here, so the library API can take plain values
+
+func (o *options) parseSizeRange() error {
+	o.MinSize = 0
+	o.MaxSize = -1
+
+	if o.minSize != "" {
+		size, err := parseHumanSize(o.minSize)
+		if err != nil {
+			return fmt.Errorf("--min-size: %w", err)
+		}
+		o.MinSize = size
+	}
+
+	if o.maxSize != "" {
+		size, err := parseHumanSize(o.maxSize)
+		if err != nil {
+			return fmt.Errorf("--max-size: %w", err)
+		}
+		o.MaxSize = size
+	}
+
+	if o.MaxSize >= 0 && o.MinSize > o.MaxSize {
+		return fmt.Errorf("--min-size (%s) is larger than --max-size (%s)", o.minSize, o.maxSize)
+	}
+
+	return nil
+}
+
+func (o *options) parseAgeRange(now time.Time) error {
+	var err error
+
+	if o.newerThan != "" {
+		if o.NewerThan, err = parseAgeThreshold(o.newerThan, now); err != nil {
+			return fmt.Errorf("--newer-than: %w", err)
+		}
+	}
+
+	if o.olderThan != "" {
+		if o.OlderThan, err = parseAgeThreshold(o.olderThan, now); err != nil {
+			return fmt.Errorf("--older-than: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (o *options) parseRates() error {
+	if o.maxReadRate != "" {
+		rate, err := parseHumanSize(strings.TrimSuffix(o.maxReadRate, "/s")) // "10M/s" reads naturally as well
+		switch {
+		case err != nil:
+			return fmt.Errorf("--max-read-rate: %w", err)
+		case rate <= 0:
+			return errors.New("--max-read-rate: rate must be positive")
+		}
+		o.MaxReadRate = rate
+	}
+
+	if o.MaxEntriesRate < 0 {
+		return errors.New("--max-entries-rate: rate can't be negative")
+	}
+
+	return nil
+}
+
+// "30d" (meaning 30 days before now), "2h30m", "2006-01-02" (local time) or RFC3339
+func parseAgeThreshold(value string, now time.Time) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"} {
+		if ts, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return ts, nil
+		}
+	}
+
+	age, err := parseAge(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("not an age or a timestamp: %s", value)
+	}
+
+	return now.Add(-age), nil
+}
+
+// like time.ParseDuration() but also supports days and weeks (only as the sole unit)
+func parseAge(value string) (time.Duration, error) {
+	unitDurations := map[string]time.Duration{
+		"d": 24 * time.Hour,
+		"w": 7 * 24 * time.Hour,
+	}
+
+	for unit, unitDuration := range unitDurations {
+		if number := strings.TrimSuffix(value, unit); number != value {
+			count, err := strconv.ParseFloat(number, 64)
+			if err != nil {
+				return 0, err
+			}
+
+			return time.Duration(count * float64(unitDuration)), nil
+		}
+	}
+
+	return time.ParseDuration(value)
+}
+
+// "1024", "10k", "1.5M", "2GiB". units are binary (k = 1024) like in our human-readable output.
+func parseHumanSize(human string) (int64, error) {
+	number := strings.TrimRight(strings.ToLower(human), "ib") // "MiB" / "MB" / "M"
+
+	multiplier := int64(1)
+	if number != "" {
+		if exp := strings.IndexByte("kmgtp", number[len(number)-1]); exp != -1 {
+			multiplier = 1 << (10 * (exp + 1))
+			number = number[:len(number)-1]
+		}
+	}
+
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size: %s", human)
+	}
+
+	return int64(value * float64(multiplier)), nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+	_ "time/tzdata" // for --timezone where the OS has no zone database (Windows)
+
+	"github.com/function61/gokit/app/cli"
+	"github.com/joonas-fi/file-structure-skeleton-archive/pkg/skeleton"
+	"github.com/spf13/cobra"
+)
+
+func listEntrypoint() *cobra.Command {
+	asJSON := false
+	asTree := false
+	passwordFile := ""
+	timezone := "local"
+
+	cmd := &cobra.Command{
+		Use:   "list [archive.zip...]",
+		Short: "Lists the entries of a skeleton archive (all volumes, if split) without extracting",
+		Args:  cobra.MinimumNArgs(1),
+		Run: cli.Runner(func(ctx context.Context, args []string, _ *log.Logger) error {
+			location, err := loadTimezone(timezone)
+			if err != nil {
+				return err
+			}
+
+			return list(args, asJSON, asTree, passwordFile, location, os.Stdout)
+		}),
+	}
+
+	cmd.Flags().BoolVarP(&asJSON, "json", "", asJSON, "Output as JSON")
+	cmd.Flags().BoolVarP(&asTree, "tree", "", asTree, "Render as an indented tree")
+	cmd.Flags().StringVarP(&passwordFile, "password-file", "", passwordFile, "Password for an encrypted archive (prompted if not given)")
+	cmd.Flags().StringVarP(&timezone, "timezone", "", timezone, "Show times in this zone (IANA name like America/New_York, UTC or local). --json is always UTC.")
+
+	return cmd
+}
+
+// IANA name, or "local"
+func loadTimezone(name string) (*time.Location, error) {
+	if name == "local" {
+		return time.Local, nil
+	}
+
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("--timezone: %w", err)
+	}
+
+	return location, nil
+}
+
+func list(archivePaths []string, asJSON bool, asTree bool, passwordFile string, location *time.Location, output io.Writer) error {
+	if asJSON && asTree {
+		return fmt.Errorf("--json and --tree are mutually exclusive")
+	}
+
+	entries, err := skeleton.List(archivePaths, passwordFrom(passwordFile))
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case asJSON:
+		encoder := json.NewEncoder(output)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(entries)
+	case asTree:
+		return skeleton.WriteTree(output, entries)
+	default:
+		for _, entry := range entries {
+			path := entry.Path
+			if entry.IsDir {
+				path += "/"
+			}
+
+			if _, err := fmt.Fprintf(output, "%s %12d %s %s\n", entry.Mode, entry.Size, entry.Modified.In(location).Format("2006-01-02 15:04:05"), path); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// one JSON line per log message, for orchestration systems that ingest structured logs
+type logRecord struct {
+	Time    time.Time    `json:"time"`
+	Level   string       `json:"level"` // debug|info|error
+	Message string       `json:"msg"`
+	Path    string       `json:"path,omitempty"`
+	Size    *int64       `json:"size,omitempty"` // of a listed file
+	Summary *summaryJSON `json:"summary,omitempty"`
+}
+
+type jsonLogWriter struct {
+	output io.Writer
+	mu     sync.Mutex
+}
+
+// the returned logger's messages (incl. logex levels, which are prefixes like "[INFO] ") are
+// written as records. the writer's entryLog() is for skeleton.Options.EntryLog.
+func newJSONLogger(output io.Writer) (*log.Logger, *jsonLogWriter) {
+	writer := &jsonLogWriter{output: output}
+	return log.New(writer, "", 0), writer
+}
+
+func (j *jsonLogWriter) Write(msg []byte) (int, error) {
+	level, message := parseLogLevel(strings.TrimSuffix(string(msg), "\n"))
+
+	if err := j.write(logRecord{Level: level, Message: message}); err != nil {
+		return 0, err
+	}
+
+	return len(msg), nil
+}
+
+func (j *jsonLogWriter) entryLog(path string, fileInfo fs.FileInfo) {
+	record := logRecord{Level: "info", Message: "archived", Path: path}
+	if fileInfo.Mode().IsRegular() {
+		size := fileInfo.Size()
+		record.Size = &size
+	}
+
+	_ = j.write(record) // nowhere to report a failed log write
+}
+
+func (j *jsonLogWriter) summary(stats summaryJSON, message string) error {
+	return j.write(logRecord{Level: "info", Message: message, Summary: &stats})
+}
+
+func (j *jsonLogWriter) write(record logRecord) error {
+	record.Time = time.Now().UTC()
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	_, err = j.output.Write(append(line, '\n'))
+	return err
+}
+
+// "[ERROR] disk on fire" => "error", "disk on fire". unprefixed messages are info.
+func parseLogLevel(msg string) (string, string) {
+	for _, level := range []string{"debug", "info", "error"} {
+		if prefix := fmt.Sprintf("[%s] ", strings.ToUpper(level)); strings.HasPrefix(msg, prefix) {
+			return level, strings.TrimPrefix(msg, prefix)
+		}
+	}
+
+	return "info", msg
+}
@@ -1,11 +1,10 @@
 package main
 
 import (
-	"archive/zip"
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
@@ -13,142 +12,508 @@ import (
 
 	"github.com/function61/gokit/app/cli"
 	"github.com/function61/gokit/app/dynversion"
+	"github.com/function61/gokit/log/logex"
 	"github.com/function61/gokit/os/osutil"
+	"github.com/joonas-fi/file-structure-skeleton-archive/pkg/skeleton"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 func main() {
-	osutil.ExitIfError((&cobra.Command{
+	opts := defaultOptions()
+
+	app := &cobra.Command{
 		Use:     os.Args[0] + " [dir]",
 		Short:   "Creates skeleton .zip that represent how a directory hierarchy looks like, without storing file contents",
 		Version: dynversion.Version,
-		Args:    cobra.MinimumNArgs(1),
-		Run: cli.Runner(func(ctx context.Context, args []string, _ *log.Logger) error {
-			return logic(ctx, args)
+		Args:    cobra.ArbitraryArgs, // validated in logic() since --files-from makes these optional
+		Run: cli.Runner(func(ctx context.Context, args []string, logger *log.Logger) error {
+			return exitIfSpecialCode(logic(ctx, opts, logger, func(ctx context.Context, w io.Writer, opts skeleton.Options) (skeleton.Stats, error) {
+				return skeleton.Archive(ctx, w, args, opts)
+			}))
 		}),
-	}).Execute())
+	}
+
+	addOutputFlags(app.Flags(), &opts)
+	addFilterFlags(app.Flags(), &opts)
+	addWalkFlags(app.Flags(), &opts)
+	app.Flags().BoolVarP(&opts.appendToOutput, "append", "", opts.appendToOutput, "Add the dirs to the existing output archive (zip), keeping its entries without walking their roots again")
+	app.Flags().StringVarP(&opts.StateFile, "state-file", "", opts.StateFile, "Record the walk's progress in this file, so that if it's interrupted (or fails), running again with the same file resumes roughly where it left off. Implies --keep-partial.")
+	app.Flags().StringVarP(&opts.FilesFrom, "files-from", "T", opts.FilesFrom, `Instead of walking dirs, archive paths listed (one per line) in this file ("-" for stdin). Like tar, a leading "/" and "../" are removed from the names.`)
+	app.Flags().StringVarP(&opts.RelativeTo, "relative-to", "", opts.RelativeTo, "Store entry names relative to this dir (default: each root's parent, i.e. roots appear by their base name, so that several roots don't get mixed together. see --disambiguate)")
+	app.Flags().BoolVarP(&opts.Disambiguate, "disambiguate", "", opts.Disambiguate, "If dirs would have the same name in the archive, suffix them (data, data-2, ...) instead of failing")
+	app.Flags().BoolVarP(&opts.Xattrs, "xattrs", "", opts.Xattrs, "Store extended attributes (SELinux labels, com.apple.* etc.). Restore reapplies them.")
+	app.Flags().BoolVarP(&opts.FileAttrs, "file-attrs", "", opts.FileAttrs, "Store Linux inode flags (chattr's immutable, append-only, nodump etc.). Restore reapplies them if run as root.")
+	app.Flags().BoolVarP(&opts.AllTimes, "all-times", "", opts.AllTimes, "Also store access and change times (Linux), for forensic timelines. list --json shows them.")
+	app.Flags().BoolVarP(&opts.Owners, "owners", "", opts.Owners, "Store owner and group (IDs and names). Restore chowns accordingly if run as root.")
+
+	app.AddCommand(restoreEntrypoint())
+	app.AddCommand(verifyEntrypoint())
+	app.AddCommand(listEntrypoint())
+	app.AddCommand(skeletonizeEntrypoint())
+	app.AddCommand(statsEntrypoint())
+	app.AddCommand(benchEntrypoint())
+	app.AddCommand(diffEntrypoint())
+	app.AddCommand(dupesEntrypoint())
+	app.AddCommand(mergeEntrypoint())
+
+	addConfigFlag(app)
+
+	osutil.ExitIfError(app.Execute())
+}
+
+// flags that don't map directly onto the library's options
+type options struct {
+	skeleton.Options
+	output         string
+	jsonSummary    bool
+	logFormat      string
+	jsonLog        *jsonLogWriter // with --log-format=json
+	keepPartial    bool
+	timeout        time.Duration // 0 = none
+	timedOut       bool          // set after the run, for the summary
+	encrypt        bool
+	appendToOutput bool
+	passwordFile   string
+	minSize        string // human size. "" = no limit
+	maxSize        string // human size. "" = no limit
+	newerThan      string // duration or timestamp. "" = no limit
+	olderThan      string // duration or timestamp. "" = no limit
+	split          string // human size. "" = don't split
+	keepBelow      string // human size. "" = keep no content
+	maxReadRate    string // human size per second. "" = unlimited
+	verifyOutput   bool
+	nameMap        string // path. "" = don't write
+	readmeFile     string // path. "" = generated README
 }
 
-func logic(ctx context.Context, dirs []string) error {
-	return osutil.WriteFileAtomic("out.zip", func(file io.Writer) error {
-		zipWriter := zip.NewWriter(file)
+func defaultOptions() options {
+	opts := options{Options: skeleton.DefaultOptions()}
+	opts.Progress = skeleton.ProgressLine
+	opts.Skeletonignore = true // it's our own file, so whoever placed it wants it honored
+	opts.logFormat = logFormatText
+	return opts
+}
 
-		// no need to change default compression level. here's results from Video + Pictures collection of 163 GB:
-		//
-		// DefaultCompression = 164M
-		// BestCompression = 164M
-		// BestSpeed = 204M
-		// HuffmanOnly = huge file size
+// for anything that visits entries, be it from a walk or from an archive
+func addFilterFlags(flags *pflag.FlagSet, opts *options) {
+	flags.StringArrayVarP(&opts.Excludes, "exclude", "", nil, "Glob pattern (matched against base name and path relative to root) to exclude. Can be repeated.")
+	flags.StringSliceVarP(&opts.Extensions, "ext", "", nil, "Only archive files with these extensions (e.g. jpg,png). Comma-separated or repeated, case-insensitive. Dirs are still walked.")
+	flags.BoolVarP(&opts.DirsOnly, "dirs-only", "", opts.DirsOnly, "Skip everything but directories (e.g. to keep --format dot readable)")
+	flags.StringSliceVarP(&opts.ExcludeExtensions, "exclude-ext", "", nil, "Skip files with these extensions. Comma-separated or repeated, case-insensitive.")
+	flags.StringVarP(&opts.minSize, "min-size", "", opts.minSize, "Skip files smaller than this (e.g. 10k, 1.5M, 2G)")
+	flags.StringVarP(&opts.maxSize, "max-size", "", opts.maxSize, "Skip files larger than this (e.g. 10k, 1.5M, 2G)")
+	flags.StringVarP(&opts.newerThan, "newer-than", "", opts.newerThan, "Skip files modified before this. Age (30d, 2h) or timestamp (2006-01-02, RFC3339)")
+	flags.StringVarP(&opts.olderThan, "older-than", "", opts.olderThan, "Skip files modified after this. Age (30d, 2h) or timestamp (2006-01-02, RFC3339)")
+}
 
-		for _, dir := range dirs {
-			if err := zipOneDir(ctx, dir, zipWriter); err != nil {
-				return err
+// for anything that walks a directory tree
+func addWalkFlags(flags *pflag.FlagSet, opts *options) {
+	flags.BoolVarP(&opts.SkipErrors, "skip-errors", "", opts.SkipErrors, "Log and leave out entries that can't be read instead of failing (exit code 2 if any)")
+	flags.IntVarP(&opts.MaxDepth, "max-depth", "", opts.MaxDepth, "Don't descend deeper than this many levels below each root (0 = only immediate children, -1 = unlimited)")
+	flags.BoolVarP(&opts.OneFileSystem, "one-file-system", "x", opts.OneFileSystem, "Don't descend into directories on other filesystems than the root's")
+	flags.BoolVarP(&opts.FollowSymlinks, "follow-symlinks", "L", opts.FollowSymlinks, "Record symlinks as their targets, and walk symlinked directories (each directory only once, so loops are safe)")
+	flags.BoolVarP(&opts.NoHidden, "no-hidden", "", opts.NoHidden, "Skip files and directories whose name starts with a dot")
+	flags.BoolVarP(&opts.Skeletonignore, "skeletonignore", "", opts.Skeletonignore, "Skip entries matching the patterns (same as --exclude's) in .skeletonignore files at the roots and below, each applying to its own subtree")
+	flags.BoolVarP(&opts.Gitignore, "gitignore", "", opts.Gitignore, "Skip entries ignored by .gitignore files encountered along the walk")
+	flags.IntVarP(&opts.Concurrency, "concurrency", "", opts.Concurrency, "Walk directories with this many goroutines. Entry order is nondeterministic unless --reproducible.")
+	flags.IntVarP(&opts.Threads, "threads", "", opts.Threads, "Read files' content (for --hash, --detect-type and --sample-bytes) with this many goroutines. Doesn't change entry order.")
+	flags.StringVarP(&opts.maxReadRate, "max-read-rate", "", opts.maxReadRate, "Read files' content (for --hash, --detect-type and --sample-bytes) at most this fast, per second (e.g. 10M)")
+	flags.IntVarP(&opts.MaxEntriesRate, "max-entries-rate", "", opts.MaxEntriesRate, "Walk at most this many entries per second, to go easy on shared storage (0 = unlimited)")
+}
+
+// for anything that writes an archive, be it from a walk or from an existing archive
+func addOutputFlags(flags *pflag.FlagSet, opts *options) {
+	flags.StringVarP(&opts.output, "output", "o", opts.output, `Path of the archive to write ("-" for stdout) (default "out.<format>")`)
+	flags.IntVarP(&opts.LimitEntries, "limit-entries", "", opts.LimitEntries, "Stop after archiving this many entries (for exploring unknown trees). The archive is marked incomplete, and exit code is 3.")
+	flags.DurationVarP(&opts.timeout, "timeout", "", opts.timeout, "Stop the walk after this long (e.g. 10m), like when interrupted. See --keep-partial.")
+	flags.BoolVarP(&opts.keepPartial, "keep-partial", "", opts.keepPartial, "If interrupted, keep the (valid but incomplete) archive instead of discarding it")
+	flags.StringVarP(&opts.Prefix, "prefix", "", opts.Prefix, "Nest all entries under this path inside the archive (e.g. backups/2024)")
+	flags.IntVarP(&opts.StripComponents, "strip-components", "", opts.StripComponents, "Drop this many leading components from names (before --prefix is added). Entries with no more components are left out.")
+	flags.BoolVarP(&opts.NormalizeUnicode, "normalize-unicode", "", opts.NormalizeUnicode, "Store names in Unicode NFC, so that skeletons made on macOS (NFD names) compare equal to ones from elsewhere")
+	flags.BoolVarP(&opts.CheckPortability, "check-portability", "", opts.CheckPortability, "Report names that Windows (or FAT) can't have, e.g. with : ? * or reserved device names like CON and NUL")
+	flags.BoolVarP(&opts.SanitizeNames, "sanitize-names", "", opts.SanitizeNames, "Rewrite names that Windows can't have to ones it can (e.g. \"a:b\" => \"a_b\", \"CON\" => \"CON_\"). --name-map records the mapping.")
+	flags.BoolVarP(&opts.NameHash, "name-hash", "", opts.NameHash, "Redact names by replacing each path component with a short hash of it (stable, so guessable for common names)")
+	flags.BoolVarP(&opts.KeepExtensions, "keep-extensions", "", opts.KeepExtensions, "With --name-hash, keep files' extensions")
+	flags.StringVarP(&opts.nameMap, "name-map", "", opts.nameMap, "With --name-hash (or --sanitize-names), write the hashed (or sanitized) => original names (JSON lines) to this file, for de-anonymizing locally")
+	flags.StringVarP(&opts.split, "split", "", opts.split, "Split into volumes (out.001.zip, out.002.zip, ...) of at most this size (e.g. 100M). Entries aren't split across volumes.")
+	flags.BoolVarP(&opts.SplitByTopLevel, "split-by-top-level", "", opts.SplitByTopLevel, "Write each of the root's subdirectories to an archive of its own (out-<name>.zip), and the files directly in the root to out-root.zip")
+	flags.BoolVarP(&opts.Fingerprint, "fingerprint", "", opts.Fingerprint, "Compute a Merkle root hash of the tree (names, sizes, modes, link targets and --hash digests) into the summary, manifest and zip comment")
+	flags.StringVarP(&opts.readmeFile, "readme-file", "", opts.readmeFile, "Use this file's content as the archive's README instead of the generated text")
+	flags.StringVarP(&opts.ReadmeName, "readme-name", "", opts.ReadmeName, "Name of the README entry (default \"README-this-archive-is-special.txt\")")
+	flags.BoolVarP(&opts.NoReadme, "no-readme", "", opts.NoReadme, "Leave the README out, e.g. for pipelines that reject unexpected entries (manifest.json still describes the archive)")
+	flags.BoolVarP(&opts.RestoreScript, "emit-restore-script", "", opts.RestoreScript, "Also store restore.sh in the archive, for recreating the skeleton (zero-filled) without this tool")
+	flags.StringSliceVarP(&opts.Since, "since", "", nil, "Previous skeleton (zip, all volumes). Only entries that are new or changed since it are archived, and the removed ones are listed in the manifest.")
+	flags.BoolVarP(&opts.DryRun, "dry-run", "n", opts.DryRun, "Walk (with filters applied) and list what would be archived, but don't write anything")
+	flags.BoolVarP(&opts.verifyOutput, "verify-output", "", opts.verifyOutput, "Read the written archive back to check it's not corrupt. If it is, it's discarded.")
+	flags.StringVarP(&opts.Format, "format", "", opts.Format, "Output format: zip|tar|tar.gz|json|jsonl|yaml|csv|tree|dot|html (tree is written to stdout by default. dot is a Graphviz graph, html a browsable report)")
+	flags.BoolVarP(&opts.WithCounts, "with-counts", "", opts.WithCounts, "Record each directory's number of immediate children and of all descendants")
+	flags.BoolVarP(&opts.WithSizes, "with-sizes", "", opts.WithSizes, "Record each directory's total file count and bytes (of its whole subtree), like du")
+	flags.BoolVarP(&opts.WithAllocation, "with-allocation", "", opts.WithAllocation, "Record each file's bytes allocated on disk (st_blocks), which tells sparse files apart from dense ones")
+	flags.BoolVarP(&opts.PruneEmptyDirs, "prune-empty-dirs", "", opts.PruneEmptyDirs, "Leave out directories that (after filtering) don't contain any files")
+	flags.BoolVarP(&opts.Quiet, "quiet", "q", opts.Quiet, "Don't list each path. Summary, errors and --progress are still shown.")
+	flags.StringVarP(&opts.Progress, "progress", "", opts.Progress, "Running counters on stderr: none|line|bar")
+	flags.BoolVarP(&opts.CountFirst, "count-first", "", opts.CountFirst, "Count the entries with a quick pre-pass, so that --progress shows a percentage and an ETA (reads directories twice)")
+	flags.StringVarP(&opts.logFormat, "log-format", "", opts.logFormat, "Log format (on stderr): text|json (JSON lines with level, path and size, incl. the summary)")
+	flags.IntVarP(&opts.Top, "top", "", opts.Top, "List this many of the largest files (by size) after the summary, for hunting space hogs")
+	flags.BoolVarP(&opts.jsonSummary, "json-summary", "", opts.jsonSummary, "Write the final summary as JSON (to stderr)")
+	flags.StringVarP(&opts.FillByte, "fill-byte", "", opts.FillByte, "Hex byte (or short repeating pattern, e.g. deadbeef) to fill file contents with")
+	flags.StringVarP(&opts.keepBelow, "keep-content-below", "", opts.keepBelow, "Store files smaller than this (e.g. 64K) with their real content, like config files, while the rest are skeletons")
+	flags.IntVarP(&opts.SampleBytes, "sample-bytes", "", opts.SampleBytes, "Keep this many bytes (--sample-bytes=N, or 512 if just --sample-bytes) of each file's real content, for sniffing file types later")
+	flags.Lookup("sample-bytes").NoOptDefVal = "512"
+	flags.BoolVarP(&opts.DetectType, "detect-type", "", opts.DetectType, "Detect and store each file's MIME type (reads the first 512 bytes of each file)")
+	flags.StringVarP(&opts.Compression, "compression", "", opts.Compression, "Compression for zip: deflate|zstd (zstd is much smaller for large files, but not all unzip tools support it)")
+	flags.IntVarP(&opts.CompressionLevel, "compression-level", "", opts.CompressionLevel, "Compression level: 0-9 for deflate, 1-22 for zstd. -1 = the compression's default (deflate 5, zstd 3)")
+	flags.StringVarP(&opts.Hash, "hash", "", opts.Hash, "Store hash of each file's real content (slow, reads all files): sha256")
+	flags.BoolVarP(&opts.encrypt, "encrypt", "", opts.encrypt, "Encrypt the whole archive (incl. entry names) with a password")
+	flags.StringVarP(&opts.passwordFile, "password-file", "", opts.passwordFile, "Read the --encrypt password from this file instead of prompting")
+	flags.BoolVarP(&opts.Reproducible, "reproducible", "", opts.Reproducible, "Produce byte-identical output for identical input (generated timestamps follow SOURCE_DATE_EPOCH, which is honored even without this)")
+}
+
+// output "-" means stdout
+const outputStdout = "-"
+
+// produce writes the skeleton (from whatever source) to w
+func logic(
+	ctx context.Context,
+	opts options,
+	logger *log.Logger,
+	produce func(ctx context.Context, w io.Writer, opts skeleton.Options) (skeleton.Stats, error),
+) (err error) {
+	switch opts.logFormat {
+	case logFormatText:
+	case logFormatJSON:
+		logger, opts.jsonLog = newJSONLogger(os.Stderr)
+		opts.EntryLog = opts.jsonLog.entryLog
+		opts.Progress = skeleton.ProgressNone // a status line would garble the records
+
+		defer func() {
+			if err != nil {
+				logex.Levels(logger).Error.Println(err.Error())
 			}
+		}()
+	default:
+		return fmt.Errorf("unsupported log format: %s", opts.logFormat)
+	}
+
+	if err := opts.parseSizeRange(); err != nil {
+		return err
+	}
+
+	if err := opts.parseAgeRange(time.Now()); err != nil {
+		return err
+	}
+
+	if err := opts.parseRates(); err != nil {
+		return err
+	}
+
+	if opts.Format == skeleton.FormatTree { // for eyeballing, not an archive. the tree itself lists the paths and counts.
+		opts.Quiet = true
+
+		if opts.output == "" {
+			opts.output = outputStdout
 		}
+	}
 
-		if err := zipWriter.SetComment("written by directory-structure-skeleton-archive"); err != nil {
-			return err
+	if opts.output == "" {
+		opts.output = "out." + opts.Format
+	}
+
+	if opts.appendToOutput {
+		if opts.output == outputStdout || opts.DryRun || opts.split != "" {
+			return errors.New("--append needs an output file, and can't be used with --dry-run or --split")
 		}
 
-		readme, err := zipWriter.CreateHeader(&zip.FileHeader{
-			Name:     "README-this-archive-is-special.txt",
-			Modified: time.Now().UTC(),
-		})
-		if err != nil {
-			return err
+		if _, err := os.Stat(opts.output); err != nil {
+			return fmt.Errorf("--append: %w", err)
 		}
-		if _, err := readme.Write([]byte("This archive contains only metadata about the files. The file contents are filled with null.")); err != nil {
-			return err
+
+		opts.Append = opts.output
+	}
+
+	if opts.StateFile != "" {
+		if opts.output == outputStdout || opts.DryRun || opts.appendToOutput || opts.encrypt || opts.verifyOutput {
+			return errors.New("--state-file needs an output file, and can't be used with --dry-run, --append, --encrypt or --verify-output")
 		}
 
-		return zipWriter.Close()
-	})
-}
+		// the previous run's partial archive, which gets rebuilt as the start of this one
+		if _, err := os.Stat(opts.StateFile); err == nil {
+			if _, err := os.Stat(opts.output); err == nil {
+				opts.Resume = opts.output
+			}
+		}
+	}
+
+	if opts.split != "" {
+		size, err := parseHumanSize(opts.split)
+		switch {
+		case err != nil:
+			return fmt.Errorf("--split: %w", err)
+		case size <= 0:
+			return errors.New("--split: size must be positive")
+		case opts.output == outputStdout:
+			return errors.New("--split can't be used when writing to stdout")
+		case opts.Format != skeleton.FormatZip:
+			return errors.New("--split is only supported with --format zip")
+		}
+		opts.SplitSize = size
+	}
 
-func zipOneDir(ctx context.Context, dir string, zipWriter *zip.Writer) error {
-	if err := filepath.WalkDir(dir, func(path string, dirEntry fs.DirEntry, err error) error {
-		withErr := func(err error) error {
-			return fmt.Errorf("%s: %w", path, err)
+	if opts.keepBelow != "" {
+		size, err := parseHumanSize(opts.keepBelow)
+		switch {
+		case err != nil:
+			return fmt.Errorf("--keep-content-below: %w", err)
+		case size <= 0:
+			return errors.New("--keep-content-below: size must be positive")
+		case opts.Format != skeleton.FormatZip:
+			return errors.New("--keep-content-below is only supported with --format zip")
 		}
+		opts.KeepContentBelow = size
+	}
 
+	if opts.verifyOutput && (opts.output == outputStdout || opts.Format != skeleton.FormatZip) {
+		return errors.New("--verify-output needs a zip file as output")
+	}
+
+	if opts.SplitByTopLevel && (opts.output == outputStdout || opts.split != "" || opts.appendToOutput || opts.verifyOutput) {
+		return errors.New("--split-by-top-level needs an output file, and can't be used with --split, --append or --verify-output")
+	}
+
+	if opts.DryRun && (opts.verifyOutput || opts.encrypt || opts.nameMap != "") {
+		return errors.New("--dry-run writes nothing, so it can't be used with --verify-output, --encrypt or --name-map")
+	}
+
+	if opts.nameMap != "" {
+		if !opts.NameHash && !opts.SanitizeNames {
+			return errors.New("--name-map requires --name-hash or --sanitize-names")
+		}
+
+		nameMap, err := os.Create(opts.nameMap)
 		if err != nil {
-			return withErr(err)
+			return err
 		}
+		defer nameMap.Close()
 
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-			// continue
+		opts.NameMap = nameMap
+	}
+
+	if opts.readmeFile != "" {
+		readme, err := os.ReadFile(opts.readmeFile)
+		if err != nil {
+			return fmt.Errorf("--readme-file: %w", err)
 		}
 
-		fmt.Println(path)
+		opts.ReadmeContent = readme
+	}
 
-		fileInfo, err := dirEntry.Info()
+	if opts.passwordFile != "" && !opts.encrypt {
+		return errors.New("--password-file requires --encrypt")
+	}
+
+	if opts.encrypt { // ask before the walk, so the user doesn't have to wait around for the prompt
+		password, err := readPassword(opts.passwordFile, true)
 		if err != nil {
-			return withErr(err)
+			return err
 		}
+		opts.Password = password
+	}
 
-		if fileInfo.IsDir() {
-			return nil
+	opts.SincePassword = func() ([]byte, error) { // a previous skeleton of the same tree is likely encrypted with the same password
+		if opts.Password != nil {
+			return opts.Password, nil
 		}
 
-		zipInfo, err := zip.FileInfoHeader(fileInfo)
-		if err != nil {
-			return withErr(err)
+		return readPassword("", false)
+	}
+
+	// paths and progress go to stderr (via logger), so in stdout mode they don't corrupt the stream
+	opts.Logger = logger
+
+	if opts.timeout > 0 { // after the password prompt, so waiting for the user doesn't count
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
+	}
+	interruption := func() string {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Sprintf("timed out after %s", opts.timeout)
 		}
 
-		// > If compression is desired, callers should set the FileHeader.Method field; it is unset by default.
-		zipInfo.Method = zip.Deflate
+		return "interrupted"
+	}
 
-		// > Because fs.FileInfo's Name method returns only the base name of the file it describes, it may be
-		// > necessary to modify the Name field of the returned header to provide the full path name of the file.
-		zipInfo.Name = func() string {
-			if fileInfo.IsDir() {
-				// > To write an empty directory you just need to call Create with the directory path with a trailing path separator.
-				// https://stackoverflow.com/a/70482137
-				return path + "/"
-			} else {
-				return path
-			}
-		}()
+	var stats skeleton.Stats
+	var interruptedBy error // if the partial archive was kept
+	if err := func() error {
+		if opts.DryRun {
+			var err error
+			stats, err = produce(ctx, io.Discard, opts.Options)
+			return err
+		}
 
-		objectInZip, err := zipWriter.CreateHeader(zipInfo)
-		if err != nil {
-			return withErr(err)
+		if opts.output == outputStdout { // atomic write makes no sense for a stream
+			var err error
+			stats, err = produce(ctx, os.Stdout, opts.Options)
+			return err
 		}
 
-		if !fileInfo.IsDir() { // only files have content
-			fileZeroContent := io.LimitReader(readAllZeroes, fileInfo.Size())
+		// WriteFileAtomic() would fail with a cryptic error about the temp file, so check this upfront
+		if err := assertParentDirExists(opts.output); err != nil {
+			return err
+		}
 
-			// adding buffered writer (with 1 MB buffer size) does not improve compression ratio.
-			// this implies there's already optimal buffering going on.
-			if _, err := io.Copy(objectInZip, fileZeroContent); err != nil {
-				return withErr(err)
+		output := opts.output
+		extra := &extraVolumes{output: opts.output}
+		if opts.SplitSize > 0 {
+			output = volumeName(opts.output, 1)
+			opts.NextVolume = extra.next
+		}
+		if opts.SplitByTopLevel {
+			output = topLevelArchiveName(opts.output, topLevelRestName)
+			opts.TopLevelOutput = extra.topLevel
+		}
+
+		// the temp file is "<output>.part" (as are the extra volumes'), i.e. on the same filesystem as
+		// the output, so the final rename is atomic and can't fail with EXDEV
+		return osutil.WriteFileAtomic(output, func(file io.Writer) error {
+			err := func() error {
+				var err error
+				stats, err = produce(ctx, file, opts.Options)
+				if err != nil && stats.Interrupted {
+					if opts.keepPartial || opts.StateFile != "" {
+						interruptedBy = err
+						return nil // the partial archive is valid, so let it get renamed to its final name
+					}
+
+					return fmt.Errorf("%s. discarding partial archive (use --keep-partial to keep it): %w", interruption(), err)
+				}
+				return err
+			}()
+			if err == nil && opts.verifyOutput {
+				err = verifyOutput(output, extra, opts.Options)
 			}
+			if err != nil {
+				extra.discard()
+				return err
+			}
+
+			return extra.commit()
+		})
+	}(); err != nil {
+		return err
+	}
+
+	opts.timedOut = stats.Interrupted && errors.Is(ctx.Err(), context.DeadlineExceeded)
+
+	if opts.Format != skeleton.FormatTree || opts.jsonSummary || opts.DryRun {
+		if err := printSummary(os.Stderr, stats, opts); err != nil {
+			return err
 		}
+	}
 
-		return nil
-	}); err != nil {
-		return fmt.Errorf("zipOneDir: %w", err)
+	if stats.Interrupted {
+		if opts.StateFile != "" {
+			reason := interruption()
+			if ctx.Err() == nil { // failed instead
+				reason = interruptedBy.Error()
+			}
+
+			return fmt.Errorf("%s. the archive is incomplete. run again with --state-file %s to resume", reason, opts.StateFile)
+		}
+
+		return fmt.Errorf("%s. the archive is incomplete", interruption())
+	}
+
+	if stats.Truncated {
+		return &truncatedError{opts.LimitEntries}
+	}
+
+	if len(stats.Errors) > 0 {
+		return &completedWithErrorsError{len(stats.Errors)}
 	}
 
 	return nil
 }
 
-var (
-	// can share this instance
-	readAllZeroes = &nullReader{}
-)
+// lets automation tell "archive is complete except for entries we couldn't read" apart from
+// failing entirely (exit code 1)
+const exitCodeCompletedWithErrors = 2
 
-type nullReader struct{}
+// the archive is valid, but stopped at --limit-entries
+const exitCodeTruncated = 3
 
-var _ io.Reader = (*nullReader)(nil)
+type completedWithErrorsError struct {
+	count int
+}
+
+func (c *completedWithErrorsError) Error() string {
+	return fmt.Sprintf("completed, but with %d error(s). affected entries are missing from the archive", c.count)
+}
+
+type truncatedError struct {
+	limit int
+}
+
+func (t *truncatedError) Error() string {
+	return fmt.Sprintf("stopped at --limit-entries %d. the archive is incomplete", t.limit)
+}
 
-func (n *nullReader) Read(buf []byte) (int, error) {
-	for i := range buf {
-		buf[i] = 0x00
+func exitCodeForError(err error) int {
+	var completedWithErrors *completedWithErrorsError
+	var truncated *truncatedError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &truncated):
+		return exitCodeTruncated
+	case errors.As(err, &completedWithErrors):
+		return exitCodeCompletedWithErrors
+	default:
+		return 1
 	}
+}
 
-	return len(buf), nil
+// cli.Runner only knows exit code 1
+func exitIfSpecialCode(err error) error {
+	if code := exitCodeForError(err); code > 1 {
+		fmt.Fprintf(os.Stderr, "✗ ERROR: %s\n", err.Error())
+		os.Exit(code)
+	}
+
+	return err
+}
+
+func assertParentDirExists(path string) error {
+	parentDir := filepath.Dir(path)
+
+	parentInfo, err := os.Stat(parentDir)
+	switch {
+	case os.IsNotExist(err):
+		return fmt.Errorf("output directory does not exist: %s", parentDir)
+	case err != nil:
+		return err
+	case !parentInfo.IsDir():
+		return fmt.Errorf("output parent is not a directory: %s", parentDir)
+	default:
+		return nil
+	}
+}
+
+// reads back the volumes while they still have their temporary names, so a corrupt archive never
+// gets its final name
+func verifyOutput(output string, extra *extraVolumes, opts skeleton.Options) error {
+	tempNames := []string{output + ".part"} // where WriteFileAtomic() writes to
+	for _, file := range extra.files {
+		tempNames = append(tempNames, file.Name())
+	}
+
+	for _, tempName := range tempNames {
+		if err := skeleton.CheckArchive(tempName, opts); err != nil {
+			return fmt.Errorf("--verify-output: %w", err)
+		}
+	}
+
+	return nil
 }
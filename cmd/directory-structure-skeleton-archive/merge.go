@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/function61/gokit/app/cli"
+	"github.com/joonas-fi/file-structure-skeleton-archive/pkg/skeleton"
+	"github.com/spf13/cobra"
+)
+
+func mergeEntrypoint() *cobra.Command {
+	opts := defaultOptions()
+	prefixBySource := false
+
+	cmd := &cobra.Command{
+		Use:   "merge [archive.zip...] [out.zip]",
+		Short: "Combines skeleton archives (e.g. of several machines) into one",
+		Args:  cobra.MinimumNArgs(2),
+		Run: cli.Runner(func(ctx context.Context, args []string, logger *log.Logger) error {
+			if opts.output != "" {
+				return errors.New("give the output as the last argument instead of --output")
+			}
+			opts.output = args[len(args)-1]
+
+			sources := []skeleton.MergeSource{}
+			for _, archivePath := range args[:len(args)-1] {
+				source := skeleton.MergeSource{Path: archivePath}
+				if prefixBySource {
+					source.Prefix = sourcePrefix(archivePath)
+				}
+
+				sources = append(sources, source)
+			}
+
+			return exitIfSpecialCode(logic(ctx, opts, logger, func(ctx context.Context, w io.Writer, opts skeleton.Options) (skeleton.Stats, error) {
+				return skeleton.Merge(ctx, w, sources, opts)
+			}))
+		}),
+	}
+
+	addOutputFlags(cmd.Flags(), &opts)
+	addFilterFlags(cmd.Flags(), &opts)
+	cmd.Flags().BoolVarP(&prefixBySource, "prefix-by-source", "", prefixBySource, `Put each archive's entries under its name ("host1.zip" => "host1/")`)
+	cmd.Flags().BoolVarP(&opts.Disambiguate, "disambiguate", "", opts.Disambiguate, "If entries would have the same name, suffix the later ones (report.pdf, report-2.pdf, ...) instead of failing")
+
+	return cmd
+}
+
+// "backups/host1.tar.gz" => "host1"
+func sourcePrefix(archivePath string) string {
+	name := filepath.Base(archivePath)
+
+	for _, ext := range []string{".zip", ".tgz", ".gz", ".tar"} {
+		name = strings.TrimSuffix(name, ext)
+	}
+
+	return name
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// reads password from passwordFile, or if not given, prompts for it
+func readPassword(passwordFile string, confirm bool) ([]byte, error) {
+	if passwordFile != "" {
+		content, err := os.ReadFile(passwordFile)
+		if err != nil {
+			return nil, err
+		}
+
+		// editors add trailing newline, and it's unlikely to be intended as part of the password
+		password := strings.TrimRight(string(content), "\r\n")
+		if password == "" {
+			return nil, fmt.Errorf("%s: password is empty", passwordFile)
+		}
+
+		return []byte(password), nil
+	}
+
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, errors.New("no terminal to prompt password from. use --password-file")
+	}
+
+	prompt := func(msg string) ([]byte, error) {
+		fmt.Fprint(os.Stderr, msg)
+		defer fmt.Fprintln(os.Stderr) // the newline typed by the user is not echoed either
+
+		return term.ReadPassword(int(os.Stdin.Fd()))
+	}
+
+	password, err := prompt("Password: ")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(password) == 0 {
+		return nil, errors.New("password is empty")
+	}
+
+	if confirm {
+		again, err := prompt("Password (again): ")
+		if err != nil {
+			return nil, err
+		}
+
+		if !bytes.Equal(password, again) {
+			return nil, errors.New("passwords don't match")
+		}
+	}
+
+	return password, nil
+}
+
+// for opening archives, where the password is only needed if the archive turns out to be encrypted
+func passwordFrom(passwordFile string) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		return readPassword(passwordFile, false)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/function61/gokit/app/cli"
+	"github.com/joonas-fi/file-structure-skeleton-archive/pkg/skeleton"
+	"github.com/spf13/cobra"
+)
+
+func restoreEntrypoint() *cobra.Command {
+	force := false
+	dense := false
+	stripComponents := 0
+	passwordFile := ""
+
+	cmd := &cobra.Command{
+		Use:   "restore [archive.zip...] [dest-dir]",
+		Short: "Recreates the skeleton directory hierarchy on disk, with zero-filled files. Give all volumes of a split archive.",
+		Args:  cobra.MinimumNArgs(2),
+		Run: cli.Runner(func(ctx context.Context, args []string, logger *log.Logger) error {
+			return skeleton.Restore(ctx, args[:len(args)-1], args[len(args)-1], skeleton.RestoreOptions{
+				Force:           force,
+				Dense:           dense,
+				StripComponents: stripComponents,
+				Password:        passwordFrom(passwordFile),
+				Logger:          logger,
+			})
+		}),
+	}
+
+	cmd.Flags().BoolVarP(&force, "force", "", force, "Restore even if destination is a non-empty directory")
+	cmd.Flags().BoolVarP(&dense, "dense", "", dense, "Write files' zeroes to disk, instead of making them sparse (which takes no disk space)")
+	cmd.Flags().IntVarP(&stripComponents, "strip-components", "", stripComponents, "Drop this many leading components from names. Entries with no more components are skipped.")
+	cmd.Flags().StringVarP(&passwordFile, "password-file", "", passwordFile, "Password for an encrypted archive (prompted if not given)")
+
+	return cmd
+}
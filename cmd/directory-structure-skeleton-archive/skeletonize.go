@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+
+	"github.com/function61/gokit/app/cli"
+	"github.com/joonas-fi/file-structure-skeleton-archive/pkg/skeleton"
+	"github.com/spf13/cobra"
+)
+
+func skeletonizeEntrypoint() *cobra.Command {
+	opts := defaultOptions()
+
+	cmd := &cobra.Command{
+		Use:   "skeletonize [archive.zip|archive.tar[.gz]]",
+		Short: `Makes a skeleton out of an existing .zip or .tar archive, without extracting it ("-" reads a tar from stdin)`,
+		Args:  cobra.ExactArgs(1),
+		Run: cli.Runner(func(ctx context.Context, args []string, logger *log.Logger) error {
+			return exitIfSpecialCode(logic(ctx, opts, logger, func(ctx context.Context, w io.Writer, opts skeleton.Options) (skeleton.Stats, error) {
+				return skeleton.Skeletonize(ctx, w, args[0], opts)
+			}))
+		}),
+	}
+
+	addOutputFlags(cmd.Flags(), &opts)
+	addFilterFlags(cmd.Flags(), &opts)
+
+	return cmd
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/function61/gokit/app/byteshuman"
+	"github.com/function61/gokit/app/cli"
+	"github.com/joonas-fi/file-structure-skeleton-archive/pkg/skeleton"
+	"github.com/spf13/cobra"
+)
+
+func statsEntrypoint() *cobra.Command {
+	opts := defaultOptions()
+	asJSON := false
+	largest := 10
+	histogram := false
+
+	cmd := &cobra.Command{
+		Use:   "stats [dir...]",
+		Short: "Summarizes directory trees (counts, sizes by extension, largest files) without writing an archive",
+		Args:  cobra.MinimumNArgs(1),
+		Run: cli.Runner(func(ctx context.Context, args []string, logger *log.Logger) error {
+			return exitIfSpecialCode(stats(ctx, args, opts, asJSON, largest, histogram, logger, os.Stdout))
+		}),
+	}
+
+	cmd.Flags().BoolVarP(&asJSON, "json", "", asJSON, "Output as JSON")
+	cmd.Flags().IntVarP(&largest, "largest", "", largest, "How many of the largest files (and extensions, unless --json) to list")
+	cmd.Flags().BoolVarP(&histogram, "histogram", "", histogram, "Also show how files are distributed by size (0, <1K, <1M, <1G, >=1G)")
+	cmd.Flags().StringVarP(&opts.Progress, "progress", "", opts.Progress, "Running counters on stderr: none|line|bar")
+	addFilterFlags(cmd.Flags(), &opts)
+	addWalkFlags(cmd.Flags(), &opts)
+
+	return cmd
+}
+
+type statsJSON struct {
+	Files       int                 `json:"files"`
+	Directories int                 `json:"directories"`
+	Hardlinks   int                 `json:"hardlinks"` // further links to files, not counted again
+	Bytes       int64               `json:"bytes"`
+	ByExtension []extensionStatJSON `json:"byExtension"` // largest total size first
+	Largest     []fileSizeJSON      `json:"largest"`
+	Histogram   []sizeBucketJSON    `json:"histogram,omitempty"`  // with --histogram
+	Allocation  *allocationJSON     `json:"allocation,omitempty"` // if the platform tells
+	Skipped     map[string]int      `json:"skipped"`              // by reason
+	Errors      []string            `json:"errors"`
+}
+
+type extensionStatJSON struct {
+	Extension string `json:"extension"`
+	Files     int    `json:"files"`
+	Bytes     int64  `json:"bytes"`
+}
+
+type allocationJSON struct {
+	Empty          int   `json:"empty"`
+	Dense          int   `json:"dense"`
+	Sparse         int   `json:"sparse"`
+	SparseBytes    int64 `json:"sparseBytes"`
+	AllocatedBytes int64 `json:"allocatedBytes"`
+}
+
+type sizeBucketJSON struct {
+	Below *int64 `json:"below"` // exclusive upper bound. null for the last bucket
+	Files int    `json:"files"`
+	Bytes int64  `json:"bytes"`
+}
+
+type fileSizeJSON struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// [] instead of null
+func largestFilesJSON(largest []skeleton.FileSize) []fileSizeJSON {
+	largestFiles := []fileSizeJSON{}
+	for _, file := range largest {
+		largestFiles = append(largestFiles, fileSizeJSON{Path: file.Path, Size: file.Size})
+	}
+
+	return largestFiles
+}
+
+func stats(
+	ctx context.Context,
+	dirs []string,
+	opts options,
+	asJSON bool,
+	largest int,
+	histogram bool,
+	logger *log.Logger,
+	output io.Writer,
+) error {
+	if err := opts.parseSizeRange(); err != nil {
+		return err
+	}
+
+	if err := opts.parseAgeRange(time.Now()); err != nil {
+		return err
+	}
+
+	if err := opts.parseRates(); err != nil {
+		return err
+	}
+
+	opts.Logger = logger
+	opts.Quiet = true // listing every path would drown the aggregates
+
+	treeStats, err := skeleton.Summarize(ctx, dirs, opts.Options, largest)
+	if err != nil && !treeStats.Interrupted {
+		return err
+	}
+
+	if asJSON {
+		if err := printStatsJSON(output, treeStats, histogram); err != nil {
+			return err
+		}
+	} else {
+		printStats(output, treeStats, largest, histogram)
+	}
+
+	if treeStats.Interrupted {
+		return errors.New("interrupted. the stats are incomplete")
+	}
+
+	if len(treeStats.Errors) > 0 {
+		return &completedWithErrorsError{len(treeStats.Errors)}
+	}
+
+	return nil
+}
+
+func printStatsJSON(output io.Writer, treeStats skeleton.TreeStats, histogram bool) error {
+	extensions := []extensionStatJSON{}
+	for _, ext := range treeStats.ByExtension {
+		extensions = append(extensions, extensionStatJSON{Extension: ext.Extension, Files: ext.Files, Bytes: ext.Bytes})
+	}
+
+	buckets := []sizeBucketJSON{}
+	for _, bucket := range treeStats.Histogram {
+		bucketJSON := sizeBucketJSON{Files: bucket.Files, Bytes: bucket.Bytes}
+		if bucket.Below != -1 {
+			below := bucket.Below
+			bucketJSON.Below = &below
+		}
+
+		buckets = append(buckets, bucketJSON)
+	}
+	if !histogram {
+		buckets = nil
+	}
+
+	var allocation *allocationJSON
+	if a := treeStats.Allocation; a != nil {
+		allocation = &allocationJSON{Empty: a.Empty, Dense: a.Dense, Sparse: a.Sparse, SparseBytes: a.SparseBytes, AllocatedBytes: a.AllocatedBytes}
+	}
+
+	encoder := json.NewEncoder(output)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(statsJSON{
+		Files:       treeStats.Files,
+		Directories: treeStats.Dirs,
+		Hardlinks:   treeStats.Hardlinks,
+		Bytes:       treeStats.Bytes,
+		ByExtension: extensions,
+		Largest:     largestFilesJSON(treeStats.Largest),
+		Histogram:   buckets,
+		Allocation:  allocation,
+		Skipped:     treeStats.Skipped,
+		Errors:      append([]string{}, treeStats.Errors...), // [] instead of null
+	})
+}
+
+func printStats(output io.Writer, treeStats skeleton.TreeStats, largest int, histogram bool) {
+	hardlinks := ""
+	if treeStats.Hardlinks > 0 {
+		hardlinks = fmt.Sprintf(" (+ %d hardlinks to them, not counted again)", treeStats.Hardlinks)
+	}
+
+	fmt.Fprintf(output, "%d files%s and %d directories, %s in total\n", treeStats.Files, hardlinks, treeStats.Dirs, byteshuman.Humanize(uint64(treeStats.Bytes)))
+
+	if a := treeStats.Allocation; a != nil {
+		fmt.Fprintf(output, "%s allocated on disk. %d empty, %d dense and %d sparse files (%s)\n", byteshuman.Humanize(uint64(a.AllocatedBytes)), a.Empty, a.Dense, a.Sparse, byteshuman.Humanize(uint64(a.SparseBytes)))
+	}
+
+	if total, reasons := summarizeSkipped(treeStats.Skipped); total > 0 {
+		fmt.Fprintf(output, "%d skipped (%s)\n", total, reasons)
+	}
+
+	if len(treeStats.ByExtension) > 0 {
+		fmt.Fprintln(output, "\nBy extension:")
+
+		for i, ext := range treeStats.ByExtension {
+			if i == largest {
+				fmt.Fprintf(output, "  ... and %d more\n", len(treeStats.ByExtension)-largest)
+				break
+			}
+
+			name := ext.Extension
+			if name == "" {
+				name = "(none)"
+			}
+
+			fmt.Fprintf(output, "  %-12s %10d files %12s\n", name, ext.Files, byteshuman.Humanize(uint64(ext.Bytes)))
+		}
+	}
+
+	if len(treeStats.Largest) > 0 {
+		fmt.Fprintln(output, "\nLargest files:")
+
+		for _, file := range treeStats.Largest {
+			fmt.Fprintf(output, "  %12s  %s\n", byteshuman.Humanize(uint64(file.Size)), file.Path)
+		}
+	}
+
+	if histogram {
+		fmt.Fprintln(output, "\nBy size:")
+
+		for i, bucket := range treeStats.Histogram {
+			share := 0.0
+			if treeStats.Bytes > 0 {
+				share = 100 * float64(bucket.Bytes) / float64(treeStats.Bytes)
+			}
+
+			fmt.Fprintf(output, "  %-12s %10d files %12s %5.1f %%\n", sizeBucketLabel(treeStats.Histogram, i), bucket.Files, byteshuman.Humanize(uint64(bucket.Bytes)), share)
+		}
+	}
+}
+
+// "0", "< 1.00 kiB" etc. the last bucket is labeled by its lower bound.
+func sizeBucketLabel(histogram []skeleton.SizeBucket, i int) string {
+	switch {
+	case histogram[i].Below == 1:
+		return "0"
+	case histogram[i].Below == -1:
+		return ">= " + byteshuman.Humanize(uint64(histogram[i-1].Below))
+	default:
+		return "< " + byteshuman.Humanize(uint64(histogram[i].Below))
+	}
+}
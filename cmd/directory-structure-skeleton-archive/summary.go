@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/function61/gokit/app/byteshuman"
+	"github.com/joonas-fi/file-structure-skeleton-archive/pkg/skeleton"
+)
+
+type summaryJSON struct {
+	Files              int            `json:"files"`
+	Directories        int            `json:"directories"`
+	LogicalBytes       int64          `json:"logicalBytes"`
+	ArchiveBytes       int64          `json:"archiveBytes"`
+	DeflateBytes       int64          `json:"deflateBytes,omitempty"` // with other compression, for comparison
+	Volumes            int            `json:"volumes"`
+	CompressionLevel   *int           `json:"compressionLevel,omitempty"` // zip only. the effective level
+	CompressionRatio   float64        `json:"compressionRatio"`           // logical bytes / archive bytes
+	HardlinksCollapsed int            `json:"hardlinksCollapsed"`
+	Truncated          bool           `json:"truncated"` // stopped at --limit-entries
+	TimedOut           bool           `json:"timedOut"`  // cut short by --timeout
+	MerkleRoot         string         `json:"merkleRoot,omitempty"`
+	Largest            []fileSizeJSON `json:"largest,omitempty"`    // with --top
+	Deleted            int            `json:"deleted,omitempty"`    // with --since, entries removed since the previous skeleton
+	Unportable         int            `json:"unportable,omitempty"` // with --check-portability
+	Skipped            map[string]int `json:"skipped"`              // by reason
+	Errors             []string       `json:"errors"`
+}
+
+func printSummary(output io.Writer, stats skeleton.Stats, opts options) error {
+	ratio := func() float64 {
+		if stats.ArchiveBytes == 0 {
+			return 0
+		}
+
+		return float64(stats.LogicalBytes) / float64(stats.ArchiveBytes)
+	}()
+
+	// as zip is the only format with levels
+	compressionLevel := (*int)(nil)
+	if opts.Format == skeleton.FormatZip && !opts.DryRun {
+		compressionLevel = &stats.CompressionLevel
+	}
+
+	if opts.jsonSummary || opts.jsonLog != nil {
+		summary := summaryJSON{
+			Files:              stats.Files,
+			Directories:        stats.Dirs,
+			LogicalBytes:       stats.LogicalBytes,
+			ArchiveBytes:       stats.ArchiveBytes,
+			DeflateBytes:       stats.DeflateBytes,
+			Volumes:            stats.Volumes,
+			CompressionLevel:   compressionLevel,
+			CompressionRatio:   ratio,
+			HardlinksCollapsed: stats.HardlinksCollapsed,
+			Truncated:          stats.Truncated,
+			TimedOut:           opts.timedOut,
+			MerkleRoot:         stats.MerkleRoot,
+			Deleted:            len(stats.Deleted),
+			Unportable:         stats.Unportable,
+			Largest:            largestFilesJSON(stats.Largest),
+			Skipped:            stats.Skipped,
+			Errors:             append([]string{}, stats.Errors...), // [] instead of null
+		}
+
+		if opts.jsonLog != nil {
+			return opts.jsonLog.summary(summary, "summary")
+		}
+
+		return json.NewEncoder(output).Encode(summary)
+	}
+
+	hardlinks := ""
+	if stats.HardlinksCollapsed > 0 {
+		hardlinks = fmt.Sprintf(" %d hardlink(s) were collapsed.", stats.HardlinksCollapsed)
+	}
+
+	volumes := ""
+	if stats.Volumes > 1 {
+		volumes = fmt.Sprintf(" in %d volumes", stats.Volumes)
+	}
+
+	level := ""
+	if compressionLevel != nil && opts.CompressionLevel != skeleton.CompressionLevelDefault {
+		level = fmt.Sprintf(", %s level %d", opts.Compression, *compressionLevel)
+	}
+
+	deflateComparison := ""
+	if stats.DeflateBytes > 0 {
+		deflateComparison = fmt.Sprintf(", vs. %s with deflate", byteshuman.Humanize(uint64(stats.DeflateBytes)))
+	}
+
+	skipped := ""
+	if total, reasons := summarizeSkipped(stats.Skipped); total > 0 {
+		skipped = fmt.Sprintf(" %d entries were skipped (%s).", total, reasons)
+	}
+	if len(opts.Since) > 0 && stats.Deleted != nil {
+		skipped += fmt.Sprintf(" %d entries were removed since the previous skeleton.", len(stats.Deleted))
+	}
+
+	if stats.Unportable > 0 {
+		skipped += fmt.Sprintf(" %d names aren't portable to Windows.", stats.Unportable)
+	}
+
+	fingerprint := ""
+	if stats.MerkleRoot != "" {
+		fingerprint = " Merkle root: " + stats.MerkleRoot
+	}
+
+	truncated := ""
+	switch {
+	case stats.Truncated:
+		truncated = fmt.Sprintf(" Stopped at --limit-entries %d, so the archive is INCOMPLETE.", opts.LimitEntries)
+	case opts.timedOut:
+		truncated = fmt.Sprintf(" Cut short by --timeout %s, so the archive is INCOMPLETE.", opts.timeout)
+	}
+
+	if opts.DryRun { // there's no archive to describe
+		_, err := fmt.Fprintf(
+			output,
+			"%d files and %d directories representing %s would be archived (dry run, nothing was written).%s%s\n",
+			stats.Files,
+			stats.Dirs,
+			byteshuman.Humanize(uint64(stats.LogicalBytes)),
+			skipped,
+			strings.ReplaceAll(truncated, "the archive is", "the list is"))
+		return err
+	}
+
+	_, err := fmt.Fprintf(
+		output,
+		"%d files and %d directories representing %s were archived as %s%s (compression ratio %.2f:1%s%s).%s%s%s%s\n",
+		stats.Files,
+		stats.Dirs,
+		byteshuman.Humanize(uint64(stats.LogicalBytes)),
+		byteshuman.Humanize(uint64(stats.ArchiveBytes)),
+		volumes,
+		ratio,
+		level,
+		deflateComparison,
+		hardlinks,
+		skipped,
+		truncated,
+		fingerprint)
+	if err != nil {
+		return err
+	}
+
+	if len(stats.Largest) > 0 {
+		fmt.Fprintln(output, "Largest files:")
+		for _, file := range stats.Largest {
+			fmt.Fprintf(output, "  %12s  %s\n", byteshuman.Humanize(uint64(file.Size)), file.Path)
+		}
+	}
+
+	if len(stats.Errors) > 0 {
+		fmt.Fprintf(output, "%d error(s) were skipped:\n", len(stats.Errors))
+		for _, entryErr := range stats.Errors {
+			fmt.Fprintf(output, "  %s\n", entryErr)
+		}
+	}
+
+	return nil
+}
+
+// => 3, "size: 2, age: 1"
+func summarizeSkipped(skipped map[string]int) (int, string) {
+	reasons := []string{}
+	for reason := range skipped {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+
+	total := 0
+	reasonCounts := []string{}
+	for _, reason := range reasons {
+		total += skipped[reason]
+		reasonCounts = append(reasonCounts, fmt.Sprintf("%s: %d", reason, skipped[reason]))
+	}
+
+	return total, strings.Join(reasonCounts, ", ")
+}
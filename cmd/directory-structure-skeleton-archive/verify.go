@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/function61/gokit/app/cli"
+	"github.com/joonas-fi/file-structure-skeleton-archive/pkg/skeleton"
+	"github.com/spf13/cobra"
+)
+
+func verifyEntrypoint() *cobra.Command {
+	asJSON := false
+	opts := skeleton.VerifyOptions{}
+	passwordFile := ""
+
+	cmd := &cobra.Command{
+		Use:   "verify [archive.zip...] [dir]",
+		Short: "Compares a skeleton archive (all volumes, if split) to a live directory. Exits non-zero if they differ.",
+		Args:  cobra.MinimumNArgs(2),
+		Run: cli.Runner(func(ctx context.Context, args []string, _ *log.Logger) error {
+			opts.Password = passwordFrom(passwordFile)
+
+			return verify(ctx, args[:len(args)-1], args[len(args)-1], opts, asJSON, os.Stdout)
+		}),
+	}
+
+	cmd.Flags().BoolVarP(&asJSON, "json", "", asJSON, "Output differences as JSON")
+	cmd.Flags().BoolVarP(&opts.HashContent, "hash", "", opts.HashContent, "Also compare content hashes (archive must have been made with --hash)")
+	cmd.Flags().StringVarP(&opts.RelativeTo, "relative-to", "", opts.RelativeTo, "Same as when archiving")
+	cmd.Flags().StringVarP(&opts.Prefix, "prefix", "", opts.Prefix, "Same as when archiving")
+	cmd.Flags().StringVarP(&passwordFile, "password-file", "", passwordFile, "Password for an encrypted archive (prompted if not given)")
+
+	return cmd
+}
+
+func verify(ctx context.Context, archivePaths []string, dir string, opts skeleton.VerifyOptions, asJSON bool, output io.Writer) error {
+	diffs, err := skeleton.Verify(ctx, archivePaths, dir, opts)
+	if err != nil {
+		return err
+	}
+
+	if err := printDifferences(output, diffs, asJSON); err != nil {
+		return err
+	}
+
+	if n := diffs.Count(); n > 0 {
+		return fmt.Errorf("%d difference(s) found", n)
+	}
+
+	return nil
+}
+
+func printDifferences(output io.Writer, diffs skeleton.Differences, asJSON bool) error {
+	if asJSON {
+		return json.NewEncoder(output).Encode(diffs)
+	}
+
+	for _, name := range diffs.Removed {
+		fmt.Fprintf(output, "- %s\n", name)
+	}
+
+	for _, name := range diffs.Added {
+		fmt.Fprintf(output, "+ %s\n", name)
+	}
+
+	for _, delta := range diffs.Changed {
+		fmt.Fprintf(output, "~ %s: %s %s -> %s\n", delta.Path, delta.Field, delta.Old, delta.New)
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// volumes after the first one (which is written with WriteFileAtomic() like an unsplit archive).
+// they get their final names only once the whole archive is done.
+type extraVolumes struct {
+	output string
+	files  []*os.File
+}
+
+func (e *extraVolumes) next(number int) (io.Writer, error) {
+	return e.create(volumeName(e.output, number))
+}
+
+// with --split-by-top-level
+func (e *extraVolumes) topLevel(subdir string) (io.Writer, error) {
+	if subdir == topLevelRestName {
+		return nil, fmt.Errorf("top-level directory %q would get the same archive name as the files directly in the root", subdir)
+	}
+
+	return e.create(topLevelArchiveName(e.output, subdir))
+}
+
+func (e *extraVolumes) create(name string) (io.Writer, error) {
+	file, err := os.Create(name + ".part")
+	if err != nil {
+		return nil, err
+	}
+
+	e.files = append(e.files, file)
+
+	return file, nil
+}
+
+func (e *extraVolumes) commit() error {
+	for _, file := range e.files {
+		// a top-level directory's archive was closed already when it was finished
+		if err := file.Close(); err != nil && !errors.Is(err, os.ErrClosed) {
+			return err
+		}
+
+		if err := os.Rename(file.Name(), strings.TrimSuffix(file.Name(), ".part")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *extraVolumes) discard() {
+	for _, file := range e.files {
+		file.Close()
+		os.Remove(file.Name())
+	}
+}
+
+// for the files directly in the root, with --split-by-top-level
+const topLevelRestName = "root"
+
+// "out.zip", "tenant" => "out-tenant.zip"
+func topLevelArchiveName(output string, subdir string) string {
+	ext := filepath.Ext(output)
+
+	return fmt.Sprintf("%s-%s%s", strings.TrimSuffix(output, ext), subdir, ext)
+}
+
+// "out.zip", 2 => "out.002.zip". zero-padded so that "out.*.zip" expands in order.
+func volumeName(output string, number int) string {
+	ext := filepath.Ext(output)
+
+	return fmt.Sprintf("%s.%03d%s", strings.TrimSuffix(output, ext), number, ext)
+}
@@ -1,15 +1,19 @@
 module github.com/joonas-fi/file-structure-skeleton-archive
 
-go 1.18
+go 1.20
 
 require (
 	github.com/function61/gokit v0.0.0-20230206130116-7988167114d0
+	github.com/klauspost/compress v1.16.7
+	github.com/pkg/xattr v0.4.4
 	github.com/spf13/cobra v1.6.1
+	github.com/spf13/pflag v1.0.5
+	golang.org/x/crypto v0.33.0
+	golang.org/x/sys v0.30.0
+	golang.org/x/term v0.29.0
+	golang.org/x/text v0.22.0
+	golang.org/x/time v0.5.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
-require (
-	github.com/inconshreveable/mousetrap v1.0.1 // indirect
-	github.com/pkg/xattr v0.4.4 // indirect
-	github.com/spf13/pflag v1.0.5 // indirect
-	golang.org/x/sys v0.0.0-20201101102859-da207088b7d1 // indirect
-)
+require github.com/inconshreveable/mousetrap v1.0.1 // indirect
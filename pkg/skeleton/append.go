@@ -0,0 +1,61 @@
+package skeleton
+
+import (
+	"context"
+	"fmt"
+)
+
+// visits the entries of an existing skeleton as-is (filters, Prefix etc. are only for the new
+// entries), and then walked's. archive/zip can't append in-place, so the archive gets rebuilt, but
+// the existing skeleton's roots don't have to be walked again (or even be available anymore).
+//
+// directories that are in both are merged. other entries with the same name are an error.
+func appendSource(existingPath string, walked entrySource) entrySource {
+	return func(ctx context.Context, state *walkState, opts Options, visit func(entry) error) error {
+		existing := map[string]bool{}
+
+		a := &archiveWalker{
+			ctx:        ctx,
+			sourcePath: existingPath,
+			state:      state,
+			opts:       Options{Prefix: ".", MaxSize: -1}, // i.e. no filters
+			visit: func(e entry) error {
+				existing[e.name] = true
+				return visit(e)
+			},
+		}
+
+		if err := a.run(); err != nil {
+			return fmt.Errorf("%s: %w", existingPath, err)
+		}
+
+		return walked(ctx, state, opts, func(e entry) error {
+			if existing[e.name] {
+				if e.fileInfo.IsDir() {
+					state.stats.uncount(e.fileInfo)
+					return nil
+				}
+
+				return fmt.Errorf("%s is already in %s", e.name, existingPath)
+			}
+
+			return visit(e)
+		})
+	}
+}
+
+// of the existing skeleton, from its manifest. none if it doesn't have one.
+func appendedRoots(existingPath string) ([]string, error) {
+	archive, err := openArchives([]string{existingPath}, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	manifest, err := readManifest(archive.File)
+	if err != nil || manifest == nil {
+		return nil, err
+	}
+
+	return manifest.Roots, nil
+}
@@ -0,0 +1,82 @@
+package skeleton
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// CheckArchive reads back every entry of an archive that was written with opts (which tell the
+// fill pattern and the password), to catch a corrupt archive. archive/zip checks the sizes and
+// CRCs while reading, and we check that file content is the fill pattern.
+func CheckArchive(archivePath string, opts Options) error {
+	fill, err := parseFillPattern(opts.FillByte)
+	if err != nil {
+		return err
+	}
+
+	archive, err := openArchive(archivePath, func() ([]byte, error) { return opts.Password, nil })
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	trailer, err := findZipTrailer(archive.File)
+	if err != nil {
+		return fmt.Errorf("%s: %s: %w", archivePath, manifestName, err)
+	}
+
+	for _, entry := range archive.File {
+		if err := checkEntry(entry, fill, trailer); err != nil {
+			return fmt.Errorf("%s: %s: %w", archivePath, entry.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func checkEntry(entry *zip.File, fill []byte, trailer zipTrailer) error {
+	content, err := entry.Open()
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	_, isHardlink := findExtraField(entry.Extra, extraFieldHardlink)
+
+	if trailer.has(entry) || !entry.Mode().IsRegular() || isHardlink { // content isn't fill
+		_, err := io.Copy(io.Discard, content) // still read, so the size and CRC get checked
+		return err
+	}
+
+	// the sample is real content, so there's nothing to compare it against
+	if _, err := io.CopyN(io.Discard, content, entrySampleLen(entry)); err != nil {
+		return err
+	}
+
+	expected := newFillReader(fill)
+	actualBuf := make([]byte, 32*1024)
+	expectedBuf := make([]byte, len(actualBuf))
+
+	for {
+		n, err := io.ReadFull(content, actualBuf)
+		if n > 0 {
+			if _, errExpected := io.ReadFull(expected, expectedBuf[:n]); errExpected != nil {
+				return errExpected
+			}
+
+			if !bytes.Equal(actualBuf[:n], expectedBuf[:n]) {
+				return fmt.Errorf("content is not the fill pattern")
+			}
+		}
+
+		switch err {
+		case nil:
+		case io.EOF, io.ErrUnexpectedEOF:
+			return nil
+		default:
+			return err
+		}
+	}
+}
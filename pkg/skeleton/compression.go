@@ -0,0 +1,187 @@
+package skeleton
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	CompressionDeflate = "deflate"
+	CompressionZstd    = "zstd" // compresses the fill much better, but not all unzip tools support it
+)
+
+// not in archive/zip, but assigned in APPNOTE.TXT (4.4.5)
+const zipMethodZstd uint16 = 93
+
+// what archive/zip's deflate uses
+const zipDeflateLevel = 5
+
+// zstd's own default (its CLI's -3), i.e. zstd.SpeedDefault
+const zstdDefaultLevel = 3
+
+// CompressionLevelDefault leaves the level to the compression method's default
+const CompressionLevelDefault = -1
+
+// level in the compression's own scale: 0-9 for deflate, 1-22 for zstd
+func validateCompressionLevel(compression string, level int) error {
+	switch {
+	case level == CompressionLevelDefault:
+		return nil
+	case compression == CompressionZstd && (level < 1 || level > 22):
+		return fmt.Errorf("zstd compression level must be 1-22, got %d", level)
+	case compression == CompressionDeflate && (level < flate.NoCompression || level > flate.BestCompression):
+		return fmt.Errorf("deflate compression level must be 0-9, got %d", level)
+	default:
+		return nil
+	}
+}
+
+// resolves CompressionLevelDefault, for recording what was actually used
+func effectiveCompressionLevel(compression string, level int) int {
+	switch {
+	case level != CompressionLevelDefault:
+		return level
+	case compression == CompressionZstd:
+		return zstdDefaultLevel
+	default:
+		return zipDeflateLevel
+	}
+}
+
+func zipMethod(compression string) uint16 {
+	if compression == CompressionZstd {
+		return zipMethodZstd
+	}
+
+	return zip.Deflate
+}
+
+// what entries' content took compressed, and with other than deflate, what it would've taken
+// with deflate (for comparison)
+type compressedSizes struct {
+	compressed int64
+	deflated   int64
+}
+
+// registers the compressors for compression and level. compressed content sizes are added to sizes.
+func newZipWriter(w io.Writer, compression string, level int, sizes *compressedSizes) *zip.Writer {
+	zipWriter := zip.NewWriter(w)
+
+	switch {
+	case compression == CompressionZstd:
+		zipWriter.RegisterCompressor(zipMethodZstd, func(w io.Writer) (io.WriteCloser, error) {
+			return newZstdCompressor(&addingWriter{w: w, total: &sizes.compressed}, level)
+		})
+	case level != CompressionLevelDefault: // archive/zip's own is at zipDeflateLevel
+		flateWriters := &sync.Pool{}
+
+		zipWriter.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+			return newDeflateCompressor(w, level, flateWriters)
+		})
+	}
+
+	return zipWriter
+}
+
+// like archive/zip's, flate writers are pooled since they're expensive to make (~1 MB each)
+func newDeflateCompressor(w io.Writer, level int, pool *sync.Pool) (io.WriteCloser, error) {
+	if compressor, ok := pool.Get().(*flate.Writer); ok {
+		compressor.Reset(w)
+		return &pooledFlateWriter{compressor, pool}, nil
+	}
+
+	compressor, err := flate.NewWriter(w, level)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pooledFlateWriter{compressor, pool}, nil
+}
+
+type pooledFlateWriter struct {
+	*flate.Writer
+	pool *sync.Pool
+}
+
+func (p *pooledFlateWriter) Close() error {
+	err := p.Writer.Close()
+	p.pool.Put(p.Writer)
+	return err
+}
+
+// for the compressions we write
+func registerDecompressors(zipReader *zip.Reader) {
+	zipReader.RegisterDecompressor(zipMethodZstd, func(r io.Reader) io.ReadCloser {
+		decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return io.NopCloser(&errorReader{err})
+		}
+
+		return decoder.IOReadCloser()
+	})
+}
+
+// a compressor for deflated size of content, for comparing against other compression
+func newDeflateCounter(sizes *compressedSizes) io.WriteCloser {
+	compressor, _ := flate.NewWriter(&addingWriter{w: io.Discard, total: &sizes.deflated}, zipDeflateLevel) // error only for invalid level
+	return compressor
+}
+
+// encoders are expensive to make, and there's one per entry. encoders keep their level, so
+// there's a pool per level.
+var zstdEncoders = sync.Map{} // zstd.EncoderLevel => *sync.Pool
+
+func newZstdCompressor(w io.Writer, level int) (io.WriteCloser, error) {
+	encoderLevel := zstd.EncoderLevelFromZstd(effectiveCompressionLevel(CompressionZstd, level))
+
+	poolAny, _ := zstdEncoders.LoadOrStore(encoderLevel, &sync.Pool{})
+	pool := poolAny.(*sync.Pool)
+
+	if encoder, ok := pool.Get().(*zstd.Encoder); ok {
+		encoder.Reset(w)
+		return &pooledZstdEncoder{encoder, pool}, nil
+	}
+
+	encoder, err := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1), zstd.WithEncoderLevel(encoderLevel))
+	if err != nil {
+		return nil, err
+	}
+
+	return &pooledZstdEncoder{encoder, pool}, nil
+}
+
+type pooledZstdEncoder struct {
+	*zstd.Encoder
+	pool *sync.Pool
+}
+
+func (p *pooledZstdEncoder) Close() error {
+	err := p.Encoder.Close()
+	p.pool.Put(p.Encoder)
+	return err
+}
+
+// adds bytes written through it to a total shared by many writers
+type addingWriter struct {
+	w     io.Writer
+	total *int64
+}
+
+func (a *addingWriter) Write(buf []byte) (int, error) {
+	n, err := a.w.Write(buf)
+	*a.total += int64(n)
+	return n, err
+}
+
+type errorReader struct {
+	err error
+}
+
+func (e *errorReader) Read([]byte) (int, error) {
+	return 0, e.err
+}
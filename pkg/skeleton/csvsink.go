@@ -0,0 +1,43 @@
+package skeleton
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+)
+
+// one row per entry (path,size,mode,modified,isDir), e.g. for opening in a spreadsheet. streamed,
+// so memory use stays flat.
+type csvSink struct {
+	writer *csv.Writer
+}
+
+func newCSVSink(file io.Writer) (*csvSink, error) {
+	writer := csv.NewWriter(file)
+	writer.UseCRLF = true // RFC 4180
+
+	if err := writer.Write([]string{"path", "size", "mode", "modified", "isDir"}); err != nil {
+		return nil, err
+	}
+
+	return &csvSink{writer: writer}, nil
+}
+
+func (c *csvSink) Entry(e entry) error {
+	m := newManifestEntry(e)
+
+	// csv.Writer buffers, so errors are reported by later writes or at latest by Close()
+	return c.writer.Write([]string{
+		m.Path,
+		strconv.FormatInt(m.Size, 10),
+		m.Mode,
+		m.Modified.Format(time.RFC3339Nano),
+		strconv.FormatBool(m.IsDir),
+	})
+}
+
+func (c *csvSink) Close() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
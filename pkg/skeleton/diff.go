@@ -0,0 +1,24 @@
+package skeleton
+
+// Diff compares two skeleton archives, e.g. periodic skeletons of the same tree. password is asked
+// only if an archive is encrypted. if the new one is incremental (on top of the old one), it's
+// compared as the tree it represents.
+func Diff(oldArchivePath string, newArchivePath string, password func() ([]byte, error)) (Differences, error) {
+	old, err := readArchiveMetadata([]string{oldArchivePath}, password)
+	if err != nil {
+		return Differences{}, err
+	}
+
+	new, err := readArchiveMetadata([]string{newArchivePath}, password)
+	if err != nil {
+		return Differences{}, err
+	}
+
+	if incremental, err := readArchiveIncremental(newArchivePath, password); err != nil {
+		return Differences{}, err
+	} else if incremental != nil {
+		new = overlayIncremental(old, new, incremental.Deleted)
+	}
+
+	return compareMetadata(old, new), nil
+}
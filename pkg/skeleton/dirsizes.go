@@ -0,0 +1,118 @@
+package skeleton
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"strings"
+)
+
+// SubtreeSize is the totals of a directory's whole subtree (like "$ du" would report, but of logical sizes)
+type SubtreeSize struct {
+	Files int   `json:"files" yaml:"files"` // non-directories
+	Bytes int64 `json:"bytes" yaml:"bytes"`
+}
+
+// needs all entries, since a directory precedes its children
+func addSubtreeSizes(entries []entry) {
+	dirs := map[string]*SubtreeSize{} // by archive name
+	for i := range entries {
+		if entries[i].fileInfo.IsDir() {
+			entries[i].subtree = &SubtreeSize{}
+			dirs[entries[i].name] = entries[i].subtree
+		}
+	}
+
+	for _, e := range entries {
+		if e.fileInfo.IsDir() {
+			continue
+		}
+
+		for _, parent := range archiveParentDirs(e.name) {
+			if subtree, found := dirs[parent]; found {
+				subtree.Files++
+				subtree.Bytes += e.fileInfo.Size()
+			}
+		}
+	}
+}
+
+// ChildCounts is a directory's fan-out
+type ChildCounts struct {
+	Children    int `json:"children" yaml:"children"`       // immediate
+	Descendants int `json:"descendants" yaml:"descendants"` // whole subtree, incl. directories
+}
+
+// needs all entries, like addSubtreeSizes()
+func addChildCounts(entries []entry) {
+	dirs := map[string]*ChildCounts{} // by archive name
+	for i := range entries {
+		if entries[i].fileInfo.IsDir() {
+			entries[i].childCounts = &ChildCounts{}
+			dirs[entries[i].name] = entries[i].childCounts
+		}
+	}
+
+	for _, e := range entries {
+		parents := archiveParentDirs(e.name)
+
+		for i, parent := range parents {
+			if counts, found := dirs[parent]; found {
+				counts.Descendants++
+				if i == len(parents)-1 {
+					counts.Children++
+				}
+			}
+		}
+	}
+}
+
+// "a/b/c" => ["a/", "a/b/"] (archive names of directories)
+func archiveParentDirs(name string) []string {
+	parents := []string{}
+
+	for i := range name {
+		if name[i] == '/' && i < len(name)-1 {
+			parents = append(parents, name[:i+1])
+		}
+	}
+
+	return parents
+}
+
+func encodeSubtreeExtraField(subtree SubtreeSize) []byte {
+	data := make([]byte, 16)
+	binary.LittleEndian.PutUint64(data[0:8], uint64(subtree.Files))
+	binary.LittleEndian.PutUint64(data[8:16], uint64(subtree.Bytes))
+	return data
+}
+
+func encodeChildCountsExtraField(counts ChildCounts) []byte {
+	data := make([]byte, 16)
+	binary.LittleEndian.PutUint64(data[0:8], uint64(counts.Children))
+	binary.LittleEndian.PutUint64(data[8:16], uint64(counts.Descendants))
+	return data
+}
+
+func entryChildCounts(entry *zip.File) *ChildCounts {
+	data, found := findExtraField(entry.Extra, extraFieldChildCounts)
+	if !found || len(data) < 16 || !strings.HasSuffix(entry.Name, "/") {
+		return nil
+	}
+
+	return &ChildCounts{
+		Children:    int(binary.LittleEndian.Uint64(data[0:8])),
+		Descendants: int(binary.LittleEndian.Uint64(data[8:16])),
+	}
+}
+
+func entrySubtreeSize(entry *zip.File) *SubtreeSize {
+	data, found := findExtraField(entry.Extra, extraFieldSubtree)
+	if !found || len(data) < 16 || !strings.HasSuffix(entry.Name, "/") {
+		return nil
+	}
+
+	return &SubtreeSize{
+		Files: int(binary.LittleEndian.Uint64(data[0:8])),
+		Bytes: int64(binary.LittleEndian.Uint64(data[8:16])),
+	}
+}
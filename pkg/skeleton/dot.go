@@ -0,0 +1,65 @@
+package skeleton
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// Graphviz graph of the hierarchy (directories and files as nodes, containment as edges), for
+// rendering with e.g. "$ dot -Tpng". node IDs are the entry names, so they're unique.
+type dotSink struct {
+	output  *bufio.Writer
+	started bool
+}
+
+func newDotSink(output io.Writer) *dotSink {
+	return &dotSink{output: bufio.NewWriter(output)}
+}
+
+func (d *dotSink) Entry(e entry) error {
+	if !d.started {
+		d.start()
+	}
+
+	name := strings.TrimSuffix(e.name, "/")
+
+	shape := "note"
+	if e.fileInfo.IsDir() {
+		shape = "folder"
+	}
+
+	fmt.Fprintf(d.output, "  %s [label=%s, shape=%s];\n", dotQuote(name), dotQuote(path.Base(name)), shape)
+
+	// edges can refer to parents before (or without) their node statement. Graphviz then creates them.
+	if parent := path.Dir(name); parent != "." {
+		fmt.Fprintf(d.output, "  %s -> %s;\n", dotQuote(parent), dotQuote(name))
+	}
+
+	return nil
+}
+
+func (d *dotSink) Close() error {
+	if !d.started { // empty, but still a valid graph
+		d.start()
+	}
+
+	fmt.Fprintln(d.output, "}")
+
+	return d.output.Flush()
+}
+
+func (d *dotSink) start() {
+	fmt.Fprintln(d.output, "digraph skeleton {")
+	fmt.Fprintln(d.output, "  rankdir=LR;")
+	fmt.Fprintln(d.output, `  node [fontname="sans-serif", fontsize=10];`)
+	d.started = true
+}
+
+// DOT's quoted strings only need quotes and backslashes escaped (and newlines, to keep one
+// statement per line)
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
@@ -0,0 +1,82 @@
+package skeleton
+
+import (
+	"sort"
+	"strings"
+)
+
+// Duplicates are groups of files that are (likely) the same content
+type Duplicates struct {
+	ByContent   bool             `json:"byContent"` // grouped by hash as well. if false, only by size, so the groups are just candidates.
+	Groups      []DuplicateGroup `json:"groups"`    // most wasted bytes first
+	WastedBytes int64            `json:"wastedBytes"`
+}
+
+type DuplicateGroup struct {
+	Size        int64    `json:"size"`             // of each file
+	SHA256      string   `json:"sha256,omitempty"` // with ByContent
+	Paths       []string `json:"paths"`            // sorted
+	WastedBytes int64    `json:"wastedBytes"`      // by all but one of the copies
+}
+
+// FindDuplicates groups regular files of the same size and (if all of them have one, i.e.
+// archived with Hash) the same content hash. empty files and hardlinks (which share their
+// target's storage) are not counted as duplicates.
+func FindDuplicates(entries []ManifestEntry) Duplicates {
+	candidates := []ManifestEntry{}
+	byContent := true
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Mode, "-") || entry.Size == 0 || entry.HardlinkTo != "" {
+			continue
+		}
+
+		if entry.SHA256 == "" {
+			byContent = false
+		}
+
+		candidates = append(candidates, entry)
+	}
+
+	type groupKey struct {
+		size   int64
+		sha256 string
+	}
+
+	groups := map[groupKey]*DuplicateGroup{}
+	for _, entry := range candidates {
+		key := groupKey{size: entry.Size}
+		if byContent {
+			key.sha256 = entry.SHA256
+		}
+
+		group, found := groups[key]
+		if !found {
+			group = &DuplicateGroup{Size: key.size, SHA256: key.sha256}
+			groups[key] = group
+		}
+
+		group.Paths = append(group.Paths, entry.Path)
+	}
+
+	dupes := Duplicates{ByContent: byContent, Groups: []DuplicateGroup{}}
+	for _, group := range groups {
+		if len(group.Paths) < 2 {
+			continue
+		}
+
+		sort.Strings(group.Paths)
+		group.WastedBytes = group.Size * int64(len(group.Paths)-1)
+		dupes.WastedBytes += group.WastedBytes
+		dupes.Groups = append(dupes.Groups, *group)
+	}
+
+	sort.Slice(dupes.Groups, func(i, j int) bool {
+		if dupes.Groups[i].WastedBytes != dupes.Groups[j].WastedBytes {
+			return dupes.Groups[i].WastedBytes > dupes.Groups[j].WastedBytes
+		}
+
+		return dupes.Groups[i].Paths[0] < dupes.Groups[j].Paths[0]
+	})
+
+	return dupes
+}
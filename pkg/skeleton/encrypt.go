@@ -0,0 +1,294 @@
+package skeleton
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// stdlib's archive/zip doesn't do encryption (and zip's own encryption doesn't hide entry names
+// anyway), so the whole archive is wrapped in an envelope:
+//
+//	magic | scrypt salt | nonce prefix | chunk | chunk | ...
+//
+// each chunk is AES-256-GCM sealed separately so we can stream. the nonce is the prefix + chunk
+// counter + "is last chunk" flag, so reordering or truncating chunks fails authentication.
+const (
+	encryptedMagic             = "DSSAENC\x01"
+	encryptedSaltLen           = 16
+	encryptedNoncePrefixLen    = 7
+	encryptedChunkSize         = 64 * 1024
+	encryptedHeaderLen         = len(encryptedMagic) + encryptedSaltLen + encryptedNoncePrefixLen
+	encryptedChunkOverheadSize = 16 // GCM tag
+)
+
+type encryptingWriter struct {
+	output      io.Writer
+	aead        cipher.AEAD
+	noncePrefix []byte
+	counter     uint32
+	buf         []byte
+}
+
+// writes the envelope header immediately. Close() must be called to write the last chunk.
+func newEncryptingWriter(output io.Writer, password []byte) (*encryptingWriter, error) {
+	header := make([]byte, encryptedHeaderLen)
+	copy(header, encryptedMagic)
+	if _, err := rand.Read(header[len(encryptedMagic):]); err != nil {
+		return nil, err
+	}
+
+	salt := header[len(encryptedMagic) : len(encryptedMagic)+encryptedSaltLen]
+
+	aead, err := newEnvelopeAEAD(password, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := output.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &encryptingWriter{
+		output:      output,
+		aead:        aead,
+		noncePrefix: header[len(encryptedMagic)+encryptedSaltLen:],
+		buf:         make([]byte, 0, encryptedChunkSize),
+	}, nil
+}
+
+func (e *encryptingWriter) Write(data []byte) (int, error) {
+	written := 0
+
+	for len(data) > 0 {
+		// only flush a full buffer once we know more data follows, because the last chunk must
+		// be flagged as such (and it's only known to be last in Close())
+		if len(e.buf) == encryptedChunkSize {
+			if err := e.flush(false); err != nil {
+				return written, err
+			}
+		}
+
+		n := copy(e.buf[len(e.buf):encryptedChunkSize], data)
+		e.buf = e.buf[:len(e.buf)+n]
+		data = data[n:]
+		written += n
+	}
+
+	return written, nil
+}
+
+func (e *encryptingWriter) Close() error {
+	return e.flush(true)
+}
+
+func (e *encryptingWriter) flush(last bool) error {
+	sealed := e.aead.Seal(nil, envelopeNonce(e.noncePrefix, e.counter, last), e.buf, nil)
+
+	if _, err := e.output.Write(sealed); err != nil {
+		return err
+	}
+
+	e.counter++
+	e.buf = e.buf[:0]
+
+	return nil
+}
+
+func isEncryptedArchive(header []byte) bool {
+	return bytes.HasPrefix(header, []byte(encryptedMagic))
+}
+
+// the whole plaintext is kept in memory, because archive/zip needs random access. skeleton
+// archives are small compared to the trees they represent, so this should be fine.
+func decryptArchive(ciphertext []byte, password []byte) ([]byte, error) {
+	if len(ciphertext) < encryptedHeaderLen || !isEncryptedArchive(ciphertext) {
+		return nil, errors.New("not an encrypted archive")
+	}
+
+	salt := ciphertext[len(encryptedMagic) : len(encryptedMagic)+encryptedSaltLen]
+	noncePrefix := ciphertext[len(encryptedMagic)+encryptedSaltLen : encryptedHeaderLen]
+
+	aead, err := newEnvelopeAEAD(password, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext := []byte{}
+
+	chunks := ciphertext[encryptedHeaderLen:]
+	for counter := uint32(0); ; counter++ {
+		chunkLen := encryptedChunkSize + encryptedChunkOverheadSize
+		last := len(chunks) <= chunkLen
+		if last {
+			chunkLen = len(chunks)
+		}
+
+		plaintext, err = aead.Open(plaintext, envelopeNonce(noncePrefix, counter, last), chunks[:chunkLen], nil)
+		if err != nil {
+			return nil, errors.New("decryption failed: wrong password or corrupted archive")
+		}
+
+		if last {
+			return plaintext, nil
+		}
+
+		chunks = chunks[chunkLen:]
+	}
+}
+
+func newEnvelopeAEAD(password []byte, salt []byte) (cipher.AEAD, error) {
+	// N=2^15 is the interactive-use recommendation
+	key, err := scrypt.Key(password, salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func envelopeNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, encryptedNoncePrefixLen+4+1)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[encryptedNoncePrefixLen:], counter)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+
+	return nonce
+}
+
+// a zip archive that possibly had to be decrypted first
+type openedArchive struct {
+	*zip.Reader
+	close func() error
+}
+
+func (o *openedArchive) Close() error {
+	return o.close()
+}
+
+// password is only asked if the archive is encrypted
+func openArchive(archivePath string, password func() ([]byte, error)) (*openedArchive, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, len(encryptedMagic))
+	if _, err := io.ReadFull(file, header); err != nil || !isEncryptedArchive(header) {
+		file.Close()
+
+		archive, err := zip.OpenReader(archivePath)
+		if err != nil {
+			return nil, err
+		}
+		registerDecompressors(&archive.Reader)
+
+		return &openedArchive{&archive.Reader, archive.Close}, nil
+	}
+	file.Close()
+
+	if password == nil {
+		return nil, fmt.Errorf("%s: archive is encrypted, but no password given", archivePath)
+	}
+
+	passwordBytes, err := password()
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext, err := os.ReadFile(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := decryptArchive(ciphertext, passwordBytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", archivePath, err)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(plaintext), int64(len(plaintext)))
+	if err != nil {
+		return nil, err
+	}
+	registerDecompressors(archive)
+
+	return &openedArchive{archive, func() error { return nil }}, nil
+}
+
+// volumes of a (possibly split) archive, as one
+type openedArchives struct {
+	File    []*zip.File // entries of all volumes, in order
+	trailer zipTrailer  // of all volumes. the manifest is the last volume's
+	volumes []*openedArchive
+}
+
+func (o *openedArchives) Close() error {
+	var firstErr error
+	for _, volume := range o.volumes {
+		if err := volume.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// password is asked at most once, even if there are many encrypted volumes
+func openArchives(archivePaths []string, password func() ([]byte, error)) (*openedArchives, error) {
+	var knownPassword []byte
+	passwordOnce := password
+	if password != nil {
+		passwordOnce = func() ([]byte, error) {
+			if knownPassword == nil {
+				var err error
+				if knownPassword, err = password(); err != nil {
+					return nil, err
+				}
+			}
+
+			return knownPassword, nil
+		}
+	}
+
+	archives := &openedArchives{trailer: zipTrailer{entries: map[*zip.File]bool{}}}
+
+	for _, archivePath := range archivePaths {
+		volume, err := openArchive(archivePath, passwordOnce)
+		if err != nil {
+			archives.Close()
+			return nil, err
+		}
+
+		archives.volumes = append(archives.volumes, volume)
+		archives.File = append(archives.File, volume.File...)
+
+		volumeTrailer, err := findZipTrailer(volume.File)
+		if err != nil {
+			archives.Close()
+			return nil, fmt.Errorf("%s: %s: %w", archivePath, manifestName, err)
+		}
+
+		for file := range volumeTrailer.entries {
+			archives.trailer.entries[file] = true
+		}
+		archives.trailer.manifest = volumeTrailer.manifest
+	}
+
+	return archives, nil
+}
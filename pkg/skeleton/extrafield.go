@@ -0,0 +1,184 @@
+package skeleton
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"time"
+)
+
+// IDs for zip extra fields that carry metadata zip has no native support for. picked so they
+// don't collide with IDs listed in APPNOTE.TXT or Info-ZIP's extrafld.txt
+const (
+	extraFieldHardlink    uint16 = 0x6c68 // "hl". data: name of the entry this is a hardlink to
+	extraFieldSHA256      uint16 = 0x6873 // "hs". data: SHA-256 digest of the file's real content
+	extraFieldTimes       uint16 = 0x6e74 // "tn". data: mtime (optionally followed by atime and ctime), see encodeTimesExtraField() (zip's own timestamps have 1-2 s resolution)
+	extraFieldXattrs      uint16 = 0x7861 // "xa". data: extended attributes, see encodeXattrsExtraField()
+	extraFieldOwner       uint16 = 0x6e6f // "on". data: owner user and group names, see encodeOwnerNamesExtraField()
+	extraFieldDevice      uint16 = 0x7664 // "dv". data: device node's major and minor as uint32s
+	extraFieldContentType uint16 = 0x7463 // "ct". data: MIME type detected from the file's real content
+	extraFieldSubtree     uint16 = 0x7564 // "du". data: directory's subtree totals, see encodeSubtreeExtraField()
+	extraFieldChildCounts uint16 = 0x6e63 // "cn". data: directory's child and descendant counts, see encodeChildCountsExtraField()
+	extraFieldSample      uint16 = 0x6d73 // "sm". data: uint32 length of the real content sample the entry's content starts with
+	extraFieldFileAttrs   uint16 = 0x6166 // "fa". data: uint32 Linux inode flags (immutable, append-only etc.), see fileAttrsRecorded
+	extraFieldLogicalSize uint16 = 0x7a73 // "sz". data: uint64 size of the original file, regardless of how much content the entry stores (e.g. none for a hardlink)
+	extraFieldAllocated   uint16 = 0x6261 // "ab". data: uint64 bytes the original file had allocated on disk (less than its size for sparse files)
+
+	// not ours, but Info-ZIP's (the "new" Unix extra field)
+	extraFieldUnixOwner uint16 = 0x7875 // "ux". data: UID and GID
+)
+
+// extra field layout is a sequence of (header ID uint16, data size uint16, data)
+func appendExtraField(extra []byte, id uint16, data []byte) []byte {
+	header := make([]byte, 4)
+	binary.LittleEndian.PutUint16(header[0:2], id)
+	binary.LittleEndian.PutUint16(header[2:4], uint16(len(data)))
+
+	return append(append(extra, header...), data...)
+}
+
+func encodeDeviceExtraField(major uint32, minor uint32) []byte {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint32(data[0:4], major)
+	binary.LittleEndian.PutUint32(data[4:8], minor)
+	return data
+}
+
+func decodeDeviceExtraField(data []byte) (uint32, uint32, bool) {
+	if len(data) < 8 {
+		return 0, 0, false
+	}
+
+	return binary.LittleEndian.Uint32(data[0:4]), binary.LittleEndian.Uint32(data[4:8]), true
+}
+
+func encodeSampleExtraField(length int) []byte {
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, uint32(length))
+	return data
+}
+
+// 0 if the content is all fill
+func entrySampleLen(entry *zip.File) int64 {
+	data, found := findExtraField(entry.Extra, extraFieldSample)
+	if !found || len(data) < 4 {
+		return 0
+	}
+
+	return int64(binary.LittleEndian.Uint32(data[0:4]))
+}
+
+func encodeLogicalSizeExtraField(size int64) []byte {
+	data := make([]byte, 8)
+	binary.LittleEndian.PutUint64(data, uint64(size))
+	return data
+}
+
+// with WithAllocation, if the source platform had it
+func entryAllocatedBytes(entry *zip.File) (int64, bool) {
+	data, found := findExtraField(entry.Extra, extraFieldAllocated)
+	if !found || len(data) < 8 {
+		return 0, false
+	}
+
+	return int64(binary.LittleEndian.Uint64(data)), true
+}
+
+// the original file's size. falls back to the stored content's size if our extra field is not
+// present (e.g. made by an older version), which then is the same.
+func entryLogicalSize(entry *zip.File) int64 {
+	data, found := findExtraField(entry.Extra, extraFieldLogicalSize)
+	if !found || len(data) < 8 {
+		return int64(entry.UncompressedSize64)
+	}
+
+	return int64(binary.LittleEndian.Uint64(data))
+}
+
+// each time is int64 Unix seconds followed by uint32 nanoseconds, so that any year fits (Unix
+// nanoseconds in an int64 would only cover 1678-2262). accessed and changed are stored only if
+// accessed is non-zero.
+func encodeTimesExtraField(modified time.Time, accessed time.Time, changed time.Time) []byte {
+	times := []time.Time{modified}
+	if !accessed.IsZero() {
+		times = append(times, accessed, changed)
+	}
+
+	data := make([]byte, timeSize*len(times))
+	for i, t := range times {
+		binary.LittleEndian.PutUint64(data[i*timeSize:], uint64(t.Unix()))
+		binary.LittleEndian.PutUint32(data[i*timeSize+8:], uint32(t.Nanosecond()))
+	}
+	return data
+}
+
+const (
+	timeSize       = 12 // int64 seconds and uint32 nanoseconds
+	legacyTimeSize = 8  // int64 Unix nanoseconds, as written by older versions
+)
+
+// the i'th time of the field. false if it doesn't have that many.
+func decodeTimesExtraField(data []byte, i int) (time.Time, bool) {
+	size := timeSize
+	switch len(data) {
+	case timeSize, 3 * timeSize:
+	case legacyTimeSize, 3 * legacyTimeSize:
+		size = legacyTimeSize
+	default: // not present, or corrupt
+		return time.Time{}, false
+	}
+
+	if (i+1)*size > len(data) {
+		return time.Time{}, false
+	}
+
+	if size == legacyTimeSize {
+		return time.Unix(0, int64(binary.LittleEndian.Uint64(data[i*size:]))).UTC(), true
+	}
+
+	seconds := int64(binary.LittleEndian.Uint64(data[i*size:]))
+	nanoseconds := int64(binary.LittleEndian.Uint32(data[i*size+8:]))
+	return time.Unix(seconds, nanoseconds).UTC(), true
+}
+
+// only present if recorded with AllTimes
+func entryAccessChangeTimes(entry *zip.File) (time.Time, time.Time, bool) {
+	data, _ := findExtraField(entry.Extra, extraFieldTimes)
+
+	accessed, found := decodeTimesExtraField(data, 1)
+	if !found {
+		return time.Time{}, time.Time{}, false
+	}
+
+	changed, _ := decodeTimesExtraField(data, 2)
+	return accessed, changed, true
+}
+
+// falls back to zip's own (less precise) modification time if our extra field is not present
+func entryModified(entry *zip.File) (time.Time, bool) {
+	data, _ := findExtraField(entry.Extra, extraFieldTimes)
+
+	modified, found := decodeTimesExtraField(data, 0)
+	if !found {
+		return entry.Modified, false
+	}
+
+	return modified, true
+}
+
+func findExtraField(extra []byte, id uint16) ([]byte, bool) {
+	for len(extra) >= 4 {
+		fieldID := binary.LittleEndian.Uint16(extra[0:2])
+		size := int(binary.LittleEndian.Uint16(extra[2:4]))
+		if len(extra) < 4+size { // corrupt
+			return nil, false
+		}
+
+		if fieldID == id {
+			return extra[4 : 4+size], true
+		}
+
+		extra = extra[4+size:]
+	}
+
+	return nil, false
+}
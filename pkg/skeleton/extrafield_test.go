@@ -0,0 +1,166 @@
+package skeleton
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// checks that each of the archive's files has the logical size extra field, with the expected size
+func assertLogicalSizes(t *testing.T, archivePath string, expected map[string]int64) {
+	t.Helper()
+
+	archive, err := zip.OpenReader(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+
+	for _, file := range archive.File {
+		size, isFile := expected[file.Name]
+		if !isFile {
+			continue
+		}
+		delete(expected, file.Name)
+
+		if _, found := findExtraField(file.Extra, extraFieldLogicalSize); !found {
+			t.Fatalf("%s: no logical size extra field", file.Name)
+		}
+
+		assertEqual(t, entryLogicalSize(file), size)
+	}
+
+	if len(expected) > 0 {
+		t.Fatalf("not in archive: %v", expected)
+	}
+
+	destDir := restoreTestArchive(t, archivePath)
+	for _, entry := range listTestArchive(t, archivePath) {
+		if entry.IsDir {
+			continue
+		}
+
+		info, err := os.Stat(filepath.Join(destDir, filepath.FromSlash(entry.Path)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if info.Size() != entry.Size {
+			t.Fatalf("%s: restored as %d bytes, expected %d", entry.Path, info.Size(), entry.Size)
+		}
+	}
+}
+
+func TestLogicalSizeRegardlessOfContent(t *testing.T) {
+	fsys := fstest.MapFS{
+		"root/small": testFile("tiny"),
+		"root/large": testFile(strings.Repeat("x", 10000)),
+	}
+
+	for _, tc := range []struct {
+		name      string
+		configure func(opts *Options)
+	}{
+		{"zeroes", func(opts *Options) {}},
+		{"sample", func(opts *Options) { opts.SampleBytes = 16 }},
+		{"real content", func(opts *Options) { opts.KeepContentBelow = 100 }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			tc.configure(&opts)
+
+			archivePath, _ := archiveTestFS(t, fsys, []string{"root"}, opts)
+			assertLogicalSizes(t, archivePath, map[string]int64{
+				"root/small": 4,
+				"root/large": 10000,
+			})
+		})
+	}
+}
+
+// the second link stores no content, so its size is only in the extra field
+func TestLogicalSizeOfHardlink(t *testing.T) {
+	if !fileIDsSupported {
+		t.Skip("hardlinks aren't detected on this platform")
+	}
+
+	root := filepath.Join(t.TempDir(), "root")
+	if err := os.Mkdir(root, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "a"), []byte(strings.Repeat("x", 1234)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(root, "a"), filepath.Join(root, "b")); err != nil {
+		t.Skipf("can't create hardlinks: %v", err)
+	}
+
+	archivePath := archiveTestDirs(t, []string{root}, DefaultOptions())
+
+	assertEqual(t, entryByPath(t, listTestArchive(t, archivePath), "root/b").HardlinkTo, "root/a")
+	assertLogicalSizes(t, archivePath, map[string]int64{
+		"root/a": 1234,
+		"root/b": 1234,
+	})
+}
+
+func TestTimesExtraFieldOutsideUnixNanoRange(t *testing.T) {
+	modified := time.Date(1600, 1, 2, 3, 4, 5, 123456789, time.UTC)
+	accessed := time.Date(2500, 6, 7, 8, 9, 10, 987654321, time.UTC)
+	changed := time.Date(1970, 1, 1, 0, 0, 0, 1, time.UTC)
+
+	entry := &zip.File{FileHeader: zip.FileHeader{
+		Extra: appendExtraField(nil, extraFieldTimes, encodeTimesExtraField(modified, accessed, changed)),
+	}}
+
+	gotModified, found := entryModified(entry)
+	assertEqual(t, found, true)
+	assertEqual(t, gotModified, modified)
+
+	gotAccessed, gotChanged, found := entryAccessChangeTimes(entry)
+	assertEqual(t, found, true)
+	assertEqual(t, gotAccessed, accessed)
+	assertEqual(t, gotChanged, changed)
+
+	fsys := fstest.MapFS{
+		"root/old.txt": &fstest.MapFile{Data: []byte("x"), ModTime: modified},
+		"root/new.txt": &fstest.MapFile{Data: []byte("x"), ModTime: accessed},
+	}
+	archivePath, _ := archiveTestFS(t, fsys, []string{"root"}, DefaultOptions())
+
+	entries := listTestArchive(t, archivePath)
+	assertEqual(t, entryByPath(t, entries, "root/old.txt").Modified.Equal(modified), true)
+	assertEqual(t, entryByPath(t, entries, "root/new.txt").Modified.Equal(accessed), true)
+}
+
+// as written by older versions: int64 Unix nanoseconds
+func TestLegacyTimesExtraField(t *testing.T) {
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	accessed := time.Date(2021, 1, 2, 3, 4, 5, 6, time.UTC)
+
+	legacy := func(times ...time.Time) *zip.File {
+		data := make([]byte, 8*len(times))
+		for i, t := range times {
+			binary.LittleEndian.PutUint64(data[i*8:], uint64(t.UnixNano()))
+		}
+
+		return &zip.File{FileHeader: zip.FileHeader{Extra: appendExtraField(nil, extraFieldTimes, data)}}
+	}
+
+	gotModified, found := entryModified(legacy(modified))
+	assertEqual(t, found, true)
+	assertEqual(t, gotModified, modified)
+
+	_, _, found = entryAccessChangeTimes(legacy(modified))
+	assertEqual(t, found, false)
+
+	gotAccessed, gotChanged, found := entryAccessChangeTimes(legacy(modified, accessed, accessed))
+	assertEqual(t, found, true)
+	assertEqual(t, gotAccessed, accessed)
+	assertEqual(t, gotChanged, accessed)
+}
@@ -0,0 +1,304 @@
+package skeleton
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"time"
+)
+
+// entries held in memory before a sorted run of them is spilled to a temp file. an entry takes a
+// few hundred bytes, so this bounds the sorting to some tens of MBs regardless of the tree's size.
+// a variable only so that tests can spill small trees.
+var sortRunEntries = 100000
+
+// sorts entries by name (for Reproducible) with an external merge sort: entries are buffered and
+// sorted in runs of sortRunEntries, which (if there are more than one) are spilled to temp files
+// and merged when reading back. a tree that fits one run never touches the disk.
+type entrySorter struct {
+	run     []entry
+	spilled []*os.File // sorted runs
+}
+
+func (s *entrySorter) add(e entry) error {
+	if s.run == nil {
+		s.run = make([]entry, 0, sortRunEntries)
+	}
+
+	s.run = append(s.run, e)
+
+	if len(s.run) < sortRunEntries {
+		return nil
+	}
+
+	return s.spill()
+}
+
+func (s *entrySorter) spill() error {
+	sortEntriesByName(s.run)
+
+	file, err := os.CreateTemp("", "skeleton-sort-*.jsonl")
+	if err != nil {
+		return err
+	}
+	s.spilled = append(s.spilled, file) // before anything can fail, so that close() removes it
+
+	buffered := bufio.NewWriter(file)
+	encoder := json.NewEncoder(buffered)
+	for _, e := range s.run {
+		if err := encoder.Encode(newSpilledEntry(e)); err != nil {
+			return err
+		}
+	}
+	if err := buffered.Flush(); err != nil {
+		return err
+	}
+
+	for i := range s.run { // so the spilled ones' names etc. can be collected
+		s.run[i] = entry{}
+	}
+	s.run = s.run[:0]
+
+	return nil
+}
+
+// visits the entries in sorted order
+func (s *entrySorter) sorted(visit func(entry) error) error {
+	if len(s.spilled) == 0 {
+		sortEntriesByName(s.run)
+
+		for _, e := range s.run {
+			if err := visit(e); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if len(s.run) > 0 {
+		if err := s.spill(); err != nil {
+			return err
+		}
+	}
+
+	runs := &mergedRuns{}
+	for _, file := range s.spilled {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		r := &spilledRun{decoder: json.NewDecoder(bufio.NewReader(file))}
+		if ok, err := r.next(); err != nil {
+			return err
+		} else if ok {
+			runs.runs = append(runs.runs, r)
+		}
+	}
+	heap.Init(runs)
+
+	for runs.Len() > 0 {
+		r := runs.runs[0]
+		if err := visit(r.head); err != nil {
+			return err
+		}
+
+		if ok, err := r.next(); err != nil {
+			return err
+		} else if ok {
+			heap.Fix(runs, 0)
+		} else {
+			heap.Pop(runs)
+		}
+	}
+
+	return nil
+}
+
+// removes the temp files
+func (s *entrySorter) close() {
+	for _, file := range s.spilled {
+		file.Close()
+		os.Remove(file.Name())
+	}
+	s.spilled = nil
+}
+
+func sortEntriesByName(entries []entry) {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+}
+
+// one of the sorted runs being merged
+type spilledRun struct {
+	decoder *json.Decoder
+	head    entry // smallest not yet visited
+}
+
+// false at the end of the run
+func (r *spilledRun) next() (bool, error) {
+	var spilled spilledEntry
+	if err := r.decoder.Decode(&spilled); err != nil {
+		if err == io.EOF {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	r.head = spilled.entry()
+	return true, nil
+}
+
+// min-heap of the runs by their heads
+type mergedRuns struct {
+	runs []*spilledRun
+}
+
+func (m *mergedRuns) Len() int           { return len(m.runs) }
+func (m *mergedRuns) Less(i, j int) bool { return m.runs[i].head.name < m.runs[j].head.name }
+func (m *mergedRuns) Swap(i, j int)      { m.runs[i], m.runs[j] = m.runs[j], m.runs[i] }
+func (m *mergedRuns) Push(x interface{}) { m.runs = append(m.runs, x.(*spilledRun)) }
+func (m *mergedRuns) Pop() interface{} {
+	last := m.runs[len(m.runs)-1]
+	m.runs = m.runs[:len(m.runs)-1]
+	return last
+}
+
+// entry as stored in a temp file. fileInfo.Sys() is kept (where the sinks use it), so that
+// hardlinks, owners and device numbers are still known after reading back. names are []byte
+// because JSON strings would replace bytes that aren't UTF-8, which names can have.
+type spilledEntry struct {
+	Path           []byte         `json:"path"`
+	Name           []byte         `json:"name"`
+	FileName       []byte         `json:"fileName"` // fileInfo.Name()
+	Size           int64          `json:"size"`
+	Mode           fs.FileMode    `json:"mode"`
+	Modified       time.Time      `json:"modified"`
+	Sys            *spilledSys    `json:"sys,omitempty"`
+	SHA256         []byte         `json:"sha256,omitempty"`
+	Sample         []byte         `json:"sample,omitempty"`
+	Content        []byte         `json:"content,omitempty"`    // of entry.content, read when spilling as the source can't be kept open
+	ContentErr     string         `json:"contentErr,omitempty"` // if reading it failed, reported when writing like a failed open
+	ContentType    string         `json:"contentType,omitempty"`
+	Xattrs         []spilledXattr `json:"xattrs,omitempty"`
+	SymlinkTarget  []byte         `json:"symlinkTarget,omitempty"`
+	HardlinkTarget []byte         `json:"hardlinkTarget,omitempty"`
+	Accessed       time.Time      `json:"accessed"`
+	Changed        time.Time      `json:"changed"`
+	FileAttrs      uint32         `json:"fileAttrs,omitempty"`
+	Allocated      *int64         `json:"allocated,omitempty"`
+	RecordedExtra  []byte         `json:"recordedExtra,omitempty"`
+}
+
+type spilledXattr struct {
+	Name  []byte `json:"name"`
+	Value []byte `json:"value"`
+}
+
+// subtree and childCounts aren't kept, as those features need all entries in memory anyway
+func newSpilledEntry(e entry) spilledEntry {
+	xattrs := []spilledXattr{}
+	for _, xattr := range e.xattrs {
+		xattrs = append(xattrs, spilledXattr{Name: []byte(xattr.name), Value: xattr.value})
+	}
+
+	content, contentErr := []byte(nil), ""
+	if e.content != nil {
+		var err error
+		if content, err = readContent(e); err != nil {
+			contentErr = err.Error()
+		}
+	}
+
+	return spilledEntry{
+		Path:           []byte(e.path),
+		Name:           []byte(e.name),
+		FileName:       []byte(e.fileInfo.Name()),
+		Size:           e.fileInfo.Size(),
+		Mode:           e.fileInfo.Mode(),
+		Modified:       e.fileInfo.ModTime(),
+		Sys:            spillSys(e.fileInfo),
+		SHA256:         e.sha256,
+		Sample:         e.sample,
+		Content:        content,
+		ContentErr:     contentErr,
+		ContentType:    e.contentType,
+		Xattrs:         xattrs,
+		SymlinkTarget:  []byte(e.symlinkTarget),
+		HardlinkTarget: []byte(e.hardlinkTarget),
+		Accessed:       e.accessed,
+		Changed:        e.changed,
+		FileAttrs:      e.fileAttrs,
+		Allocated:      e.allocated,
+		RecordedExtra:  e.recordedExtra,
+	}
+}
+
+func (s spilledEntry) entry() entry {
+	xattrs := []extendedAttribute(nil)
+	for _, xattr := range s.Xattrs {
+		xattrs = append(xattrs, extendedAttribute{name: string(xattr.Name), value: xattr.Value})
+	}
+
+	content := (func() (io.ReadCloser, error))(nil)
+	switch {
+	case s.ContentErr != "":
+		content = func() (io.ReadCloser, error) { return nil, errors.New(s.ContentErr) }
+	case s.Content != nil:
+		content = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(s.Content)), nil }
+	}
+
+	return entry{
+		path:           string(s.Path),
+		name:           string(s.Name),
+		fileInfo:       &spilledFileInfo{s},
+		sha256:         s.SHA256,
+		sample:         s.Sample,
+		content:        content,
+		contentType:    s.ContentType,
+		xattrs:         xattrs,
+		symlinkTarget:  string(s.SymlinkTarget),
+		hardlinkTarget: string(s.HardlinkTarget),
+		accessed:       s.Accessed,
+		changed:        s.Changed,
+		fileAttrs:      s.FileAttrs,
+		allocated:      s.Allocated,
+		recordedExtra:  s.RecordedExtra,
+	}
+}
+
+// a file kept whole is smaller than KeepContentBelow, so this is bounded
+func readContent(e entry) ([]byte, error) {
+	file, err := e.content()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return io.ReadAll(io.LimitReader(file, e.fileInfo.Size()))
+}
+
+type spilledFileInfo struct {
+	spilled spilledEntry
+}
+
+var _ fs.FileInfo = (*spilledFileInfo)(nil)
+
+func (s *spilledFileInfo) Name() string       { return string(s.spilled.FileName) }
+func (s *spilledFileInfo) Size() int64        { return s.spilled.Size }
+func (s *spilledFileInfo) Mode() fs.FileMode  { return s.spilled.Mode }
+func (s *spilledFileInfo) ModTime() time.Time { return s.spilled.Modified }
+func (s *spilledFileInfo) IsDir() bool        { return s.spilled.Mode.IsDir() }
+func (s *spilledFileInfo) Sys() interface{} {
+	if s.spilled.Sys == nil { // not a typed nil, which the type assertions would take for a stat
+		return nil
+	}
+
+	return s.spilled.Sys
+}
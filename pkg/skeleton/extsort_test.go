@@ -0,0 +1,309 @@
+package skeleton
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"path"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+	"unsafe"
+)
+
+type testFileInfo struct {
+	name string
+	size int64
+}
+
+func (t testFileInfo) Name() string       { return t.name }
+func (t testFileInfo) Size() int64        { return t.size }
+func (t testFileInfo) Mode() fs.FileMode  { return 0o644 }
+func (t testFileInfo) ModTime() time.Time { return testModTime }
+func (t testFileInfo) IsDir() bool        { return false }
+func (t testFileInfo) Sys() interface{}   { return nil }
+
+func withSortRunEntries(t *testing.T, n int) {
+	previous := sortRunEntries
+	sortRunEntries = n
+	t.Cleanup(func() { sortRunEntries = previous })
+}
+
+func sortedNames(t *testing.T, sorter *entrySorter) []string {
+	t.Helper()
+
+	names := []string{}
+	if err := sorter.sorted(func(e entry) error {
+		names = append(names, e.name)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	return names
+}
+
+func TestEntrySorterKeepsNamesThatArentUTF8(t *testing.T) {
+	withSortRunEntries(t, 2) // so that they get spilled
+
+	sorter := &entrySorter{}
+	defer sorter.close()
+
+	for _, name := range []string{"bad\xffname", "z", "a", "bad\xfename", "\xc3"} {
+		if err := sorter.add(entry{name: name, path: "/src/" + name, fileInfo: testFileInfo{name: name}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(sorter.spilled) == 0 {
+		t.Fatal("expected runs to be spilled")
+	}
+
+	// byte order, like the in-memory sort
+	assertEqual(t, sortedNames(t, sorter), []string{"a", "bad\xfename", "bad\xffname", "z", "\xc3"})
+}
+
+func TestReproducibleArchiveIsSameWhenSpilled(t *testing.T) {
+	fsys := fstest.MapFS{}
+	for i := 0; i < 300; i++ {
+		fsys[fmt.Sprintf("root/dir%d/file%03d.txt", i%7, (i*37)%300)] = testFile(fmt.Sprintf("content %d", i))
+	}
+	fsys["root/bad\xffname"] = testFile("x")
+
+	opts := DefaultOptions()
+	opts.Reproducible = true
+
+	inMemory := bytes.Buffer{}
+	if _, err := ArchiveFS(context.Background(), &inMemory, fsys, []string{"root"}, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	withSortRunEntries(t, 16)
+
+	spilled := bytes.Buffer{}
+	if _, err := ArchiveFS(context.Background(), &spilled, fsys, []string{"root"}, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(inMemory.Bytes(), spilled.Bytes()) {
+		t.Fatal("archives differ")
+	}
+}
+
+func TestEntrySorterMillionEntries(t *testing.T) {
+	if testing.Short() {
+		t.Skip("takes a while")
+	}
+
+	const entries = 1000000
+
+	names := make([]string, 0, entries)
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < entries; i++ {
+		names = append(names, fmt.Sprintf("dir%04d/file%08x", random.Intn(10000), random.Uint32()))
+	}
+
+	sorter := &entrySorter{}
+	defer sorter.close()
+
+	peakHeap := uint64(0)
+	memStats := runtime.MemStats{}
+
+	for i, name := range names {
+		if err := sorter.add(entry{name: name, path: "/src/" + name, fileInfo: testFileInfo{name: name, size: int64(i)}}); err != nil {
+			t.Fatal(err)
+		}
+
+		if i%(entries/10) == entries/10-1 { // just before a spill, when the run is at its largest
+			runtime.GC()
+			runtime.ReadMemStats(&memStats)
+			if memStats.HeapAlloc > peakHeap {
+				peakHeap = memStats.HeapAlloc
+			}
+		}
+	}
+
+	// names (~40 MB) are held by the test, the rest should be about one run's worth
+	namesSize := uint64(entries * (unsafe.Sizeof("") + 20))
+	runSize := uint64(sortRunEntries) * uint64(unsafe.Sizeof(entry{})+unsafe.Sizeof(testFileInfo{})+40)
+	if limit := namesSize + 3*runSize; peakHeap > limit {
+		t.Fatalf("peak heap %d MB, expected at most %d MB", peakHeap>>20, limit>>20)
+	}
+
+	if len(sorter.spilled) < entries/sortRunEntries-1 {
+		t.Fatalf("expected runs to be spilled, got %d", len(sorter.spilled))
+	}
+
+	expected := append([]string{}, names...)
+	sort.Strings(expected)
+
+	sizes := 0
+	got := []string{}
+	if err := sorter.sorted(func(e entry) error {
+		got = append(got, e.name)
+		if e.fileInfo.Name() == e.name && names[e.fileInfo.Size()] == e.name {
+			sizes++
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != entries {
+		t.Fatalf("expected %d entries, got %d", entries, len(got))
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("entry %d: expected %s, got %s", i, expected[i], got[i])
+		}
+	}
+	assertEqual(t, sizes, entries)
+}
+
+// a tree of dirs*filesPerDir files generated as it's walked, as a MapFS that big would take most
+// of the memory being measured (and lists a directory by going through all of its files)
+type generatedFS struct {
+	dirs        int
+	filesPerDir int
+}
+
+var _ fs.ReadDirFS = generatedFS{}
+
+type generatedFileInfo struct {
+	name  string
+	isDir bool
+}
+
+func (g generatedFileInfo) Name() string       { return g.name }
+func (g generatedFileInfo) Size() int64        { return int64(len(g.name)) }
+func (g generatedFileInfo) ModTime() time.Time { return testModTime }
+func (g generatedFileInfo) IsDir() bool        { return g.isDir }
+func (g generatedFileInfo) Sys() interface{}   { return nil }
+
+func (g generatedFileInfo) Mode() fs.FileMode {
+	if g.isDir {
+		return fs.ModeDir | 0o755
+	}
+	return 0o644
+}
+
+// the root is "root", its dirs "dir0000" etc. and their files "file00000000" etc.
+func (g generatedFS) stat(name string) (fs.FileInfo, error) {
+	parts := strings.Split(name, "/")
+	switch {
+	case name == "." || name == "root":
+		return generatedFileInfo{name: path.Base(name), isDir: true}, nil
+	case parts[0] != "root":
+	case len(parts) == 2 && strings.HasPrefix(parts[1], "dir"):
+		return generatedFileInfo{name: parts[1], isDir: true}, nil
+	case len(parts) == 3 && strings.HasPrefix(parts[2], "file"):
+		return generatedFileInfo{name: parts[2]}, nil
+	}
+
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (g generatedFS) Open(name string) (fs.File, error) {
+	info, err := g.stat(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return generatedFile{info}, nil
+}
+
+func (g generatedFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	info, err := g.stat(name)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []fs.DirEntry{}
+	switch {
+	case name == ".":
+		entries = append(entries, fs.FileInfoToDirEntry(generatedFileInfo{name: "root", isDir: true}))
+	case name == "root":
+		for i := 0; i < g.dirs; i++ {
+			entries = append(entries, fs.FileInfoToDirEntry(generatedFileInfo{name: fmt.Sprintf("dir%04d", i), isDir: true}))
+		}
+	case info.IsDir():
+		for i := 0; i < g.filesPerDir; i++ {
+			entries = append(entries, fs.FileInfoToDirEntry(generatedFileInfo{name: fmt.Sprintf("file%08d", i)}))
+		}
+	default:
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+
+	return entries, nil
+}
+
+type generatedFile struct {
+	info fs.FileInfo
+}
+
+func (g generatedFile) Stat() (fs.FileInfo, error) { return g.info, nil }
+func (g generatedFile) Read([]byte) (int, error)   { return 0, io.EOF }
+func (g generatedFile) Close() error               { return nil }
+
+// peak heap while archiving a generated tree of dirs*1000 files with Reproducible
+func reproduciblePeakHeap(t *testing.T, dirs int) uint64 {
+	opts := DefaultOptions()
+	opts.Format = FormatTar // a zip's central directory grows with the entries, a tar has none
+	opts.Reproducible = true
+
+	done := make(chan struct{})
+	peak := make(chan uint64)
+	go func() {
+		peakHeap := uint64(0)
+		memStats := runtime.MemStats{}
+		ticker := time.NewTicker(50 * time.Millisecond)
+		defer ticker.Stop()
+
+		for {
+			runtime.GC() // so that only what's live counts
+			runtime.ReadMemStats(&memStats)
+			if memStats.HeapAlloc > peakHeap {
+				peakHeap = memStats.HeapAlloc
+			}
+
+			select {
+			case <-done:
+				peak <- peakHeap
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	stats, err := ArchiveFS(context.Background(), io.Discard, generatedFS{dirs: dirs, filesPerDir: 1000}, []string{"root"}, opts)
+	close(done)
+	peakHeap := <-peak
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEqual(t, stats.Files, dirs*1000)
+
+	return peakHeap
+}
+
+func TestReproducibleArchiveOfMillionEntriesHasBoundedHeap(t *testing.T) {
+	if testing.Short() {
+		t.Skip("takes a while")
+	}
+
+	smallPeak := reproduciblePeakHeap(t, 100)  // 100k entries, about one run
+	largePeak := reproduciblePeakHeap(t, 1000) // 1M entries, spilled in ten runs
+
+	// the entries grew tenfold. held in memory, the heap would too.
+	if largePeak > 3*smallPeak {
+		t.Fatalf("peak heap %d MB for 1M entries, %d MB for 100k", largePeak>>20, smallPeak>>20)
+	}
+}
@@ -0,0 +1,56 @@
+package skeleton
+
+import (
+	"encoding/binary"
+)
+
+// Linux inode flags (as in lsattr/chattr) that document a file's hardening and that restore can
+// reapply. others (like ext4's "extents") are filesystem internals, so they're not recorded.
+const (
+	fileAttrSync      uint32 = 0x00000008 // FS_SYNC_FL, chattr +S
+	fileAttrImmutable uint32 = 0x00000010 // FS_IMMUTABLE_FL, chattr +i
+	fileAttrAppend    uint32 = 0x00000020 // FS_APPEND_FL, chattr +a
+	fileAttrNoDump    uint32 = 0x00000040 // FS_NODUMP_FL, chattr +d
+	fileAttrNoAtime   uint32 = 0x00000080 // FS_NOATIME_FL, chattr +A
+	fileAttrDirSync   uint32 = 0x00010000 // FS_DIRSYNC_FL, chattr +D
+
+	fileAttrsRecorded = fileAttrSync | fileAttrImmutable | fileAttrAppend | fileAttrNoDump | fileAttrNoAtime | fileAttrDirSync
+)
+
+var fileAttrNames = []struct {
+	flag uint32
+	name string
+}{
+	{fileAttrSync, "sync"},
+	{fileAttrImmutable, "immutable"},
+	{fileAttrAppend, "append"},
+	{fileAttrNoDump, "nodump"},
+	{fileAttrNoAtime, "noatime"},
+	{fileAttrDirSync, "dirsync"},
+}
+
+// => ["immutable", "nodump"]
+func describeFileAttrs(flags uint32) []string {
+	names := []string{}
+	for _, attr := range fileAttrNames {
+		if flags&attr.flag != 0 {
+			names = append(names, attr.name)
+		}
+	}
+
+	return names
+}
+
+func encodeFileAttrsExtraField(flags uint32) []byte {
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, flags)
+	return data
+}
+
+func decodeFileAttrsExtraField(data []byte) (uint32, bool) {
+	if len(data) < 4 {
+		return 0, false
+	}
+
+	return binary.LittleEndian.Uint32(data[0:4]) & fileAttrsRecorded, true
+}
@@ -0,0 +1,52 @@
+//go:build linux
+
+package skeleton
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+const fileAttrsSupported = true
+
+// only for regular files and dirs, as opening others could have side effects (e.g. a FIFO or a
+// tape device). 0 if the filesystem doesn't support the flags.
+func readFileAttrs(path string) (uint32, error) {
+	fd, err := unix.Open(path, unix.O_RDONLY|unix.O_NOFOLLOW|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer unix.Close(fd)
+
+	flags, err := unix.IoctlGetUint32(fd, unix.FS_IOC_GETFLAGS)
+	if err != nil {
+		if isFileAttrsUnsupported(err) {
+			return 0, nil
+		}
+
+		return 0, err
+	}
+
+	return flags & fileAttrsRecorded, nil
+}
+
+// keeps the flags we don't record (like ext4's "extents") as the filesystem set them
+func setFileAttrs(path string, flags uint32) error {
+	fd, err := unix.Open(path, unix.O_RDONLY|unix.O_NOFOLLOW|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	current, err := unix.IoctlGetUint32(fd, unix.FS_IOC_GETFLAGS)
+	if err != nil {
+		return err
+	}
+
+	return unix.IoctlSetPointerInt(fd, unix.FS_IOC_SETFLAGS, int(current&^fileAttrsRecorded|flags))
+}
+
+func isFileAttrsUnsupported(err error) bool {
+	return errors.Is(err, unix.ENOTTY) || errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EINVAL)
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package skeleton
+
+import (
+	"errors"
+)
+
+// not supported on this platform
+const fileAttrsSupported = false
+
+// there are none
+func readFileAttrs(path string) (uint32, error) {
+	return 0, nil
+}
+
+func setFileAttrs(path string, flags uint32) error {
+	return errors.New("file attributes are not supported on this platform")
+}
@@ -0,0 +1,21 @@
+//go:build linux
+
+package skeleton
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+const fileTimesSupported = true
+
+// access and (inode) change times
+func getFileTimes(fi fs.FileInfo) (time.Time, time.Time, bool) {
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}, time.Time{}, false
+	}
+
+	return time.Unix(stat.Atim.Unix()), time.Unix(stat.Ctim.Unix()), true
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package skeleton
+
+import (
+	"io/fs"
+	"time"
+)
+
+// not supported on this platform
+const fileTimesSupported = false
+
+func getFileTimes(fi fs.FileInfo) (time.Time, time.Time, bool) {
+	return time.Time{}, time.Time{}, false
+}
@@ -0,0 +1,129 @@
+package skeleton
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// pattern is matched against both the base name and the path relative to the root, so both
+// "*.tmp" and "build/*" style patterns work. a matching directory prunes its whole subtree,
+// so "build/*" effectively excludes everything under "build/".
+// patterns use "/" as separator on all OSes, same as entry names in the archive.
+func matchesAnyPattern(relPath string, patterns []string) bool {
+	relPath = filepath.ToSlash(relPath)
+	base := path.Base(relPath)
+
+	for _, pattern := range patterns {
+		// errors are not possible here as patterns have been validated upfront
+		if matched, _ := path.Match(pattern, base); matched {
+			return true
+		}
+
+		if matched, _ := path.Match(pattern, relPath); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+func validatePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern '%s': %w", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+// skip reasons
+const (
+	skippedBySize      = "size"
+	skippedByAge       = "age"
+	skippedByExtension = "extension"
+	skippedByDirsOnly  = "dirs-only"
+	skippedByEmpty     = "empty-dir"
+	skippedByStripping = "stripped"
+
+	skippedByVanishing = "vanished"
+)
+
+// returns non-empty skip reason if the entry should be left out. directories are never filtered
+// by metadata, so that the hierarchy stays intact.
+func (o Options) metadataFilter(fileInfo fs.FileInfo) string {
+	if fileInfo.IsDir() {
+		return ""
+	}
+
+	// size of e.g. a symlink is its target's length, which isn't interesting
+	if size := fileInfo.Size(); fileInfo.Mode().IsRegular() && (size < o.MinSize || (o.MaxSize >= 0 && size > o.MaxSize)) {
+		return skippedBySize
+	}
+
+	modified := fileInfo.ModTime()
+	if (!o.NewerThan.IsZero() && modified.Before(o.NewerThan)) || (!o.OlderThan.IsZero() && modified.After(o.OlderThan)) {
+		return skippedByAge
+	}
+
+	if o.DirsOnly {
+		return skippedByDirsOnly
+	}
+
+	if ext := fileExtension(fileInfo.Name()); (len(o.Extensions) > 0 && !hasAnyExtension(ext, o.Extensions)) || hasAnyExtension(ext, o.ExcludeExtensions) {
+		return skippedByExtension
+	}
+
+	return ""
+}
+
+// lowercased, with the dot. "" for a dotfile like ".bashrc", as its name is not an extension.
+func fileExtension(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == strings.ToLower(name) {
+		return ""
+	}
+
+	return ext
+}
+
+// extensions can be given with or without the dot, in any case
+func hasAnyExtension(ext string, extensions []string) bool {
+	for _, candidate := range extensions {
+		if ext != "" && strings.EqualFold(ext, "."+strings.TrimPrefix(candidate, ".")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// drops directories that have no non-directory entries anywhere below them
+func pruneEmptyDirs(entries []entry, stats *Stats) []entry {
+	nonEmpty := map[string]bool{}
+	for _, e := range entries {
+		if e.fileInfo.IsDir() {
+			continue
+		}
+
+		for _, parent := range parentDirs(filepath.FromSlash(e.name)) {
+			nonEmpty[archiveName(filepath.ToSlash(parent), true)] = true
+		}
+	}
+
+	kept := []entry{}
+	for _, e := range entries {
+		if e.fileInfo.IsDir() && !nonEmpty[e.name] {
+			stats.Dirs--
+			stats.Skipped[skippedByEmpty]++
+			continue
+		}
+
+		kept = append(kept, e)
+	}
+
+	return kept
+}
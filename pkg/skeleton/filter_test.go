@@ -0,0 +1,64 @@
+package skeleton
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestNoHidden(t *testing.T) {
+	fsys := fstest.MapFS{
+		"root/visible.txt":        testFile("x"),
+		"root/.hidden":            testFile("x"),
+		"root/.git/config":        testFile("x"),
+		"root/sub/.env":           testFile("x"),
+		"root/sub/kept.txt":       testFile("x"),
+		"root/.config/app/a.conf": testFile("x"),
+	}
+
+	opts := DefaultOptions()
+	opts.NoHidden = true
+
+	archivePath, _ := archiveTestFS(t, fsys, []string{"root"}, opts)
+	assertEqual(t, entryPaths(listTestArchive(t, archivePath)), []string{"root", "root/sub", "root/sub/kept.txt", "root/visible.txt"})
+
+	// and without, all of them
+	archivePath, _ = archiveTestFS(t, fsys, []string{"root"}, DefaultOptions())
+	assertEqual(t, len(listTestArchive(t, archivePath)), 11)
+}
+
+func TestExcludePatternsUseForwardSlashes(t *testing.T) {
+	for _, tc := range []struct {
+		relPath string
+		matches bool
+	}{
+		{"sub/debug.log", true},
+		{"sub/nested/debug.log", false},
+		{"other/debug.log", false},
+		{"sub/debug.txt", false},
+		{"other/build", true},
+	} {
+		// relPath comes from the OS walk, so with OS separators
+		assertEqual(t, matchesAnyPattern(filepath.FromSlash(tc.relPath), []string{"sub/*.log", "build"}), tc.matches)
+	}
+}
+
+// entry names (and the paths patterns are matched against) use "/" regardless of OS
+func TestArchiveNamesUseForwardSlashes(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "root")
+	for _, file := range []string{"sub/nested/a.txt", "sub/debug.log", "b.txt"} {
+		file = filepath.Join(root, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opts := DefaultOptions()
+	opts.Excludes = []string{"sub/*.log"}
+
+	assertEqual(t, entryPaths(listTestArchive(t, archiveTestDirs(t, []string{root}, opts))), []string{"root", "root/b.txt", "root/sub", "root/sub/nested", "root/sub/nested/a.txt"})
+}
@@ -0,0 +1,126 @@
+package skeleton
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// optional capability of an input fs.FS. without it symlink targets aren't recorded.
+// (same signature as Go 1.25's fs.ReadLinkFS, so e.g. its os.DirFS() satisfies this as well)
+type readLinkFS interface {
+	ReadLink(name string) (string, error)
+}
+
+// the OS filesystem, rooted at dir. we don't use os.DirFS(), because some metadata (symlink
+// targets, xattrs) need OS paths and with --files-from names can be any OS paths (dir is then "").
+type osDirFS struct {
+	dir            string
+	followSymlinks bool // Stat() follows them, so that WalkDir() descends into a symlinked dir given as its root
+}
+
+var _ interface {
+	fs.StatFS
+	readLinkFS
+} = (*osDirFS)(nil)
+
+func newOSDirFS(dir string) *osDirFS {
+	return &osDirFS{dir: dir}
+}
+
+func (o *osDirFS) Open(name string) (fs.File, error) {
+	return os.Open(o.osPath(name))
+}
+
+// by default doesn't follow symlinks, so that a symlinked root is recorded as a symlink (like
+// filepath.WalkDir() does) instead of being descended into
+func (o *osDirFS) Stat(name string) (fs.FileInfo, error) {
+	if o.followSymlinks {
+		return os.Stat(o.osPath(name))
+	}
+
+	return os.Lstat(o.osPath(name))
+}
+
+func (o *osDirFS) ReadLink(name string) (string, error) {
+	return os.Readlink(o.osPath(name))
+}
+
+func (o *osDirFS) osPath(name string) string {
+	return filepath.Join(o.dir, filepath.FromSlash(name)) // also cleans away "." for the root
+}
+
+// on Windows, paths longer than MAX_PATH (260) make stat and directory listing fail unless they're in
+// the extended-length form ("\\?\C:\..."). a root in that form makes all paths below it (which are
+// joined onto it) extended-length as well. the form is taken literally (no "." or "/" handling),
+// so it has to be absolute and clean.
+func extendedLengthPath(p string) string {
+	if runtime.GOOS != "windows" || strings.HasPrefix(p, `\\?\`) {
+		return p
+	}
+
+	abs, err := filepath.Abs(p) // also cleans
+	if err != nil {
+		return p // let the walk report the problem
+	}
+
+	if strings.HasPrefix(abs, `\\`) { // UNC ("\\server\share")
+		return `\\?\UNC\` + abs[len(`\\`):]
+	}
+
+	return `\\?\` + abs
+}
+
+// for names and messages, which should look like what the user gave us
+func stripExtendedLengthPrefix(p string) string {
+	if runtime.GOOS != "windows" {
+		return p
+	}
+
+	switch {
+	case strings.HasPrefix(p, `\\?\UNC\`):
+		return `\\` + p[len(`\\?\UNC\`):]
+	case strings.HasPrefix(p, `\\?\`):
+		return p[len(`\\?\`):]
+	default:
+		return p
+	}
+}
+
+// like filepath.Rel(), but for fs.FS paths where p is known to be within dir
+func fsRel(dir string, p string) string {
+	switch {
+	case dir == ".":
+		return p
+	case p == dir:
+		return "."
+	default:
+		return strings.TrimPrefix(p, dir+"/")
+	}
+}
+
+func fsIsWithinDir(dir string, p string) bool {
+	return dir == "." || p == dir || strings.HasPrefix(p, dir+"/")
+}
+
+// like rootArchiveName(), but for fs.FS paths (which can't point outside of the FS)
+func fsRootArchiveName(root string, relativeTo string) (string, error) {
+	if relativeTo == "" {
+		if root == "." { // the top level
+			return ".", nil
+		}
+
+		return path.Base(root), nil
+	}
+
+	relativeTo = path.Clean(relativeTo)
+	if !fsIsWithinDir(relativeTo, root) {
+		return "", fmt.Errorf("%s is not under --relative-to %s", root, relativeTo)
+	}
+
+	return fsRel(relativeTo, root), nil
+}
@@ -0,0 +1,184 @@
+package skeleton
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// tracks .gitignore rules that are active for the directory that the walk currently is in.
+// relies on the walk being depth-first: once we see a path that is not under a stack item's
+// directory, we've left that directory for good.
+type gitignoreStack struct {
+	fsys   fs.FS
+	levels []gitignoreLevel
+}
+
+type gitignoreLevel struct {
+	dir   string // in fsys
+	rules []gitignoreRule
+}
+
+type gitignoreRule struct {
+	pattern *regexp.Regexp // matched against slash-separated path relative to the .gitignore's dir
+	negate  bool           // "!pattern" re-includes
+	dirOnly bool           // "pattern/" only matches directories
+}
+
+// path is in fsys
+func (g *gitignoreStack) Ignored(path string, isDir bool) bool {
+	g.popLevelsNotContaining(path)
+
+	ignored := false
+
+	// later rules (and deeper .gitignore files) take precedence, so the last match wins
+	for _, level := range g.levels {
+		relPath := fsRel(level.dir, path)
+
+		for _, rule := range level.rules {
+			if rule.dirOnly && !isDir {
+				continue
+			}
+
+			if rule.pattern.MatchString(relPath) {
+				ignored = !rule.negate
+			}
+		}
+	}
+
+	return ignored
+}
+
+// reads dir's .gitignore (if any) so its rules apply to dir's subtree
+func (g *gitignoreStack) Enter(dir string) error {
+	g.popLevelsNotContaining(dir)
+
+	rules, err := parseGitignore(g.fsys, path.Join(dir, ".gitignore"))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+
+		return err
+	}
+
+	g.levels = append(g.levels, gitignoreLevel{dir, rules})
+
+	return nil
+}
+
+func (g *gitignoreStack) popLevelsNotContaining(path string) {
+	for len(g.levels) > 0 {
+		if fsIsWithinDir(g.levels[len(g.levels)-1].dir, path) {
+			return
+		}
+
+		g.levels = g.levels[:len(g.levels)-1]
+	}
+}
+
+func parseGitignore(fsys fs.FS, name string) ([]gitignoreRule, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	rules := []gitignoreRule{}
+
+	lines := bufio.NewScanner(file)
+	for lines.Scan() {
+		if rule, ok := parseGitignoreLine(lines.Text()); ok {
+			rules = append(rules, rule)
+		}
+	}
+
+	return rules, lines.Err()
+}
+
+// https://git-scm.com/docs/gitignore#_pattern_format
+func parseGitignoreLine(line string) (gitignoreRule, bool) {
+	line = strings.TrimRight(line, " \r")
+
+	if line == "" || strings.HasPrefix(line, "#") {
+		return gitignoreRule{}, false
+	}
+
+	rule := gitignoreRule{}
+
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) { // escapes leading "#" or "!"
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+
+	if line == "" {
+		return gitignoreRule{}, false
+	}
+
+	// a separator at the beginning or middle anchors the pattern to the .gitignore's dir.
+	// otherwise it can match at any level below it.
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	prefix := "^(?:.*/)?"
+	if anchored {
+		prefix = "^"
+	}
+
+	pattern, err := regexp.Compile(prefix + gitignoreGlobToRegexp(line) + "$")
+	if err != nil { // bad character class, probably. git ignores these as well.
+		return gitignoreRule{}, false
+	}
+	rule.pattern = pattern
+
+	return rule, true
+}
+
+func gitignoreGlobToRegexp(glob string) string {
+	re := strings.Builder{}
+
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"): // zero or more directories
+			re.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob): // everything inside
+			re.WriteString("/.*")
+			i += 2
+		case glob[i] == '*':
+			re.WriteString("[^/]*")
+		case glob[i] == '?':
+			re.WriteString("[^/]")
+		case glob[i] == '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end == -1 {
+				re.WriteString(regexp.QuoteMeta(glob[i:]))
+				return re.String()
+			}
+
+			class := glob[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + class + "]")
+			i += end
+		case glob[i] == '\\' && i+1 < len(glob):
+			re.WriteString(regexp.QuoteMeta(glob[i+1 : i+2]))
+			i++
+		default:
+			re.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+
+	return re.String()
+}
@@ -0,0 +1,25 @@
+package skeleton
+
+import (
+	"crypto/sha256"
+	"io"
+	"io/fs"
+)
+
+const HashSHA256 = "sha256"
+
+// streams the content so memory use stays bounded regardless of file size
+func hashFileSHA256(fsys fs.FS, name string) ([]byte, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, err
+	}
+
+	return hash.Sum(nil), nil
+}
@@ -0,0 +1,185 @@
+package skeleton
+
+import (
+	"bufio"
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/function61/gokit/app/byteshuman"
+)
+
+// how many of the largest files the HTML report lists
+const htmlReportLargest = 20
+
+// self-contained (no external CSS or JS) page with a collapsible tree and the summary, for sharing
+// with people who can't open a zip. html/template escapes the names, so hosting it is safe even
+// if they contain markup.
+type htmlSink struct {
+	output  io.Writer
+	state   *walkState
+	opts    Options
+	scanned time.Time
+	entries []entry // rendered once all are known, like treeSink
+}
+
+func newHTMLSink(output io.Writer, state *walkState, opts Options, scanned time.Time) *htmlSink {
+	return &htmlSink{output: output, state: state, opts: opts, scanned: scanned}
+}
+
+func (h *htmlSink) Entry(e entry) error {
+	h.entries = append(h.entries, e)
+	return nil
+}
+
+func (h *htmlSink) Close() error {
+	addSubtreeSizes(h.entries)
+
+	root := &htmlNode{IsDir: true, Open: true}
+	byName := map[string]*htmlNode{"": root}
+	largest := []FileSize{}
+
+	// parent before child is not guaranteed (e.g. with concurrency), so parents that are not yet
+	// seen are created on demand
+	var nodeFor func(name string) *htmlNode
+	nodeFor = func(name string) *htmlNode {
+		if node, found := byName[name]; found {
+			return node
+		}
+
+		parentName, baseName := "", name
+		if idx := strings.LastIndex(name, "/"); idx != -1 {
+			parentName, baseName = name[:idx], name[idx+1:]
+		}
+
+		node := &htmlNode{Name: baseName, IsDir: true}
+		parent := nodeFor(parentName)
+		parent.Children = append(parent.Children, node)
+		node.Open = parent == root // only the roots start expanded
+		byName[name] = node
+		return node
+	}
+
+	for _, e := range h.entries {
+		node := nodeFor(strings.TrimSuffix(e.name, "/"))
+		node.IsDir = e.fileInfo.IsDir()
+		node.Subtree = e.subtree
+		node.Symlink = e.symlinkTarget
+
+		if e.fileInfo.Mode().IsRegular() {
+			size := e.fileInfo.Size()
+			node.Size = &size
+			largest = keepLargest(largest, FileSize{Path: strings.TrimSuffix(e.name, "/"), Size: size}, htmlReportLargest)
+		}
+	}
+
+	root.sortChildren()
+
+	stats := h.state.stats
+	skipped := 0
+	for _, count := range stats.Skipped {
+		skipped += count
+	}
+
+	buffered := bufio.NewWriter(h.output)
+
+	if err := htmlReportTemplate.Execute(buffered, htmlReport{
+		Scanned:    h.scanned,
+		Roots:      h.opts.roots,
+		Stats:      stats,
+		Skipped:    skipped,
+		Incomplete: stats.Interrupted || stats.Truncated,
+		Largest:    largest,
+		TopLevel:   root.Children,
+	}); err != nil {
+		return err
+	}
+
+	return buffered.Flush()
+}
+
+type htmlReport struct {
+	Scanned    time.Time
+	Roots      []string
+	Stats      Stats
+	Skipped    int
+	Incomplete bool
+	Largest    []FileSize
+	TopLevel   []*htmlNode
+}
+
+type htmlNode struct {
+	Name     string // base name
+	IsDir    bool
+	Open     bool         // initially expanded
+	Size     *int64       // regular files
+	Subtree  *SubtreeSize // directories that were entries (not for ones only implied by their children)
+	Symlink  string       // target
+	Children []*htmlNode
+}
+
+// directories first, then by name, like file managers show them
+func (n *htmlNode) sortChildren() {
+	sort.Slice(n.Children, func(i, j int) bool {
+		if n.Children[i].IsDir != n.Children[j].IsDir {
+			return n.Children[i].IsDir
+		}
+
+		return n.Children[i].Name < n.Children[j].Name
+	})
+
+	for _, child := range n.Children {
+		child.sortChildren()
+	}
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"humanize": func(bytes int64) string { return byteshuman.Humanize(uint64(bytes)) },
+	"utc":      func(ts time.Time) string { return ts.UTC().Format("2006-01-02 15:04:05 UTC") },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Directory skeleton{{range .Roots}} {{.}}{{end}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+td, th { padding: 0.2em 1em 0.2em 0; text-align: left; }
+td.num { text-align: right; }
+ul.tree, ul.tree ul { list-style: none; padding-left: 1.2em; margin: 0; }
+ul.tree { padding-left: 0; }
+summary { cursor: pointer; }
+.meta { color: #777; font-size: 0.85em; }
+.warning { color: #b00; font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>Directory skeleton</h1>
+{{if .Incomplete}}<p class="warning">The scan was cut short, so this report is incomplete.</p>{{end}}
+<table>
+{{if .Roots}}<tr><th>Roots</th><td>{{range $i, $root := .Roots}}{{if $i}}, {{end}}{{$root}}{{end}}</td></tr>{{end}}
+<tr><th>Scanned</th><td>{{utc .Scanned}}</td></tr>
+<tr><th>Files</th><td>{{.Stats.Files}}</td></tr>
+<tr><th>Directories</th><td>{{.Stats.Dirs}}</td></tr>
+<tr><th>Total size</th><td>{{humanize .Stats.LogicalBytes}} ({{.Stats.LogicalBytes}} bytes)</td></tr>
+{{if .Skipped}}<tr><th>Skipped</th><td>{{.Skipped}}</td></tr>{{end}}
+{{if .Stats.Errors}}<tr><th>Unreadable</th><td>{{len .Stats.Errors}}</td></tr>{{end}}
+</table>
+{{if .Largest}}
+<h2>Largest files</h2>
+<table>
+{{range .Largest}}<tr><td class="num">{{humanize .Size}}</td><td>{{.Path}}</td></tr>
+{{end}}</table>
+{{end}}
+<h2>Structure</h2>
+<ul class="tree">
+{{range .TopLevel}}{{template "node" .}}{{end}}</ul>
+</body>
+</html>
+{{define "node"}}<li>{{if .IsDir}}<details{{if .Open}} open{{end}}><summary>{{.Name}}/{{with .Subtree}} <span class="meta">{{.Files}} files, {{humanize .Bytes}}</span>{{end}}</summary>
+<ul>
+{{range .Children}}{{template "node" .}}{{end}}</ul>
+</details>{{else}}{{.Name}}{{with .Size}} <span class="meta">{{humanize .}}</span>{{end}}{{with .Symlink}} <span class="meta">&rarr; {{.}}</span>{{end}}{{end}}</li>
+{{end}}`))
@@ -0,0 +1,144 @@
+package skeleton
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"sort"
+)
+
+// an incremental skeleton only has the entries that are new or changed since a previous skeleton,
+// plus (in the manifest) the ones that are gone. restore applies it on top of the previous one's
+// restore, and diff against the previous one overlays it.
+type manifestIncremental struct {
+	Since   []string `json:"since"`   // base names of the previous skeleton's volumes
+	Deleted []string `json:"deleted"` // archive names of the previous one's entries that are gone. incomplete if the scan was interrupted or truncated.
+}
+
+// reason for leaving out entries that are the same as in the previous skeleton
+const skippedByUnchanged = "unchanged"
+
+type incrementalState struct {
+	previous map[string]entryMetadata
+	seen     map[string]bool // of previous entries
+}
+
+func newIncrementalState(opts Options) (*incrementalState, error) {
+	previous, err := readArchiveMetadata(opts.Since, opts.SincePassword)
+	if err != nil {
+		return nil, err
+	}
+
+	return &incrementalState{previous: previous, seen: map[string]bool{}}, nil
+}
+
+// size (for non-directories), mode and mtime are compared. for directories the mtime changes
+// whenever their children are added or removed.
+func (i *incrementalState) unchanged(e entry) bool {
+	previous, found := i.previous[e.name]
+	if !found {
+		return false
+	}
+
+	i.seen[e.name] = true
+
+	if previous.mode != e.fileInfo.Mode() || (!e.fileInfo.IsDir() && previous.size != e.fileInfo.Size()) {
+		return false
+	}
+
+	return modifiedEqual(previous, entryMetadata{modified: e.fileInfo.ModTime(), modifiedPrecise: true})
+}
+
+// sorted
+func (i *incrementalState) deleted() []string {
+	deleted := []string{}
+	for name := range i.previous {
+		if !i.seen[name] {
+			deleted = append(deleted, name)
+		}
+	}
+	sort.Strings(deleted)
+
+	return deleted
+}
+
+func newManifestIncremental(opts Options, stats Stats) *manifestIncremental {
+	if len(opts.Since) == 0 {
+		return nil
+	}
+
+	since := []string{}
+	for _, previous := range opts.Since {
+		since = append(since, filepath.Base(previous))
+	}
+
+	deleted := stats.Deleted
+	if deleted == nil { // not known (yet)
+		deleted = []string{}
+	}
+
+	return &manifestIncremental{Since: since, Deleted: deleted}
+}
+
+// nil if the archive isn't incremental
+func readArchiveIncremental(archivePath string, password func() ([]byte, error)) (*manifestIncremental, error) {
+	archive, err := openArchives([]string{archivePath}, password)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	manifest, err := readManifest(archive.File)
+	if err != nil || manifest == nil {
+		return nil, err
+	}
+
+	return manifest.Incremental, nil
+}
+
+// the tree that restoring incremental on top of previous gives
+func overlayIncremental(previous map[string]entryMetadata, incremental map[string]entryMetadata, deleted []string) map[string]entryMetadata {
+	tree := map[string]entryMetadata{}
+	for name, metadata := range previous {
+		tree[name] = metadata
+	}
+
+	for _, name := range deleted {
+		delete(tree, name)
+	}
+
+	for name, metadata := range incremental {
+		tree[name] = metadata
+	}
+
+	return tree
+}
+
+// nil if the archive has no manifest (e.g. made by an older version). for split archives the
+// last volume's manifest is the most complete.
+func readManifest(files []*zip.File) (*zipManifest, error) {
+	trailer, err := findZipTrailer(files)
+	return trailer.manifest, err
+}
+
+// nil if it isn't JSON, i.e. not ours
+func readManifestFile(file *zip.File) (*zipManifest, error) {
+	content, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	manifestJSON, err := io.ReadAll(io.LimitReader(content, maxManifestLen))
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &zipManifest{}
+	if err := json.Unmarshal(manifestJSON, manifest); err != nil {
+		return nil, nil
+	}
+
+	return manifest, nil
+}
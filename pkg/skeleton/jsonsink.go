@@ -0,0 +1,109 @@
+package skeleton
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+)
+
+// ManifestEntry is one entry of a manifest (= the archive's entry list as data)
+type ManifestEntry struct {
+	Path        string       `json:"path" yaml:"path"`
+	Size        int64        `json:"size" yaml:"size"`
+	Mode        string       `json:"mode" yaml:"mode"`
+	Modified    time.Time    `json:"modified" yaml:"modified"`
+	Accessed    *time.Time   `json:"accessed,omitempty" yaml:"accessed,omitempty"` // with AllTimes
+	Changed     *time.Time   `json:"changed,omitempty" yaml:"changed,omitempty"`   // inode change time. with AllTimes
+	IsDir       bool         `json:"isDir" yaml:"isDir"`
+	SHA256      string       `json:"sha256,omitempty" yaml:"sha256,omitempty"`
+	ContentType string       `json:"contentType,omitempty" yaml:"contentType,omitempty"`       // MIME type
+	Subtree     *SubtreeSize `json:"subtree,omitempty" yaml:"subtree,omitempty"`               // for directories, with WithSizes
+	Counts      *ChildCounts `json:"counts,omitempty" yaml:"counts,omitempty"`                 // for directories, with WithCounts
+	FileAttrs   []string     `json:"fileAttrs,omitempty" yaml:"fileAttrs,omitempty"`           // with FileAttrs, e.g. "immutable"
+	Allocated   *int64       `json:"allocatedBytes,omitempty" yaml:"allocatedBytes,omitempty"` // with WithAllocation, for regular files. less than Size for sparse files
+	HardlinkTo  string       `json:"hardlinkTo,omitempty" yaml:"hardlinkTo,omitempty"`         // path of the entry this is a hardlink to. only read from archives.
+}
+
+func newManifestEntry(e entry) ManifestEntry {
+	size := e.fileInfo.Size()
+	if e.fileInfo.IsDir() { // size given by the OS is meaningless for us
+		size = 0
+	}
+
+	m := ManifestEntry{
+		Path:        strings.TrimSuffix(e.name, "/"),
+		Size:        size,
+		Mode:        e.fileInfo.Mode().String(),
+		Modified:    e.fileInfo.ModTime().UTC(),
+		IsDir:       e.fileInfo.IsDir(),
+		SHA256:      hex.EncodeToString(e.sha256),
+		ContentType: e.contentType,
+		Subtree:     e.subtree,
+		Counts:      e.childCounts,
+		Allocated:   e.allocated,
+	}
+
+	if e.fileAttrs != 0 {
+		m.FileAttrs = describeFileAttrs(e.fileAttrs)
+	}
+
+	if !e.accessed.IsZero() {
+		accessed, changed := e.accessed.UTC(), e.changed.UTC()
+		m.Accessed, m.Changed = &accessed, &changed
+	}
+
+	return m
+}
+
+// streams entries either as one JSON array or as JSON lines, so memory use stays flat
+type jsonSink struct {
+	file    io.Writer
+	lines   bool
+	entries int
+}
+
+func newJSONSink(file io.Writer, lines bool) *jsonSink {
+	return &jsonSink{file: file, lines: lines}
+}
+
+func (j *jsonSink) Entry(e entry) error {
+	entryJSON, err := json.Marshal(newManifestEntry(e))
+	if err != nil {
+		return err
+	}
+
+	separator := func() string {
+		switch {
+		case j.lines:
+			return ""
+		case j.entries == 0:
+			return "[\n"
+		default:
+			return ",\n"
+		}
+	}()
+	j.entries++
+
+	if j.lines {
+		entryJSON = append(entryJSON, '\n')
+	}
+
+	_, err = io.WriteString(j.file, separator+string(entryJSON))
+	return err
+}
+
+func (j *jsonSink) Close() error {
+	if j.lines {
+		return nil
+	}
+
+	end := "\n]\n"
+	if j.entries == 0 {
+		end = "[]\n"
+	}
+
+	_, err := io.WriteString(j.file, end)
+	return err
+}
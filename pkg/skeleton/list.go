@@ -0,0 +1,77 @@
+package skeleton
+
+import (
+	"encoding/hex"
+	"strings"
+)
+
+// List reads the entries of a skeleton archive (all volumes of it, if split), in archive order.
+// password is asked only if the archive is encrypted.
+func List(archivePaths []string, password func() ([]byte, error)) ([]ManifestEntry, error) {
+	archive, err := openArchives(archivePaths, password)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	entries := []ManifestEntry{}
+	indexByName := map[string]int{}
+	hardlinkTargets := map[int]string{}
+
+	for _, entry := range archive.File {
+		if archive.trailer.has(entry) { // not part of the skeleton
+			continue
+		}
+
+		if target, isHardlink := findExtraField(entry.Extra, extraFieldHardlink); isHardlink {
+			hardlinkTargets[len(entries)] = string(target)
+		}
+
+		digest, _ := findExtraField(entry.Extra, extraFieldSHA256)
+		contentType, _ := findExtraField(entry.Extra, extraFieldContentType)
+		modified, _ := entryModified(entry)
+
+		listed := ManifestEntry{
+			Path:        strings.TrimSuffix(entry.Name, "/"),
+			Size:        entryLogicalSize(entry),
+			Mode:        entry.Mode().String(),
+			Modified:    modified,
+			IsDir:       strings.HasSuffix(entry.Name, "/"),
+			SHA256:      hex.EncodeToString(digest),
+			ContentType: string(contentType),
+			Subtree:     entrySubtreeSize(entry),
+			Counts:      entryChildCounts(entry),
+		}
+
+		if data, found := findExtraField(entry.Extra, extraFieldFileAttrs); found {
+			if flags, ok := decodeFileAttrsExtraField(data); ok && flags != 0 {
+				listed.FileAttrs = describeFileAttrs(flags)
+			}
+		}
+
+		if allocated, found := entryAllocatedBytes(entry); found {
+			listed.Allocated = &allocated
+		}
+
+		if accessed, changed, found := entryAccessChangeTimes(entry); found {
+			listed.Accessed, listed.Changed = &accessed, &changed
+		}
+
+		indexByName[entry.Name] = len(entries)
+		entries = append(entries, listed)
+	}
+
+	// hardlinks don't have content of their own, so their content is their target's
+	for i, target := range hardlinkTargets {
+		if targetIdx, found := indexByName[target]; found {
+			entries[i].Size = entries[targetIdx].Size
+			entries[i].SHA256 = entries[targetIdx].SHA256
+			entries[i].ContentType = entries[targetIdx].ContentType
+			entries[i].FileAttrs = entries[targetIdx].FileAttrs
+			entries[i].Allocated = entries[targetIdx].Allocated
+			entries[i].HardlinkTo = entries[targetIdx].Path
+		}
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,316 @@
+package skeleton
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/function61/gokit/app/dynversion"
+)
+
+// describes the archive for tooling, so it doesn't have to parse the README or entry names
+const manifestName = "manifest.json"
+
+// when a manifest is looked for in a stream. one with a huge list of deletions still fits.
+const maxManifestLen = 64 << 20
+
+type zipManifest struct {
+	Version     string               `json:"version"` // of this tool
+	Scanned     time.Time            `json:"scanned"` // when the scan started. SOURCE_DATE_EPOCH (or under Reproducible, a fixed timestamp) if set.
+	Roots       []string             `json:"roots"`   // as given
+	Options     manifestOptions      `json:"options"`
+	Volume      int                  `json:"volume,omitempty"` // if split. counts then cover the volumes up to this one.
+	Counts      manifestCounts       `json:"counts"`
+	Interrupted bool                 `json:"interrupted"`
+	Truncated   bool                 `json:"truncated,omitempty"`   // stopped at Options.LimitEntries
+	MerkleRoot  string               `json:"merkleRoot,omitempty"`  // hex SHA-256, with Fingerprint
+	Incremental *manifestIncremental `json:"incremental,omitempty"` // with Since
+}
+
+// only the ones that affect what got archived. unused ones are left out.
+type manifestOptions struct {
+	Format            string     `json:"format"`
+	Compression       string     `json:"compression,omitempty"`      // zip only
+	CompressionLevel  *int       `json:"compressionLevel,omitempty"` // zip only. the effective level, even if the default was asked for
+	FilesFrom         string     `json:"filesFrom,omitempty"`
+	Excludes          []string   `json:"excludes,omitempty"`
+	Gitignore         bool       `json:"gitignore,omitempty"`
+	Skeletonignore    bool       `json:"skeletonignore,omitempty"`
+	NoHidden          bool       `json:"noHidden,omitempty"`
+	MaxDepth          *int       `json:"maxDepth,omitempty"`
+	OneFileSystem     bool       `json:"oneFileSystem,omitempty"`
+	FollowSymlinks    bool       `json:"followSymlinks,omitempty"`
+	LimitEntries      int        `json:"limitEntries,omitempty"`
+	MinSize           int64      `json:"minSize,omitempty"`
+	MaxSize           *int64     `json:"maxSize,omitempty"`
+	NewerThan         *time.Time `json:"newerThan,omitempty"`
+	OlderThan         *time.Time `json:"olderThan,omitempty"`
+	Extensions        []string   `json:"extensions,omitempty"`
+	ExcludeExtensions []string   `json:"excludeExtensions,omitempty"`
+	DirsOnly          bool       `json:"dirsOnly,omitempty"`
+	PruneEmptyDirs    bool       `json:"pruneEmptyDirs,omitempty"`
+	SkipErrors        bool       `json:"skipErrors,omitempty"`
+	RelativeTo        string     `json:"relativeTo,omitempty"`
+	Prefix            string     `json:"prefix,omitempty"`
+	Disambiguate      bool       `json:"disambiguate,omitempty"`
+	StripComponents   int        `json:"stripComponents,omitempty"`
+	Hash              string     `json:"hash,omitempty"`
+	FillByte          string     `json:"fillByte"`
+	SampleBytes       int        `json:"sampleBytes,omitempty"`
+	KeepContentBelow  int64      `json:"keepContentBelow,omitempty"` // files smaller than this have their real content
+	DetectType        bool       `json:"detectType,omitempty"`
+	WithSizes         bool       `json:"withSizes,omitempty"`
+	WithCounts        bool       `json:"withCounts,omitempty"`
+	WithAllocation    bool       `json:"withAllocation,omitempty"`
+	Xattrs            bool       `json:"xattrs,omitempty"`
+	AllTimes          bool       `json:"allTimes,omitempty"`
+	FileAttrs         bool       `json:"fileAttrs,omitempty"`
+	Owners            bool       `json:"owners,omitempty"`
+	Reproducible      bool       `json:"reproducible,omitempty"`
+	Encrypted         bool       `json:"encrypted,omitempty"`
+	RestoreScript     bool       `json:"restoreScript,omitempty"`
+	SplitSize         int64      `json:"splitSize,omitempty"`
+	SplitByTopLevel   bool       `json:"splitByTopLevel,omitempty"`
+	NameHash          bool       `json:"nameHash,omitempty"`
+	NormalizeUnicode  bool       `json:"normalizeUnicode,omitempty"`
+	SanitizeNames     bool       `json:"sanitizeNames,omitempty"`
+	KeepExtensions    bool       `json:"keepExtensions,omitempty"`
+	ReadmeName        string     `json:"readmeName,omitempty"` // if renamed
+	NoReadme          bool       `json:"noReadme,omitempty"`
+}
+
+type manifestCounts struct {
+	Files              int            `json:"files"`
+	Dirs               int            `json:"dirs"`
+	LogicalBytes       int64          `json:"logicalBytes"`
+	HardlinksCollapsed int            `json:"hardlinksCollapsed"`
+	Skipped            map[string]int `json:"skipped,omitempty"`
+	Errors             int            `json:"errors"`
+}
+
+// as given (hashed with NameHash). empty with FilesFrom.
+func archivedRoots(opts Options) []string {
+	roots := []string{}
+	for _, root := range opts.roots {
+		if opts.NameHash {
+			root = hashPathComponents(root, false)
+		}
+
+		roots = append(roots, root)
+	}
+
+	return roots
+}
+
+func newZipManifest(opts Options, scanned time.Time, stats Stats, volume int) zipManifest {
+	return zipManifest{
+		Version:     dynversion.Version,
+		Scanned:     scanned,
+		Roots:       archivedRoots(opts),
+		Options:     newManifestOptions(opts),
+		Volume:      volume,
+		Interrupted: stats.Interrupted,
+		Truncated:   stats.Truncated,
+		MerkleRoot:  stats.MerkleRoot,
+		Incremental: newManifestIncremental(opts, stats),
+		Counts: manifestCounts{
+			Files:              stats.Files,
+			Dirs:               stats.Dirs,
+			LogicalBytes:       stats.LogicalBytes,
+			HardlinksCollapsed: stats.HardlinksCollapsed,
+			Skipped:            stats.Skipped,
+			Errors:             len(stats.Errors),
+		},
+	}
+}
+
+func newManifestOptions(opts Options) manifestOptions {
+	m := manifestOptions{
+		Format:            opts.Format,
+		Compression:       opts.Compression,
+		FilesFrom:         opts.FilesFrom,
+		Excludes:          opts.Excludes,
+		Gitignore:         opts.Gitignore,
+		Skeletonignore:    opts.Skeletonignore,
+		NoHidden:          opts.NoHidden,
+		OneFileSystem:     opts.OneFileSystem,
+		FollowSymlinks:    opts.FollowSymlinks,
+		LimitEntries:      opts.LimitEntries,
+		MinSize:           opts.MinSize,
+		Extensions:        opts.Extensions,
+		ExcludeExtensions: opts.ExcludeExtensions,
+		DirsOnly:          opts.DirsOnly,
+		PruneEmptyDirs:    opts.PruneEmptyDirs,
+		SkipErrors:        opts.SkipErrors,
+		RelativeTo:        opts.RelativeTo,
+		Prefix:            opts.Prefix,
+		Disambiguate:      opts.Disambiguate,
+		StripComponents:   opts.StripComponents,
+		Hash:              opts.Hash,
+		FillByte:          opts.FillByte,
+		SampleBytes:       opts.SampleBytes,
+		KeepContentBelow:  opts.KeepContentBelow,
+		DetectType:        opts.DetectType,
+		WithSizes:         opts.WithSizes,
+		WithCounts:        opts.WithCounts,
+		WithAllocation:    opts.WithAllocation,
+		Xattrs:            opts.Xattrs,
+		AllTimes:          opts.AllTimes,
+		FileAttrs:         opts.FileAttrs,
+		Owners:            opts.Owners,
+		Reproducible:      opts.Reproducible,
+		Encrypted:         opts.Password != nil,
+		RestoreScript:     opts.RestoreScript,
+		SplitSize:         opts.SplitSize,
+		SplitByTopLevel:   opts.SplitByTopLevel,
+		NameHash:          opts.NameHash,
+		NormalizeUnicode:  opts.NormalizeUnicode,
+		SanitizeNames:     opts.SanitizeNames,
+		KeepExtensions:    opts.KeepExtensions,
+		ReadmeName:        opts.ReadmeName,
+		NoReadme:          opts.NoReadme,
+	}
+
+	if opts.NameHash { // these could reveal names
+		m.FilesFrom = ""
+		m.Excludes = nil
+		m.RelativeTo = ""
+	}
+	if m.Prefix == "." { // normalized form of no prefix
+		m.Prefix = ""
+	}
+	if opts.Format == FormatZip {
+		level := effectiveCompressionLevel(opts.Compression, opts.CompressionLevel)
+		m.CompressionLevel = &level
+	}
+	if opts.MaxDepth >= 0 {
+		m.MaxDepth = &opts.MaxDepth
+	}
+	if opts.MaxSize >= 0 {
+		m.MaxSize = &opts.MaxSize
+	}
+	if !opts.NewerThan.IsZero() {
+		newerThan := opts.NewerThan.UTC()
+		m.NewerThan = &newerThan
+	}
+	if !opts.OlderThan.IsZero() {
+		olderThan := opts.OlderThan.UTC()
+		m.OlderThan = &olderThan
+	}
+
+	return m
+}
+
+func (z zipManifest) marshal() []byte {
+	// can't fail, as there are no types that could fail to marshal
+	serialized, _ := json.MarshalIndent(z, "", "  ")
+	return append(serialized, '\n')
+}
+
+// names of the entries that describe the archive, instead of being part of the skeleton. a name
+// alone doesn't make an entry one of them (the source can have a "manifest.json" too), see zipTrailer.
+type trailerNames struct {
+	readme        string // "" if the README was left out
+	restoreScript bool   // whether restore.sh was emitted
+}
+
+// the README may have been renamed or left out, which the manifest tells. nil manifest = the defaults.
+func newTrailerNames(manifest *zipManifest) trailerNames {
+	if !isOwnManifest(manifest) {
+		return trailerNames{readme: readmeName}
+	}
+
+	names := trailerNames{readme: readmeName, restoreScript: manifest.Options.RestoreScript}
+	switch {
+	case manifest.Options.NoReadme:
+		names.readme = ""
+	case manifest.Options.ReadmeName != "":
+		names.readme = manifest.Options.ReadmeName
+	}
+
+	return names
+}
+
+// of the archive being written
+func (o Options) trailerNames() trailerNames {
+	names := trailerNames{readme: o.readmeEntryName(), restoreScript: o.RestoreScript}
+	if o.NoReadme {
+		names.readme = ""
+	}
+
+	return names
+}
+
+func (t trailerNames) has(name string) bool {
+	return (t.readme != "" && name == t.readme) || name == manifestName || (t.restoreScript && name == restoreScriptName)
+}
+
+// the source's entry would be indistinguishable from ours in tools that go by the name
+func (t trailerNames) assertNotClashing(e entry) error {
+	if t.has(e.name) {
+		return fmt.Errorf("%s: name %s is reserved for the archive's own entry. use a prefix to move it out of the way", e.path, e.name)
+	}
+
+	return nil
+}
+
+// the entries a zip (volume) ends with that describe the archive: [README], manifest.json,
+// [restore.sh]. same-named entries elsewhere are the source's own files.
+type zipTrailer struct {
+	entries  map[*zip.File]bool
+	manifest *zipManifest // nil if there's none of ours
+}
+
+func (z zipTrailer) has(file *zip.File) bool {
+	return z.entries[file]
+}
+
+// files is one volume, or many concatenated (then the trailer found is the last volume's)
+func findZipTrailer(files []*zip.File) (zipTrailer, error) {
+	trailer := zipTrailer{entries: map[*zip.File]bool{}}
+
+	i := len(files) - 1
+	var script *zip.File
+	if i >= 0 && files[i].Name == restoreScriptName {
+		script = files[i]
+		i--
+	}
+
+	if i < 0 || files[i].Name != manifestName {
+		// from before manifests existed, when the README was the only one
+		if last := len(files) - 1; last >= 0 && files[last].Name == readmeName {
+			trailer.entries[files[last]] = true
+		}
+
+		return trailer, nil
+	}
+
+	manifest, err := readManifestFile(files[i])
+	if err != nil {
+		return trailer, err
+	}
+
+	// a manifest.json of the source's. a restore.sh we didn't emit means ours isn't the last entry either.
+	if !isOwnManifest(manifest) || (script != nil && !manifest.Options.RestoreScript) {
+		return trailer, nil
+	}
+
+	trailer.manifest = manifest
+	trailer.entries[files[i]] = true
+	if script != nil {
+		trailer.entries[script] = true
+	}
+
+	if names := newTrailerNames(manifest); i > 0 && names.readme != "" && files[i-1].Name == names.readme {
+		trailer.entries[files[i-1]] = true
+	}
+
+	return trailer, nil
+}
+
+// any JSON object parses as a manifest, so a "manifest.json" of some other archive is told apart by
+// it not having the format (which ours always have)
+func isOwnManifest(manifest *zipManifest) bool {
+	return manifest != nil && manifest.Options.Format != ""
+}
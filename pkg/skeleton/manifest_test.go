@@ -0,0 +1,174 @@
+package skeleton
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestArchiveRejectsNamesOfTrailerEntries(t *testing.T) {
+	for _, name := range []string{manifestName, readmeName} {
+		fsys := fstest.MapFS{
+			name:    testFile(`{"not":"ours"}`),
+			"other": testFile("x"),
+		}
+
+		_, err := ArchiveFS(context.Background(), &bytes.Buffer{}, fsys, []string{"."}, DefaultOptions())
+		if err == nil || !strings.Contains(err.Error(), "reserved") {
+			t.Fatalf("%s: expected reserved name error, got %v", name, err)
+		}
+	}
+
+	// without --emit-restore-script the name is free
+	fsys := fstest.MapFS{restoreScriptName: testFile("#!/bin/sh")}
+	archivePath, _ := archiveTestFS(t, fsys, []string{"."}, DefaultOptions())
+	assertEqual(t, entryPaths(listTestArchive(t, archivePath)), []string{restoreScriptName})
+
+	opts := DefaultOptions()
+	opts.RestoreScript = true
+	if _, err := ArchiveFS(context.Background(), &bytes.Buffer{}, fsys, []string{"."}, opts); err == nil {
+		t.Fatal("expected restore.sh to clash with the emitted one")
+	}
+}
+
+func TestArchiveWithPrefixKeepsSourcesManifest(t *testing.T) {
+	fsys := fstest.MapFS{
+		manifestName:      testFile(`{"not":"ours"}`),
+		readmeName:        testFile("readme"),
+		"sub/file.txt":    testFile("x"),
+		"sub":             testDir(),
+		restoreScriptName: testFile("#!/bin/sh"),
+	}
+
+	opts := DefaultOptions()
+	opts.Prefix = "top"
+	opts.RestoreScript = true
+
+	archivePath, stats := archiveTestFS(t, fsys, []string{"."}, opts)
+	assertEqual(t, stats.Files, 4)
+
+	entries := listTestArchive(t, archivePath)
+	assertEqual(t, entryPaths(entries), []string{
+		"top/README-this-archive-is-special.txt",
+		"top/manifest.json",
+		"top/restore.sh",
+		"top/sub",
+		"top/sub/file.txt",
+	})
+
+	destDir := filepath.Join(t.TempDir(), "restored")
+	if err := Restore(context.Background(), []string{archivePath}, destDir, RestoreOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{manifestName, readmeName, restoreScriptName, "sub/file.txt"} {
+		if _, err := os.Stat(filepath.Join(destDir, "top", name)); err != nil {
+			t.Fatalf("not restored: %v", err)
+		}
+	}
+}
+
+// e.g. made by another tool, or by an earlier version that didn't reject the clash
+func TestOnlyTrailerAtTheEndIsOurs(t *testing.T) {
+	archive := bytes.Buffer{}
+	zipWriter := zip.NewWriter(&archive)
+
+	for _, name := range []string{manifestName, readmeName, restoreScriptName, "file.txt"} {
+		w, err := zipWriter.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: testModTime})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte("{}")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// our trailer, without restore.sh
+	for _, trailerEntry := range []struct {
+		name    string
+		content []byte
+	}{
+		{readmeName, []byte("readme")},
+		{manifestName, newZipManifest(DefaultOptions(), time.Now(), Stats{}, 0).marshal()},
+	} {
+		w, err := zipWriter.CreateHeader(&zip.FileHeader{Name: trailerEntry.name, Modified: testModTime})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(trailerEntry.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := zipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	archivePath := filepath.Join(t.TempDir(), "clashing.zip")
+	if err := os.WriteFile(archivePath, archive.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	assertEqual(t, entryPaths(listTestArchive(t, archivePath)), []string{manifestName, readmeName, restoreScriptName, "file.txt"})
+
+	destDir := filepath.Join(t.TempDir(), "restored")
+	if err := Restore(context.Background(), []string{archivePath}, destDir, RestoreOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := os.ReadDir(destDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(restored), 4)
+}
+
+func TestSkeletonizeTarKeepsSourcesReadme(t *testing.T) {
+	source := bytes.Buffer{}
+	tarWriter := tar.NewWriter(&source)
+	for _, name := range []string{readmeName, "file.txt", manifestName} {
+		content := []byte("content of " + name)
+		if err := tarWriter.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(content)), ModTime: testModTime}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tarWriter.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	sourcePath := filepath.Join(t.TempDir(), "source.tar")
+	if err := os.WriteFile(sourcePath, source.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := DefaultOptions()
+	opts.Prefix = "top"
+	opts.Hash = HashSHA256
+
+	output := bytes.Buffer{}
+	if _, err := Skeletonize(context.Background(), &output, sourcePath, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	archivePath := filepath.Join(t.TempDir(), "out.zip")
+	if err := os.WriteFile(archivePath, output.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := listTestArchive(t, archivePath)
+	assertEqual(t, entryPaths(entries), []string{"top/" + readmeName, "top/file.txt", "top/" + manifestName})
+
+	readme := entryByPath(t, entries, "top/"+readmeName)
+	assertEqual(t, readme.Size, int64(len("content of "+readmeName)))
+	assertEqual(t, readme.SHA256 != "", true)
+}
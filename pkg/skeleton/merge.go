@@ -0,0 +1,107 @@
+package skeleton
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	pathpkg "path"
+	"strings"
+)
+
+// MergeSource is one archive to merge, and the prefix its entries get (after Options.Prefix)
+type MergeSource struct {
+	Path   string
+	Prefix string
+}
+
+// Merge combines skeleton archives (or any archives Skeletonize() accepts) into one, e.g.
+// inventories of several machines, without needing the original trees.
+//
+// directories that are in more than one source are merged. other entries with the same name are
+// an error, or with Disambiguate, the later ones are suffixed ("report.pdf" => "report-2.pdf").
+func Merge(ctx context.Context, w io.Writer, sources []MergeSource, opts Options) (Stats, error) {
+	switch {
+	case opts.FilesFrom != "":
+		return Stats{}, errors.New("FilesFrom is not supported when merging")
+	case len(sources) == 0:
+		return Stats{}, errors.New("no archives given")
+	}
+
+	normalized := []MergeSource{}
+	for _, source := range sources {
+		prefix, err := normalizeArchivePrefix(source.Prefix)
+		if err != nil {
+			return Stats{}, err
+		}
+
+		normalized = append(normalized, MergeSource{Path: source.Path, Prefix: prefix})
+		opts.roots = append(opts.roots, source.Path)
+	}
+
+	return archive(ctx, w, func(ctx context.Context, state *walkState, opts Options, visit func(entry) error) error {
+		sourceByName := map[string]string{} // for messages
+
+		for _, source := range normalized {
+			sourceOpts := opts
+			sourceOpts.Prefix = joinArchivePath(opts.Prefix, source.Prefix)
+
+			renamed := map[string]string{} // so that hardlinks follow their disambiguated targets
+
+			a := &archiveWalker{
+				ctx:        ctx,
+				sourcePath: source.Path,
+				state:      state,
+				opts:       sourceOpts,
+				visit: func(e entry) error {
+					if target, wasRenamed := renamed[e.hardlinkTarget]; wasRenamed {
+						e.hardlinkTarget = target
+					}
+
+					if other, collides := sourceByName[e.name]; collides {
+						switch {
+						case e.fileInfo.IsDir():
+							state.stats.uncount(e.fileInfo)
+							return nil
+						case !opts.Disambiguate:
+							return fmt.Errorf("%s is also in %s (use --disambiguate or --prefix-by-source)", e.name, other)
+						default:
+							disambiguated := disambiguatedName(e.name, sourceByName)
+							state.logl.Info.Printf("%s is also in %s. naming it %s.", e.name, other, disambiguated)
+
+							renamed[e.name] = disambiguated
+							e.name = disambiguated
+						}
+					}
+
+					sourceByName[e.name] = source.Path
+
+					return visit(e)
+				},
+			}
+
+			if err := a.run(); err != nil {
+				return fmt.Errorf("%s: %w", source.Path, err)
+			}
+		}
+
+		return nil
+	}, opts)
+}
+
+// "a/report.pdf" => "a/report-2.pdf" (or -3 etc., the first that isn't taken)
+func disambiguatedName(name string, taken map[string]string) string {
+	ext := pathpkg.Ext(name)
+	if ext == pathpkg.Base(name) { // dotfile
+		ext = ""
+	}
+
+	stem := strings.TrimSuffix(name, ext)
+
+	for n := 2; ; n++ {
+		candidate := fmt.Sprintf("%s-%d%s", stem, n, ext)
+		if _, isTaken := taken[candidate]; !isTaken {
+			return candidate
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package skeleton
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// fingerprints the whole tree as a Merkle root over its entries sorted by name, so that two
+// skeletons can be compared in O(1). an entry's leaf covers its name, mode, size, symlink or
+// hardlink target and (if recorded) its content's hash. not times, owners or xattrs.
+type fingerprinter struct {
+	leaves []fingerprintLeaf
+}
+
+type fingerprintLeaf struct {
+	name string // for sorting
+	hash [sha256.Size]byte
+}
+
+// same length as a real root, for estimating the trailer's size before the root is known
+var placeholderMerkleRoot = strings.Repeat("0", 2*sha256.Size)
+
+func (f *fingerprinter) add(e entry, hardlinkTarget string) {
+	name := strings.TrimSuffix(e.name, "/")
+
+	size := int64(0)
+	if e.fileInfo.Mode().IsRegular() && hardlinkTarget == "" {
+		size = e.fileInfo.Size()
+	}
+
+	// length-prefixed fields, so that no two different entries encode the same
+	record := []byte{0x00} // leaf, as opposed to an inner node
+	for _, field := range []string{
+		name,
+		e.fileInfo.Mode().String(),
+		fmt.Sprint(size),
+		e.symlinkTarget,
+		strings.TrimSuffix(hardlinkTarget, "/"),
+		hex.EncodeToString(e.sha256),
+	} {
+		record = appendUvarint(record, uint64(len(field)))
+		record = append(record, field...)
+	}
+
+	f.leaves = append(f.leaves, fingerprintLeaf{name: name, hash: sha256.Sum256(record)})
+}
+
+// hex. stays the same regardless of the walk order (e.g. with concurrency).
+func (f *fingerprinter) root() string {
+	sort.Slice(f.leaves, func(i, j int) bool { return f.leaves[i].name < f.leaves[j].name })
+
+	level := make([][sha256.Size]byte, 0, len(f.leaves))
+	for _, leaf := range f.leaves {
+		level = append(level, leaf.hash)
+	}
+
+	if len(level) == 0 {
+		empty := sha256.Sum256(nil)
+		return hex.EncodeToString(empty[:])
+	}
+
+	for len(level) > 1 {
+		next := level[:0] // each node is read before its slot gets overwritten
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) { // odd one out is promoted as-is
+				next = append(next, level[i])
+				continue
+			}
+
+			node := append([]byte{0x01}, level[i][:]...)
+			node = append(node, level[i+1][:]...)
+			next = append(next, sha256.Sum256(node))
+		}
+		level = next
+	}
+
+	return hex.EncodeToString(level[0][:])
+}
+
+func appendUvarint(buf []byte, x uint64) []byte {
+	var encoded [binary.MaxVarintLen64]byte
+	return append(buf, encoded[:binary.PutUvarint(encoded[:], x)]...)
+}
@@ -0,0 +1,12 @@
+//go:build freebsd
+
+package skeleton
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// unix.Mknod() takes the device number as uint64 here, and as int elsewhere
+func mknodDev(major uint32, minor uint32) uint64 {
+	return unix.Mkdev(major, minor)
+}
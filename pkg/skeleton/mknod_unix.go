@@ -0,0 +1,12 @@
+//go:build aix || darwin || dragonfly || illumos || linux || netbsd || openbsd || solaris
+
+package skeleton
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// see mknod_freebsd.go
+func mknodDev(major uint32, minor uint32) int {
+	return int(unix.Mkdev(major, minor))
+}
@@ -0,0 +1,103 @@
+package skeleton
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	pathpkg "path"
+	"strings"
+)
+
+// with NameHash, each path component is replaced with a short hash of it. depth and sibling
+// relationships are preserved, but names aren't. the hashes are stable (the same name always gets
+// the same hash), so they're not secret against someone guessing common names.
+type nameHasher struct {
+	prefix         string            // not hashed, as it's not a name from the source
+	keepExtensions bool              // of files
+	originals      map[string]string // archive name => original, for detecting hash collisions
+	nameMap        *json.Encoder     // nil if not writing a mapping
+}
+
+func newNameHasher(opts Options) *nameHasher {
+	n := &nameHasher{
+		prefix:         opts.Prefix,
+		keepExtensions: opts.KeepExtensions,
+		originals:      map[string]string{},
+	}
+
+	if opts.NameMap != nil {
+		n.nameMap = json.NewEncoder(opts.NameMap)
+	}
+
+	return n
+}
+
+// one line of the mapping
+type nameMapping struct {
+	Hashed   string `json:"hashed"`
+	Original string `json:"original"`
+}
+
+func (n *nameHasher) redact(e entry) (entry, error) {
+	hashed := n.hashArchiveName(e.name)
+
+	if original, seen := n.originals[hashed]; seen && original != e.name {
+		return e, fmt.Errorf("%s: name hash collides with %s", e.path, original)
+	}
+	n.originals[hashed] = e.name
+
+	if n.nameMap != nil {
+		if err := n.nameMap.Encode(nameMapping{Hashed: hashed, Original: e.name}); err != nil {
+			return e, err
+		}
+	}
+
+	e.name = hashed
+
+	if e.hardlinkTarget != "" {
+		e.hardlinkTarget = n.hashArchiveName(e.hardlinkTarget)
+	}
+
+	// so that links keep pointing to their (hashed) targets within the archive
+	if e.symlinkTarget != "" {
+		e.symlinkTarget = hashPathComponents(e.symlinkTarget, n.keepExtensions)
+	}
+
+	return e, nil
+}
+
+// directories' names end in "/"
+func (n *nameHasher) hashArchiveName(name string) string {
+	isDir := strings.HasSuffix(name, "/")
+	prefix, name := splitArchivePrefix(strings.TrimSuffix(name, "/"), n.prefix)
+
+	return archiveName(prefix+hashPathComponents(name, n.keepExtensions && !isDir), isDir)
+}
+
+// keepExtension is for the last component. "", "." and ".." (and so the leading "/" of
+// absolute paths) are kept as-is.
+func hashPathComponents(path string, keepExtension bool) string {
+	components := strings.Split(path, "/")
+	for i, component := range components {
+		if component == "" || component == "." || component == ".." {
+			continue
+		}
+
+		ext := ""
+		if keepExtension && i == len(components)-1 {
+			if ext = pathpkg.Ext(component); ext == component { // a dotfile's name is not an extension
+				ext = ""
+			}
+		}
+
+		components[i] = hashNameComponent(component) + ext
+	}
+
+	return strings.Join(components, "/")
+}
+
+func hashNameComponent(component string) string {
+	digest := sha256.Sum256([]byte(component))
+	return hex.EncodeToString(digest[:])[:8]
+}
@@ -0,0 +1,17 @@
+package skeleton
+
+import (
+	"golang.org/x/text/unicode/norm"
+)
+
+// macOS stores names decomposed (NFD) while Linux and Windows usually have them composed (NFC), so
+// the same name would otherwise have different bytes depending on where it was archived
+func normalizeEntryNames(e entry) entry {
+	e.name = norm.NFC.String(e.name)
+
+	if e.hardlinkTarget != "" { // refers to an entry's (normalized) name
+		e.hardlinkTarget = norm.NFC.String(e.hardlinkTarget)
+	}
+
+	return e
+}
@@ -0,0 +1,135 @@
+package skeleton
+
+import (
+	"encoding/binary"
+	"errors"
+	"os/user"
+	"strconv"
+)
+
+type fileOwner struct {
+	uid       uint32
+	gid       uint32
+	userName  string // "" if not resolvable
+	groupName string // "" if not resolvable
+}
+
+// name lookups can hit NSS (LDAP etc.), and trees usually have only a handful of distinct owners
+type ownerNameCache struct {
+	users  map[uint32]string
+	groups map[uint32]string
+}
+
+func newOwnerNameCache() *ownerNameCache {
+	return &ownerNameCache{
+		users:  map[uint32]string{},
+		groups: map[uint32]string{},
+	}
+}
+
+func (o *ownerNameCache) resolve(uid uint32, gid uint32) fileOwner {
+	userName, found := o.users[uid]
+	if !found {
+		if u, err := user.LookupId(strconv.Itoa(int(uid))); err == nil {
+			userName = u.Username
+		}
+		o.users[uid] = userName
+	}
+
+	groupName, found := o.groups[gid]
+	if !found {
+		if g, err := user.LookupGroupId(strconv.Itoa(int(gid))); err == nil {
+			groupName = g.Name
+		}
+		o.groups[gid] = groupName
+	}
+
+	return fileOwner{uid, gid, userName, groupName}
+}
+
+// Info-ZIP's "ux" field, which e.g. "$ unzip -X" understands:
+// version (1) | UID size | UID | GID size | GID
+func encodeUnixOwnerExtraField(owner fileOwner) []byte {
+	data := make([]byte, 11)
+	data[0] = 1
+	data[1] = 4
+	binary.LittleEndian.PutUint32(data[2:6], owner.uid)
+	data[6] = 4
+	binary.LittleEndian.PutUint32(data[7:11], owner.gid)
+	return data
+}
+
+func decodeUnixOwnerExtraField(data []byte) (uint32, uint32, error) {
+	if len(data) < 2 || data[0] != 1 {
+		return 0, 0, errors.New("unsupported owner extra field")
+	}
+
+	readID := func(data []byte) (uint32, []byte, error) {
+		size := int(data[0])
+		if len(data) < 1+size || size > 4 {
+			return 0, nil, errors.New("corrupt owner extra field")
+		}
+
+		padded := make([]byte, 4)
+		copy(padded, data[1:1+size])
+		return binary.LittleEndian.Uint32(padded), data[1+size:], nil
+	}
+
+	uid, rest, err := readID(data[1:])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if len(rest) < 1 {
+		return 0, 0, errors.New("corrupt owner extra field")
+	}
+
+	gid, _, err := readID(rest)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return uid, gid, nil
+}
+
+// (user name length uint16, user name, group name length uint16, group name)
+func encodeOwnerNamesExtraField(owner fileOwner) []byte {
+	return appendLengthPrefixed(appendLengthPrefixed(nil, []byte(owner.userName)), []byte(owner.groupName))
+}
+
+func decodeOwnerNamesExtraField(data []byte) (string, string, error) {
+	userName, rest, err := cutLengthPrefixed(data)
+	if err != nil {
+		return "", "", err
+	}
+
+	groupName, _, err := cutLengthPrefixed(rest)
+	if err != nil {
+		return "", "", err
+	}
+
+	return string(userName), string(groupName), nil
+}
+
+// like tar, prefers names (if they resolve on this system), because IDs can differ across systems
+func resolveRestoreOwner(uid uint32, gid uint32, userName string, groupName string) (int, int) {
+	resolvedUID, resolvedGID := int(uid), int(gid)
+
+	if userName != "" {
+		if u, err := user.Lookup(userName); err == nil {
+			if id, err := strconv.Atoi(u.Uid); err == nil {
+				resolvedUID = id
+			}
+		}
+	}
+
+	if groupName != "" {
+		if g, err := user.LookupGroup(groupName); err == nil {
+			if id, err := strconv.Atoi(g.Gid); err == nil {
+				resolvedGID = id
+			}
+		}
+	}
+
+	return resolvedUID, resolvedGID
+}
@@ -0,0 +1,180 @@
+package skeleton
+
+import (
+	"encoding/json"
+	"fmt"
+	pathpkg "path"
+	"strings"
+	"unicode/utf16"
+)
+
+// characters that Windows (and FAT) don't allow in names, in addition to control characters
+const windowsIllegalChars = `<>:"\|?*`
+
+// device names, reserved regardless of extension ("nul.txt" is the NUL device as well)
+var windowsReservedNames = func() map[string]bool {
+	reserved := map[string]bool{"CON": true, "PRN": true, "AUX": true, "NUL": true}
+	for i := 1; i <= 9; i++ {
+		reserved[fmt.Sprintf("COM%d", i)] = true
+		reserved[fmt.Sprintf("LPT%d", i)] = true
+	}
+	return reserved
+}()
+
+// with CheckPortability, reports names that Windows can't have. with SanitizeNames, rewrites them
+// to ones it can.
+type portabilityChecker struct {
+	check     bool
+	sanitize  bool
+	prefix    string            // not checked, as it's not a name from the source
+	originals map[string]string // sanitized archive name => original, for detecting collisions
+	nameMap   *json.Encoder     // nil if not writing a mapping
+	state     *walkState
+}
+
+func newPortabilityChecker(opts Options, state *walkState) *portabilityChecker {
+	p := &portabilityChecker{
+		check:     opts.CheckPortability,
+		sanitize:  opts.SanitizeNames,
+		prefix:    opts.Prefix,
+		originals: map[string]string{},
+		state:     state,
+	}
+
+	if opts.NameMap != nil && opts.SanitizeNames {
+		p.nameMap = json.NewEncoder(opts.NameMap)
+	}
+
+	return p
+}
+
+// one line of the mapping
+type sanitizedNameMapping struct {
+	Sanitized string `json:"sanitized"`
+	Original  string `json:"original"`
+}
+
+func (p *portabilityChecker) visit(e entry) (entry, error) {
+	_, name := splitArchivePrefix(strings.TrimSuffix(e.name, "/"), p.prefix)
+
+	// only the last component, as the parents were reported as entries of their own
+	if reason := unportableReason(pathpkg.Base(name)); reason != "" && p.check {
+		p.state.progress.clear()
+		p.state.logl.Info.Printf("%s: not portable to Windows: %s", e.path, reason)
+		p.state.stats.Unportable++
+	}
+
+	if !p.sanitize {
+		return e, nil
+	}
+
+	sanitized := p.sanitizeArchiveName(e.name)
+
+	if original, seen := p.originals[sanitized]; seen && original != e.name {
+		return e, fmt.Errorf("%s: sanitized name collides with %s", e.path, original)
+	}
+	p.originals[sanitized] = e.name
+
+	if sanitized != e.name {
+		p.state.logl.Debug.Printf("%s: sanitized to %s", e.path, sanitized)
+
+		if p.nameMap != nil {
+			if err := p.nameMap.Encode(sanitizedNameMapping{Sanitized: sanitized, Original: e.name}); err != nil {
+				return e, err
+			}
+		}
+
+		e.name = sanitized
+	}
+
+	if e.hardlinkTarget != "" {
+		e.hardlinkTarget = p.sanitizeArchiveName(e.hardlinkTarget)
+	}
+
+	// so that links keep pointing to their (sanitized) targets within the archive
+	if e.symlinkTarget != "" {
+		e.symlinkTarget = sanitizePathComponents(e.symlinkTarget)
+	}
+
+	return e, nil
+}
+
+// directories' names end in "/"
+func (p *portabilityChecker) sanitizeArchiveName(name string) string {
+	isDir := strings.HasSuffix(name, "/")
+	prefix, name := splitArchivePrefix(strings.TrimSuffix(name, "/"), p.prefix)
+
+	return archiveName(prefix+sanitizePathComponents(name), isDir)
+}
+
+// "", "." and ".." (and so the leading "/" of absolute paths) are kept as-is
+func sanitizePathComponents(path string) string {
+	components := strings.Split(path, "/")
+	for i, component := range components {
+		if component == "" || component == "." || component == ".." {
+			continue
+		}
+
+		components[i] = sanitizeNameComponent(component)
+	}
+
+	return strings.Join(components, "/")
+}
+
+// "" if Windows can have component as a name
+func unportableReason(component string) string {
+	if component == "" || component == "." || component == ".." {
+		return ""
+	}
+
+	for _, r := range component {
+		switch {
+		case r < 0x20:
+			return "control character"
+		case strings.ContainsRune(windowsIllegalChars, r):
+			return fmt.Sprintf("character %q", r)
+		}
+	}
+
+	switch {
+	case strings.HasSuffix(component, ".") || strings.HasSuffix(component, " "):
+		return "ends in a dot or a space"
+	case windowsReservedNames[strings.ToUpper(reservedNameStem(component))]:
+		return "reserved device name"
+	case len(utf16.Encode([]rune(component))) > 255:
+		return "longer than 255 characters"
+	default:
+		return ""
+	}
+}
+
+// illegal characters => "_", "CON.txt" => "CON_.txt", "name." => "name_". names that are too long
+// are left alone, as shortening could easily collide.
+func sanitizeNameComponent(component string) string {
+	sanitized := strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(windowsIllegalChars, r) {
+			return '_'
+		}
+
+		return r
+	}, component)
+
+	if stem := reservedNameStem(sanitized); windowsReservedNames[strings.ToUpper(stem)] {
+		sanitized = stem + "_" + sanitized[len(stem):]
+	}
+
+	if strings.HasSuffix(sanitized, ".") || strings.HasSuffix(sanitized, " ") {
+		sanitized = sanitized[:len(sanitized)-1] + "_"
+	}
+
+	return sanitized
+}
+
+// "nul.tar.gz" => "nul"
+func reservedNameStem(component string) string {
+	if dot := strings.IndexByte(component, '.'); dot != -1 {
+		return component[:dot]
+	}
+
+	return component
+}
@@ -0,0 +1,176 @@
+package skeleton
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/function61/gokit/app/byteshuman"
+)
+
+const (
+	ProgressNone = "none"
+	ProgressLine = "line"
+	ProgressBar  = "bar"
+)
+
+// without a total known upfront (from CountFirst's pre-pass) we can only show running counters
+type progressReporter struct {
+	paths    *log.Logger                             // per-path listing
+	entryLog func(path string, fileInfo fs.FileInfo) // if set, lists the paths instead
+	status   *os.File                                // in-place updated status line. nil if disabled
+	bar      bool
+	frame    int
+	lastDraw time.Time
+	drawn    bool
+	total    *progressTotal // nil = not known
+}
+
+type progressTotal struct {
+	entries int
+	bytes   int64
+	started time.Time // of the pass the total is for
+}
+
+// makes the status a percentage with an ETA
+func (p *progressReporter) setTotal(entries int, bytes int64) {
+	p.total = &progressTotal{entries: entries, bytes: bytes, started: time.Now()}
+}
+
+func newProgressReporter(mode string, paths *log.Logger, status *os.File) (*progressReporter, error) {
+	switch mode {
+	case "":
+		mode = ProgressNone
+	case ProgressNone, ProgressLine, ProgressBar:
+	default:
+		return nil, fmt.Errorf("unsupported progress: %s", mode)
+	}
+
+	p := &progressReporter{
+		paths: paths,
+		bar:   mode == ProgressBar,
+	}
+
+	// in-place updates only make sense for humans watching a terminal
+	if mode != ProgressNone && isTerminal(status) {
+		p.status = status
+	}
+
+	return p, nil
+}
+
+func (p *progressReporter) Entry(path string, fileInfo fs.FileInfo, stats Stats) {
+	if p.entryLog != nil {
+		p.clear()
+		p.entryLog(path, fileInfo)
+	} else {
+		p.Printf("%s\n", path)
+	}
+
+	// redrawing on every entry would be a considerable slowdown for large trees
+	if now := time.Now(); now.Sub(p.lastDraw) >= 100*time.Millisecond {
+		p.lastDraw = now
+		p.draw(stats)
+	}
+}
+
+// prints a message without garbling the status line
+func (p *progressReporter) Printf(format string, args ...any) {
+	p.clear()
+
+	p.paths.Printf(format, args...)
+}
+
+// leaves the final counters visible
+func (p *progressReporter) Done(stats Stats) {
+	if p.status == nil {
+		return
+	}
+
+	p.draw(stats)
+	fmt.Fprintln(p.status)
+	p.drawn = false
+}
+
+func (p *progressReporter) draw(stats Stats) {
+	if p.status == nil {
+		return
+	}
+
+	if p.total != nil {
+		p.drawWithTotal(stats)
+		return
+	}
+
+	counters := fmt.Sprintf(
+		"%d files, %d dirs, %s",
+		stats.Files,
+		stats.Dirs,
+		byteshuman.Humanize(uint64(stats.LogicalBytes)))
+
+	if p.bar { // activity indicator, because it can't be a percentage
+		const width = 20
+		pos := p.frame % (2 * (width - 1))
+		if pos >= width {
+			pos = 2*(width-1) - pos
+		}
+		p.frame++
+
+		counters = "[" + strings.Repeat(" ", pos) + "=" + strings.Repeat(" ", width-1-pos) + "] " + counters
+	}
+
+	fmt.Fprintf(p.status, "\r\x1b[K%s", counters)
+	p.drawn = true
+}
+
+func (p *progressReporter) drawWithTotal(stats Stats) {
+	processed := stats.Files + stats.Dirs
+
+	fraction := 1.0
+	if p.total.entries > 0 {
+		fraction = float64(processed) / float64(p.total.entries)
+	}
+	if fraction > 1 { // the tree can grow between the passes
+		fraction = 1
+	}
+
+	eta := "?"
+	if elapsed := time.Since(p.total.started); processed > 0 {
+		eta = time.Duration(float64(elapsed) * (1 - fraction) / fraction).Round(time.Second).String()
+	}
+
+	counters := fmt.Sprintf(
+		"%3.0f%% %d/%d entries, %s/%s, ETA %s",
+		fraction*100,
+		processed,
+		p.total.entries,
+		byteshuman.Humanize(uint64(stats.LogicalBytes)),
+		byteshuman.Humanize(uint64(p.total.bytes)),
+		eta)
+
+	if p.bar {
+		const width = 20
+		filled := int(fraction * width)
+		counters = "[" + strings.Repeat("=", filled) + strings.Repeat(" ", width-filled) + "] " + counters
+	}
+
+	fmt.Fprintf(p.status, "\r\x1b[K%s", counters)
+	p.drawn = true
+}
+
+func (p *progressReporter) clear() {
+	if !p.drawn {
+		return
+	}
+
+	fmt.Fprint(p.status, "\r\x1b[K")
+	p.drawn = false
+}
+
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,79 @@
+package skeleton
+
+// with Threads > 1, files' content is read (for hashing and sampling) by a bounded pool of
+// goroutines, while entries are still visited one at a time and in the order they were walked
+type contentReaders struct {
+	slots   chan struct{}      // bounds concurrent reads
+	pending chan *pendingEntry // in walk order
+	done    chan struct{}      // closed once all pending entries are handled
+}
+
+type pendingEntry struct {
+	e    entry
+	read chan error // result of reading the content
+}
+
+func (w *walker) startContentReaders() {
+	if w.opts.Threads <= 1 {
+		return
+	}
+
+	r := &contentReaders{
+		slots:   make(chan struct{}, w.opts.Threads),
+		pending: make(chan *pendingEntry, w.opts.Threads*4), // some slack, so a slow file doesn't stall the walk right away
+		done:    make(chan struct{}),
+	}
+	w.readers = r
+
+	go func() {
+		defer close(r.done)
+
+		failed := false
+		for p := range r.pending {
+			err := <-p.read
+			if failed { // only draining, so that the walkers don't block
+				continue
+			}
+
+			if err != nil {
+				err = w.entryError(err)
+			} else {
+				err = w.visitEntry(p.e)
+			}
+
+			if err != nil {
+				failed = true
+				w.setErr(err) // stops the walkers
+			}
+		}
+	}()
+}
+
+// waits for the pending entries to be visited. errors are recorded with setErr().
+func (w *walker) finishContentReaders() {
+	if w.readers == nil {
+		return
+	}
+
+	close(w.readers.pending)
+	<-w.readers.done
+}
+
+// blocks if all the slots are taken, or if too many entries are pending
+func (r *contentReaders) submit(e entry, readsContent bool, read func(e *entry) error) {
+	p := &pendingEntry{e: e, read: make(chan error, 1)}
+
+	if readsContent {
+		r.slots <- struct{}{}
+
+		go func() {
+			defer func() { <-r.slots }()
+
+			p.read <- read(&p.e)
+		}()
+	} else {
+		p.read <- nil
+	}
+
+	r.pending <- p
+}
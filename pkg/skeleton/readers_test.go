@@ -0,0 +1,60 @@
+package skeleton
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestThreadsKeepEntryOrder(t *testing.T) {
+	fsys := fstest.MapFS{}
+	for i := 0; i < 200; i++ {
+		// varying sizes so that reads finish out of order
+		fsys[fmt.Sprintf("root/dir%d/file%03d", i%5, i)] = testFile(strings.Repeat("x", (i*7919)%20000))
+	}
+
+	for _, reproducible := range []bool{false, true} {
+		opts := DefaultOptions()
+		opts.Hash = HashSHA256
+		opts.Reproducible = reproducible
+
+		sequentialPath, _ := archiveTestFS(t, fsys, []string{"root"}, opts)
+
+		opts.Threads = 4
+
+		threadedPath, _ := archiveTestFS(t, fsys, []string{"root"}, opts)
+
+		assertEqual(t, listTestArchive(t, threadedPath), listTestArchive(t, sequentialPath))
+
+		if reproducible {
+			assertSameFile(t, threadedPath, sequentialPath)
+		}
+	}
+}
+
+// many small files, where hashing is dominated by opening them rather than by the digest
+func BenchmarkHashThreads(b *testing.B) {
+	files := fstest.MapFS{}
+	for i := 0; i < 500; i++ {
+		files[fmt.Sprintf("root/dir%d/file%03d", i%10, i)] = testFile(strings.Repeat("x", 1000+i))
+	}
+	fsys := slowFS{MapFS: files, latency: 100 * time.Microsecond}
+
+	for _, threads := range []int{1, 8} {
+		b.Run(fmt.Sprintf("%d", threads), func(b *testing.B) {
+			opts := DefaultOptions()
+			opts.Hash = HashSHA256
+			opts.Threads = threads
+
+			for i := 0; i < b.N; i++ {
+				if _, err := ArchiveFS(context.Background(), io.Discard, fsys, []string{"root"}, opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,440 @@
+package skeleton
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/function61/gokit/log/logex"
+	"github.com/pkg/xattr"
+)
+
+// RestoreOptions controls Restore()
+type RestoreOptions struct {
+	Force           bool                   // restore even if destination is a non-empty directory
+	Dense           bool                   // write the zeroes, instead of leaving files sparse (which takes no disk space, if the filesystem supports it)
+	StripComponents int                    // drop this many leading components from names. entries with no more components are skipped
+	Password        func() ([]byte, error) // asked only if the archive is encrypted. nil = encrypted archives fail
+	Logger          *log.Logger            // for entries that are skipped or restored only partially. nil = discard
+}
+
+// Restore recreates the skeleton directory hierarchy from the archive on disk, with files filled
+// with zeroes. a split archive is restored by giving all its volumes. an incremental archive is
+// restored on top of its previous skeleton's restore: its entries overwrite, and removed entries
+// are deleted.
+func Restore(ctx context.Context, archivePaths []string, destDir string, opts RestoreOptions) error {
+	logger := opts.Logger
+	if logger == nil {
+		logger = logex.Discard
+	}
+
+	archive, err := openArchives(archivePaths, opts.Password)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	manifest := archive.trailer.manifest
+	incremental := manifest != nil && manifest.Incremental != nil
+
+	if err := assertRestoreDestinationUsable(destDir, opts.Force || incremental); err != nil {
+		return err
+	}
+
+	if incremental {
+		for _, deleted := range manifest.Incremental.Deleted {
+			name := stripComponents(deleted, opts.StripComponents)
+			if name == "" {
+				continue
+			}
+
+			destPath, err := restoreDestinationPath(destDir, name)
+			if err != nil {
+				return err
+			}
+
+			if err := os.RemoveAll(destPath); err != nil {
+				return err
+			}
+		}
+	}
+
+	// only root can give files away
+	restoreOwners := os.Geteuid() == 0
+
+	// applied after everything else, because e.g. an immutable file can't have its mtime set (or
+	// be hardlinked), and an immutable directory can't have children created in it
+	type pendingFileAttrs struct {
+		path  string
+		name  string // for messages
+		flags uint32
+	}
+	fileAttrsToSet := []pendingFileAttrs{}
+
+	// restoring these is best-effort, because e.g. SELinux labels or attributes in the "trusted"
+	// namespace need privileges, and the destination filesystem might not support them at all
+	restoreExtraMetadata := func(destPath string, entry *zip.File) {
+		if data, found := findExtraField(entry.Extra, extraFieldFileAttrs); found {
+			if flags, ok := decodeFileAttrsExtraField(data); ok && flags != 0 {
+				fileAttrsToSet = append(fileAttrsToSet, pendingFileAttrs{destPath, entry.Name, flags})
+			}
+		}
+
+		if ids, found := findExtraField(entry.Extra, extraFieldUnixOwner); found && restoreOwners {
+			if err := restoreOwner(destPath, ids, entry); err != nil {
+				logex.Levels(logger).Error.Printf("%s: owner: %v", entry.Name, err)
+			}
+
+			// chown clears setuid/setgid of non-directories
+			if mode := entry.Mode(); mode&(os.ModeSetuid|os.ModeSetgid) != 0 && mode&os.ModeSymlink == 0 {
+				if err := os.Chmod(destPath, permissionBits(mode)); err != nil {
+					logex.Levels(logger).Error.Printf("%s: mode: %v", entry.Name, err)
+				}
+			}
+		}
+
+		data, found := findExtraField(entry.Extra, extraFieldXattrs)
+		if !found {
+			return
+		}
+
+		attrs, err := decodeXattrsExtraField(data)
+		if err != nil {
+			logex.Levels(logger).Error.Printf("%s: xattrs: %v", entry.Name, err)
+			return
+		}
+
+		for _, attr := range attrs {
+			if err := xattr.LSet(destPath, attr.name, attr.value); err != nil {
+				logex.Levels(logger).Error.Printf("%s: xattr %s: %v", entry.Name, attr.name, err)
+			}
+		}
+	}
+
+	// directory metadata have to be restored last, because creating children inside a directory
+	// bumps its mtime (and a read-only mode would prevent creating the children)
+	type dirMetadata struct {
+		path     string
+		mode     os.FileMode
+		modified time.Time
+	}
+	dirMetadatas := []dirMetadata{}
+
+	// symlinks are created last, so that no entry gets written through a symlink that points
+	// outside of the destination
+	symlinks := []*zip.File{}
+
+	// hardlinks can only be created once their target exists
+	hardlinks := []*zip.File{}
+
+	for _, entry := range archive.File {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+			// continue
+		}
+
+		if archive.trailer.has(entry) { // not part of the skeleton
+			continue
+		}
+
+		name := stripComponents(entry.Name, opts.StripComponents)
+		if name == "" {
+			logex.Levels(logger).Debug.Printf("%s: nothing left after stripping components. skipping.", entry.Name)
+			continue
+		}
+
+		destPath, err := restoreDestinationPath(destDir, name)
+		if err != nil {
+			return err
+		}
+
+		if strings.HasSuffix(entry.Name, "/") {
+			if err := os.MkdirAll(destPath, 0755); err != nil {
+				return err
+			}
+
+			modified, _ := entryModified(entry)
+
+			restoreExtraMetadata(destPath, entry)
+
+			dirMetadatas = append(dirMetadatas, dirMetadata{destPath, permissionBits(entry.Mode()), modified})
+			continue
+		}
+
+		if entry.Mode()&os.ModeSymlink != 0 {
+			symlinks = append(symlinks, entry)
+			continue
+		}
+
+		if _, isHardlink := findExtraField(entry.Extra, extraFieldHardlink); isHardlink {
+			hardlinks = append(hardlinks, entry)
+			continue
+		}
+
+		if isSpecialFile(entry.Mode()) {
+			// device nodes need privileges, and sockets can't be created at all
+			if err := restoreOneSpecialFile(destPath, entry); err != nil {
+				logex.Levels(logger).Error.Printf("%s: skipping: %v", entry.Name, err)
+				continue
+			}
+
+			restoreExtraMetadata(destPath, entry)
+			continue
+		}
+
+		if err := restoreOneFile(destPath, entry, opts.Dense); err != nil {
+			return fmt.Errorf("%s: %w", entry.Name, err)
+		}
+
+		restoreExtraMetadata(destPath, entry)
+	}
+
+	for _, entry := range hardlinks {
+		target, _ := findExtraField(entry.Extra, extraFieldHardlink)
+
+		strippedTarget := stripComponents(string(target), opts.StripComponents)
+		if strippedTarget == "" {
+			logex.Levels(logger).Error.Printf("%s: hardlink target %s was stripped away. skipping.", entry.Name, target)
+			continue
+		}
+
+		if err := restoreOneHardlink(destDir, stripComponents(entry.Name, opts.StripComponents), strippedTarget); err != nil {
+			return fmt.Errorf("%s: %w", entry.Name, err)
+		}
+	}
+
+	for _, entry := range symlinks {
+		destPath, err := restoreDestinationPath(destDir, stripComponents(entry.Name, opts.StripComponents))
+		if err != nil {
+			return err
+		}
+
+		if err := restoreOneSymlink(destPath, entry); err != nil {
+			return fmt.Errorf("%s: %w", entry.Name, err)
+		}
+
+		restoreExtraMetadata(destPath, entry)
+	}
+
+	for i := len(dirMetadatas) - 1; i >= 0; i-- { // reverse so children are handled before their parents
+		dir := dirMetadatas[i]
+
+		if err := os.Chmod(dir.path, dir.mode); err != nil {
+			return err
+		}
+
+		if err := os.Chtimes(dir.path, dir.modified, dir.modified); err != nil {
+			return err
+		}
+	}
+
+	// needs CAP_LINUX_IMMUTABLE for immutable and append-only, so best-effort as well
+	for _, attrs := range fileAttrsToSet {
+		if err := setFileAttrs(attrs.path, attrs.flags); err != nil {
+			logex.Levels(logger).Error.Printf("%s: file attributes: %v", attrs.name, err)
+		}
+	}
+
+	return nil
+}
+
+func restoreOneFile(destPath string, entry *zip.File, dense bool) error {
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, entry.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// OpenFile() mode is subject to umask and doesn't apply to pre-existing files
+	if err := file.Chmod(permissionBits(entry.Mode())); err != nil {
+		return err
+	}
+
+	size := entryLogicalSize(entry)
+
+	// except for a possible sample of the real content
+	if sampleLen := entrySampleLen(entry); sampleLen > 0 && sampleLen <= size {
+		if err := restoreSample(file, entry, sampleLen); err != nil {
+			return err
+		}
+
+		size -= sampleLen
+	}
+
+	// we know the content is all zeroes (and we don't trust archive to have a sane size), so no
+	// need to decompress the content.
+	if dense { // streaming keeps memory usage bounded
+		if _, err := io.Copy(file, io.LimitReader(readAllZeroes, size)); err != nil {
+			return err
+		}
+	} else { // extending reads back as zeroes, but is a hole that needs no disk space
+		if err := file.Truncate(entryLogicalSize(entry)); err != nil {
+			return err
+		}
+	}
+
+	if err := file.Close(); err != nil { // double close intentional
+		return err
+	}
+
+	modified, _ := entryModified(entry)
+
+	return os.Chtimes(destPath, modified, modified)
+}
+
+func restoreSample(file io.Writer, entry *zip.File, sampleLen int64) error {
+	content, err := entry.Open()
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	_, err = io.CopyN(file, content, sampleLen)
+	return err
+}
+
+func restoreOneSpecialFile(destPath string, entry *zip.File) error {
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return err
+	}
+
+	// could exist if restoring with --force
+	if err := os.Remove(destPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	major, minor := uint32(0), uint32(0)
+	if data, found := findExtraField(entry.Extra, extraFieldDevice); found {
+		major, minor, _ = decodeDeviceExtraField(data)
+	}
+
+	if err := createSpecialFile(destPath, entry.Mode(), major, minor); err != nil {
+		return err
+	}
+
+	// creation mode is subject to umask
+	if err := os.Chmod(destPath, permissionBits(entry.Mode())); err != nil {
+		return err
+	}
+
+	modified, _ := entryModified(entry)
+
+	return os.Chtimes(destPath, modified, modified)
+}
+
+func restoreOneHardlink(destDir string, name string, targetName string) error {
+	destPath, err := restoreDestinationPath(destDir, name)
+	if err != nil {
+		return err
+	}
+
+	targetPath, err := restoreDestinationPath(destDir, targetName)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return err
+	}
+
+	// could exist if restoring with --force
+	if err := os.Remove(destPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return os.Link(targetPath, destPath)
+}
+
+func restoreOneSymlink(destPath string, entry *zip.File) error {
+	content, err := entry.Open()
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	target, err := io.ReadAll(io.LimitReader(content, 4096)) // PATH_MAX
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return err
+	}
+
+	// could exist if restoring with --force
+	if err := os.Remove(destPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// not restoring mtime as os.Chtimes() would follow the link
+	return os.Symlink(string(target), destPath)
+}
+
+func restoreOwner(destPath string, ids []byte, entry *zip.File) error {
+	uid, gid, err := decodeUnixOwnerExtraField(ids)
+	if err != nil {
+		return err
+	}
+
+	userName, groupName := "", ""
+	if names, found := findExtraField(entry.Extra, extraFieldOwner); found {
+		if userName, groupName, err = decodeOwnerNamesExtraField(names); err != nil {
+			return err
+		}
+	}
+
+	resolvedUID, resolvedGID := resolveRestoreOwner(uid, gid, userName, groupName)
+
+	return os.Lchown(destPath, resolvedUID, resolvedGID)
+}
+
+// Perm() plus setuid, setgid and sticky, which Perm() drops
+func permissionBits(mode os.FileMode) os.FileMode {
+	return mode & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+}
+
+// guards against "zip slip" i.e. entry names like "../../etc/passwd"
+func restoreDestinationPath(destDir string, name string) (string, error) {
+	destPath := filepath.Join(destDir, filepath.FromSlash(name))
+
+	rel, err := filepath.Rel(destDir, destPath)
+	if err != nil {
+		return "", err
+	}
+
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("entry escapes destination directory: %s", name)
+	}
+
+	return destPath, nil
+}
+
+func assertRestoreDestinationUsable(destDir string, force bool) error {
+	if force {
+		return nil
+	}
+
+	entries, err := os.ReadDir(destDir)
+	switch {
+	case os.IsNotExist(err):
+		return nil // will be created
+	case err != nil:
+		return err
+	case len(entries) > 0:
+		return fmt.Errorf("destination is not empty (use --force to restore anyway): %s", destDir)
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,154 @@
+package skeleton
+
+import (
+	"archive/zip"
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func restoreTestArchive(t *testing.T, archivePath string) string {
+	t.Helper()
+
+	destDir := filepath.Join(t.TempDir(), "restored")
+	if err := Restore(context.Background(), []string{archivePath}, destDir, RestoreOptions{}); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	return destDir
+}
+
+func TestDirectoryModesRoundTrip(t *testing.T) {
+	fsys := fstest.MapFS{
+		"root":                 {Mode: fs.ModeDir | 0o755, ModTime: testModTime},
+		"root/private":         {Mode: fs.ModeDir | 0o700, ModTime: testModTime},
+		"root/private/file":    testFile("x"),
+		"root/readonly":        {Mode: fs.ModeDir | 0o555, ModTime: testModTime},
+		"root/readonly/nested": {Mode: fs.ModeDir | 0o750, ModTime: testModTime},
+	}
+
+	archivePath, _ := archiveTestFS(t, fsys, []string{"root"}, DefaultOptions())
+
+	entries := listTestArchive(t, archivePath)
+	assertEqual(t, entryByPath(t, entries, "root/private").Mode, "drwx------")
+	assertEqual(t, entryByPath(t, entries, "root/readonly").Mode, "dr-xr-xr-x")
+
+	destDir := restoreTestArchive(t, archivePath)
+	t.Cleanup(func() { _ = os.Chmod(filepath.Join(destDir, "root/readonly"), 0o755) }) // so that TempDir can be removed
+
+	for dir, expected := range map[string]fs.FileMode{
+		"root/private":         0o700,
+		"root/readonly":        0o555,
+		"root/readonly/nested": 0o750,
+	} {
+		info, err := os.Stat(filepath.Join(destDir, dir))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !info.IsDir() || info.Mode().Perm() != expected {
+			t.Fatalf("%s: expected %v, got %v", dir, expected, info.Mode())
+		}
+	}
+}
+
+// zip's own timestamps have at most second precision, the rest comes from our extra field
+func TestNanosecondModTimeRoundTrip(t *testing.T) {
+	fsys := fstest.MapFS{
+		"root/file": testFile("x"),
+	}
+
+	archivePath, _ := archiveTestFS(t, fsys, []string{"root"}, DefaultOptions())
+
+	assertEqual(t, entryByPath(t, listTestArchive(t, archivePath), "root/file").Modified, testModTime)
+
+	info, err := os.Stat(filepath.Join(restoreTestArchive(t, archivePath), "root/file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !info.ModTime().Truncate(time.Second).Equal(testModTime.Truncate(time.Second)) {
+		t.Fatalf("expected %v, got %v", testModTime, info.ModTime())
+	}
+
+	// filesystems with coarser timestamps (FAT, HFS+) can't hold the nanoseconds
+	if info.ModTime().Nanosecond() != 0 && !info.ModTime().Equal(testModTime) {
+		t.Fatalf("expected %v, got %v", testModTime, info.ModTime())
+	}
+}
+
+func TestEmptyDirectoriesAreKept(t *testing.T) {
+	fsys := fstest.MapFS{
+		"root/logs":       testDir(),
+		"root/data/a.txt": testFile("x"),
+	}
+
+	archivePath, _ := archiveTestFS(t, fsys, []string{"root"}, DefaultOptions())
+
+	archive, err := zip.OpenReader(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+
+	found := false
+	for _, file := range archive.File {
+		if file.Name == "root/logs/" {
+			found = file.FileInfo().IsDir()
+		}
+	}
+	if !found {
+		t.Fatal("no directory entry root/logs/")
+	}
+
+	info, err := os.Stat(filepath.Join(restoreTestArchive(t, archivePath), "root/logs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.IsDir() {
+		t.Fatal("root/logs not restored as a directory")
+	}
+}
+
+func TestSpecialModeBitsRoundTrip(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no setuid, setgid or sticky on Windows")
+	}
+
+	fsys := fstest.MapFS{
+		"root/bin/suid":  {Data: []byte("x"), Mode: fs.ModeSetuid | 0o755, ModTime: testModTime},
+		"root/bin/sgid":  {Data: []byte("x"), Mode: fs.ModeSetgid | 0o755, ModTime: testModTime},
+		"root/tmp":       {Mode: fs.ModeDir | fs.ModeSticky | 0o777, ModTime: testModTime},
+		"root/shared":    {Mode: fs.ModeDir | fs.ModeSetgid | 0o775, ModTime: testModTime},
+		"root/shared/do": testFile("x"),
+	}
+
+	archivePath, _ := archiveTestFS(t, fsys, []string{"root"}, DefaultOptions())
+
+	entries := listTestArchive(t, archivePath)
+	assertEqual(t, entryByPath(t, entries, "root/bin/suid").Mode, "urwxr-xr-x")
+	assertEqual(t, entryByPath(t, entries, "root/tmp").Mode, "dtrwxrwxrwx")
+
+	destDir := restoreTestArchive(t, archivePath)
+
+	for name, expected := range map[string]fs.FileMode{
+		"root/bin/suid": fs.ModeSetuid | 0o755,
+		"root/bin/sgid": fs.ModeSetgid | 0o755,
+		"root/tmp":      fs.ModeSticky | 0o777,
+		"root/shared":   fs.ModeSetgid | 0o775,
+	} {
+		info, err := os.Stat(filepath.Join(destDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if actual := permissionBits(info.Mode()); actual != expected {
+			t.Fatalf("%s: expected %v, got %v", name, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package skeleton
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// for recreating the skeleton without this tool. it's a trailer entry, so our restore ignores it.
+const restoreScriptName = "restore.sh"
+
+// POSIX sh, except for truncate (coreutils/BSD), which is the only portable-enough way to make a
+// file of given size without writing it. files are zero-filled, whatever the archive's fill.
+type restoreScript struct {
+	body     bytes.Buffer
+	dirModes []string // applied last and deepest first, so that non-writable dirs can still be filled
+}
+
+func newRestoreScript() *restoreScript {
+	s := &restoreScript{}
+	s.body.WriteString(`#!/bin/sh
+# Recreates this skeleton (with zero-filled files) in the current directory:
+#   mkdir dest && cd dest && sh /path/to/restore.sh
+set -eu
+
+`)
+	return s
+}
+
+func (s *restoreScript) add(e entry, hardlinkTarget string) {
+	mode := e.fileInfo.Mode()
+	p := shellPath(e.name)
+
+	switch {
+	case mode.IsDir():
+		fmt.Fprintf(&s.body, "mkdir -p %s\n", p)
+		s.dirModes = append(s.dirModes, fmt.Sprintf("chmod %s %s\n", unixPermissions(mode), p))
+		return // mtime would be changed by its children anyway
+	case hardlinkTarget != "":
+		fmt.Fprintf(&s.body, "ln %s %s\n", shellPath(hardlinkTarget), p)
+		return // shares the target's inode, so it has the mode and mtime already
+	case mode&os.ModeSymlink != 0:
+		fmt.Fprintf(&s.body, "ln -s %s %s\n", shellQuote(e.symlinkTarget), p)
+		return // chmod and touch would affect the target
+	case mode&os.ModeNamedPipe != 0:
+		fmt.Fprintf(&s.body, "mkfifo %s\n", p)
+	case isSpecialFile(mode):
+		fmt.Fprintf(&s.body, "# %s not recreated: %s\n", describeSpecialFile(mode), p)
+		return
+	default:
+		fmt.Fprintf(&s.body, "truncate -s %d %s\n", e.fileInfo.Size(), p)
+	}
+
+	fmt.Fprintf(&s.body, "chmod %s %s\n", unixPermissions(mode), p)
+	fmt.Fprintf(&s.body, "TZ=UTC0 touch -t %s %s\n", e.fileInfo.ModTime().UTC().Format("200601021504.05"), p)
+}
+
+func (s *restoreScript) bytes() []byte {
+	script := append([]byte{}, s.body.Bytes()...)
+	for i := len(s.dirModes) - 1; i >= 0; i-- {
+		script = append(script, s.dirModes[i]...)
+	}
+
+	return script
+}
+
+// relative, and prefixed with "./" so that a name starting with "-" can't be taken as an option
+func shellPath(name string) string {
+	return shellQuote("./" + strings.TrimSuffix(name, "/"))
+}
+
+// single quotes keep everything literal (incl. spaces, $ and newlines), except single quote itself
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// as chmod takes them, e.g. "4755"
+func unixPermissions(mode os.FileMode) string {
+	bits := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		bits |= 0o4000
+	}
+	if mode&os.ModeSetgid != 0 {
+		bits |= 0o2000
+	}
+	if mode&os.ModeSticky != 0 {
+		bits |= 0o1000
+	}
+
+	return fmt.Sprintf("%04o", bits)
+}
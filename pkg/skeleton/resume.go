@@ -0,0 +1,225 @@
+package skeleton
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/function61/gokit/os/osutil"
+)
+
+// how often (at most) StateFile gets rewritten during the walk. it's also written when the walk
+// gets interrupted, which is what resuming relies on.
+const stateFileInterval = 10 * time.Second
+
+// with StateFile, the walk records which of the roots' immediate children (i.e. top-level paths)
+// have had their subtrees written, and how many entries the archive had at that point. an
+// interrupted walk can then be resumed: the partial archive's first that many entries are kept, and
+// the walk continues from the top-level path it was in the middle of, which gets walked again from
+// its start.
+//
+// guarantees are best-effort: changes made to the completed paths in between aren't seen, and a
+// hardlink can't refer to a file that was written by an earlier run (so it gets stored as a file).
+type resumeState struct {
+	Version int           `json:"version"`
+	Roots   []resumedRoot `json:"roots"`
+	Entries int           `json:"entries"` // written to the archive when the recorded paths were done
+	Updated time.Time     `json:"updated"`
+}
+
+const resumeStateVersion = 1
+
+type resumedRoot struct {
+	Path      string   `json:"path"`                // as given
+	Started   bool     `json:"started,omitempty"`   // its own entry has been written
+	Completed []string `json:"completed,omitempty"` // immediate children whose subtrees have been written, in walk order
+	Done      bool     `json:"done,omitempty"`      // walked entirely
+}
+
+// where in the walk an entry is: under which root, and under which of its immediate children
+type walkPosition struct {
+	root  int
+	child string // "" for the root itself
+}
+
+type resumeTracker struct {
+	path      string // of StateFile
+	state     resumeState
+	completed map[walkPosition]bool // of state, for quick lookups
+	current   *walkPosition         // of the last entry written. nil before the first one
+	written   int                   // entries written to the archive, including the kept ones
+	saved     time.Time
+}
+
+// reads StateFile if a previous walk left one, or starts from scratch
+func newResumeTracker(path string, roots []string) (*resumeTracker, error) {
+	r := &resumeTracker{
+		path:      path,
+		completed: map[walkPosition]bool{},
+		saved:     time.Now(),
+	}
+
+	stateJSON, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+
+		r.state = resumeState{Version: resumeStateVersion}
+		for _, root := range roots {
+			r.state.Roots = append(r.state.Roots, resumedRoot{Path: root})
+		}
+
+		return r, nil
+	}
+
+	if err := json.Unmarshal(stateJSON, &r.state); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if r.state.Version != resumeStateVersion {
+		return nil, fmt.Errorf("%s: unsupported version %d", path, r.state.Version)
+	}
+
+	if !r.sameRoots(roots) {
+		return nil, fmt.Errorf("%s is of a walk of other directories. remove it to start over", path)
+	}
+
+	for i, root := range r.state.Roots {
+		for _, child := range root.Completed {
+			r.completed[walkPosition{root: i, child: child}] = true
+		}
+	}
+
+	return r, nil
+}
+
+func (r *resumeTracker) sameRoots(roots []string) bool {
+	if len(roots) != len(r.state.Roots) {
+		return false
+	}
+
+	for i, root := range roots {
+		if r.state.Roots[i].Path != root {
+			return false
+		}
+	}
+
+	return true
+}
+
+// whether there's an earlier walk's progress to continue from
+func (r *resumeTracker) resuming() bool {
+	return r.state.Entries > 0
+}
+
+func (r *resumeTracker) rootDone(root int) bool {
+	return r.state.Roots[root].Done
+}
+
+// whether an entry (and its subtree) was written by an earlier walk
+func (r *resumeTracker) doneEarlier(pos walkPosition) bool {
+	if pos.child == "" {
+		return r.state.Roots[pos.root].Started
+	}
+
+	return r.completed[pos]
+}
+
+// writes the partial archive's entries that the earlier walk recorded as done
+func (r *resumeTracker) keep(ctx context.Context, partialPath string, state *walkState, write func(entry) error) error {
+	a := &archiveWalker{
+		ctx:        ctx,
+		sourcePath: partialPath,
+		state:      state,
+		opts:       Options{Prefix: ".", MaxSize: -1}, // i.e. no filters
+		visit: func(e entry) error {
+			if err := write(e); err != nil {
+				return err
+			}
+
+			r.written++
+			return nil
+		},
+		limit: r.state.Entries,
+	}
+
+	if err := a.run(); err != nil && !errors.Is(err, errEntryLimitReached) {
+		return fmt.Errorf("%s: %w", partialPath, err)
+	}
+
+	if r.written != r.state.Entries {
+		return fmt.Errorf("%s has only %d entries, but %s records %d (was the earlier walk killed before it could finish the archive?). remove the state file to start over", partialPath, r.written, r.path, r.state.Entries)
+	}
+
+	return nil
+}
+
+// wraps the output, so that an entry arriving at another position means the previous one is done
+func (r *resumeTracker) visit(pos walkPosition, write func() error) error {
+	if r.current != nil && *r.current != pos {
+		if err := r.done(*r.current, pos); err != nil {
+			return err
+		}
+	}
+	r.current = &pos
+
+	if err := write(); err != nil {
+		return err
+	}
+	r.written++
+
+	return nil
+}
+
+// previous is done, since the walk got to next
+func (r *resumeTracker) done(previous walkPosition, next walkPosition) error {
+	root := &r.state.Roots[previous.root]
+	if previous.child == "" {
+		root.Started = true
+	} else {
+		root.Completed = append(root.Completed, previous.child)
+		r.completed[previous] = true
+	}
+
+	for i := previous.root; i < next.root; i++ { // walked past these roots
+		r.state.Roots[i].Done = true
+	}
+
+	r.state.Entries = r.written
+
+	if time.Since(r.saved) < stateFileInterval {
+		return nil
+	}
+
+	return r.save()
+}
+
+func (r *resumeTracker) save() error {
+	r.state.Updated = time.Now().UTC()
+	r.saved = time.Now()
+
+	return osutil.WriteFileAtomic(r.path, func(file io.Writer) error {
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(r.state)
+	})
+}
+
+// records the progress for resuming if the walk didn't finish, and otherwise removes StateFile
+func (r *resumeTracker) finish(interrupted bool) error {
+	if interrupted {
+		return r.save()
+	}
+
+	if err := os.Remove(r.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package skeleton
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+// http.DetectContentType() looks at most at this many bytes
+const detectTypeLen = 512
+
+// with KeepContentBelow, small files are stored whole with their real content (see entry.content)
+func (o Options) keepsContent(size int64) bool {
+	return size < o.KeepContentBelow
+}
+
+// how much of the file's real content to keep at the start of its entry. for sniffing file types
+// from the skeleton (magic numbers are in the first bytes). 0 for files that are kept whole, as
+// their content gets streamed instead.
+func (o Options) sampleLen(size int64) int64 {
+	if o.keepsContent(size) {
+		return 0
+	}
+
+	if int64(o.SampleBytes) < size {
+		return int64(o.SampleBytes)
+	}
+
+	return size
+}
+
+// how much of the file's start needs to be read, for the sample and/or detecting the type
+func (o Options) headLen(size int64) int64 {
+	n := o.sampleLen(size)
+	if o.DetectType && n < detectTypeLen {
+		n = detectTypeLen
+		if size < n {
+			n = size
+		}
+	}
+
+	return n
+}
+
+// head is what headLen() asked for (or less, if the file shrank). it can be longer than the
+// sample, for detecting the type.
+func (e *entry) setHead(head []byte, opts Options) {
+	sample := head
+	if n := opts.sampleLen(e.fileInfo.Size()); n < int64(len(sample)) {
+		sample = sample[:n]
+	}
+	if len(sample) > 0 {
+		e.sample = sample
+	}
+
+	if opts.DetectType && len(head) > 0 { // an empty file has no type
+		e.contentType = detectContentType(head)
+	}
+}
+
+// types that http.DetectContentType() (which implements what browsers sniff) doesn't know
+var extraMagicNumbers = []struct {
+	prefix      string
+	contentType string
+}{
+	{"\x7fELF", "application/x-executable"},
+	{"MZ", "application/vnd.microsoft.portable-executable"},
+	{"\xfe\xed\xfa\xce", "application/x-mach-binary"},
+	{"\xfe\xed\xfa\xcf", "application/x-mach-binary"},
+	{"\xce\xfa\xed\xfe", "application/x-mach-binary"},
+	{"\xcf\xfa\xed\xfe", "application/x-mach-binary"},
+	{"SQLite format 3\x00", "application/vnd.sqlite3"},
+	{"\xfd7zXZ\x00", "application/x-xz"},
+	{"\x28\xb5\x2f\xfd", "application/zstd"},
+	{"BZh", "application/x-bzip2"},
+}
+
+func detectContentType(head []byte) string {
+	detected := http.DetectContentType(head)
+	if detected != "application/octet-stream" { // = unknown
+		return detected
+	}
+
+	for _, magic := range extraMagicNumbers {
+		if strings.HasPrefix(string(head), magic.prefix) {
+			return magic.contentType
+		}
+	}
+
+	return detected
+}
+
+func sampleFile(fsys fs.FS, name string, n int64) ([]byte, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return readSample(file, n)
+}
+
+// how much of the entry's content is real, rather than fill
+func (e entry) realContentLen() int64 {
+	if e.content != nil {
+		return e.fileInfo.Size()
+	}
+
+	return int64(len(e.sample))
+}
+
+// a file that shrank after it was stat'd gives a shorter sample
+func readSample(content io.Reader, n int64) ([]byte, error) {
+	sample := make([]byte, n)
+
+	read, err := io.ReadFull(content, sample)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	return sample[:read], nil
+}
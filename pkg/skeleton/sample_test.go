@@ -0,0 +1,159 @@
+package skeleton
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+var (
+	keptContent   = "small config"
+	secretContent = "SECRET " + strings.Repeat("s", 6000)
+)
+
+func zipEntryContent(t *testing.T, archivePath string, name string) []byte {
+	t.Helper()
+
+	archive, err := zip.OpenReader(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+
+	for _, file := range archive.File {
+		if file.Name != name {
+			continue
+		}
+
+		content, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer content.Close()
+
+		data, err := io.ReadAll(content)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return data
+	}
+
+	t.Fatalf("no entry %s", name)
+	return nil
+}
+
+// files at or above the threshold must be pure fill, even though DetectType reads their start
+func assertOnlySmallFileKept(t *testing.T, archivePath string, prefix string) {
+	t.Helper()
+
+	assertEqual(t, string(zipEntryContent(t, archivePath, prefix+"small.conf")), keptContent)
+
+	large := zipEntryContent(t, archivePath, prefix+"large.bin")
+	assertEqual(t, len(large), len(secretContent))
+	if !bytes.Equal(large, make([]byte, len(secretContent))) {
+		t.Fatalf("real content in %slarge.bin: %q...", prefix, large[:16])
+	}
+
+	entries := listTestArchive(t, archivePath)
+	assertEqual(t, entryByPath(t, entries, prefix+"large.bin").ContentType, "text/plain; charset=utf-8")
+
+	smallDigest := sha256.Sum256([]byte(keptContent))
+	assertEqual(t, entryByPath(t, entries, prefix+"small.conf").SHA256, hex.EncodeToString(smallDigest[:]))
+}
+
+func keepContentTestOptions() Options {
+	opts := DefaultOptions()
+	opts.KeepContentBelow = 1000
+	opts.DetectType = true
+	opts.Hash = HashSHA256
+	return opts
+}
+
+func TestKeepContentBelowDoesntLeakLargerFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"root/small.conf": testFile(keptContent),
+		"root/large.bin":  testFile(secretContent),
+	}
+
+	archivePath, _ := archiveTestFS(t, fsys, []string{"root"}, keepContentTestOptions())
+	assertOnlySmallFileKept(t, archivePath, "root/")
+
+	destDir := restoreTestArchive(t, archivePath)
+	restored, err := os.ReadFile(filepath.Join(destDir, "root", "small.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, string(restored), keptContent)
+}
+
+// when sorting spills entries to disk, the kept content has to go along as the file isn't open
+func TestKeepContentBelowWhenSpilled(t *testing.T) {
+	withSortRunEntries(t, 1)
+
+	fsys := fstest.MapFS{
+		"root/small.conf": testFile(keptContent),
+		"root/large.bin":  testFile(secretContent),
+		"root/other":      testFile("x"),
+	}
+
+	opts := keepContentTestOptions()
+	opts.Reproducible = true
+
+	archivePath, _ := archiveTestFS(t, fsys, []string{"root"}, opts)
+	assertOnlySmallFileKept(t, archivePath, "root/")
+	assertEqual(t, string(zipEntryContent(t, archivePath, "root/other")), "x")
+}
+
+func TestSkeletonizeKeepContentBelowDoesntLeakLargerFiles(t *testing.T) {
+	source := bytes.Buffer{}
+	tarWriter := tar.NewWriter(&source)
+	for _, file := range []struct {
+		name    string
+		content string
+	}{
+		{"small.conf", keptContent},
+		{"large.bin", secretContent},
+	} {
+		if err := tarWriter.WriteHeader(&tar.Header{Name: file.name, Mode: 0o644, Size: int64(len(file.content)), ModTime: testModTime, Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tarWriter.Write([]byte(file.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	sourcePath := filepath.Join(t.TempDir(), "source.tar")
+	if err := os.WriteFile(sourcePath, source.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, reproducible := range []bool{false, true} {
+		opts := keepContentTestOptions()
+		opts.Reproducible = reproducible
+
+		output := bytes.Buffer{}
+		if _, err := Skeletonize(context.Background(), &output, sourcePath, opts); err != nil {
+			t.Fatal(err)
+		}
+
+		archivePath := filepath.Join(t.TempDir(), "out.zip")
+		if err := os.WriteFile(archivePath, output.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		assertOnlySmallFileKept(t, archivePath, "")
+	}
+}
@@ -0,0 +1,1379 @@
+// Package skeleton creates archives that represent how a directory hierarchy looks like (names,
+// sizes, timestamps, permissions etc.) without storing file contents, and restores and verifies them.
+package skeleton
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"math"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/function61/gokit/app/dynversion"
+	"github.com/function61/gokit/log/logex"
+)
+
+// Options controls what gets archived and how. Start from DefaultOptions(), because the zero
+// value of some fields (like MaxDepth) has a different meaning than "unlimited".
+type Options struct {
+	Format            string   // FormatZip, FormatTar, FormatTarGz, FormatJSON, FormatJSONL, FormatYAML, FormatCSV, FormatTree, FormatDot or FormatHTML
+	Compression       string   // CompressionDeflate or CompressionZstd (only for FormatZip)
+	CompressionLevel  int      // in the compression's own scale (deflate 0-9, zstd 1-22). CompressionLevelDefault = the compression's default
+	FilesFrom         string   // instead of walking the roots, archive paths listed in this file ("-" = stdin). "" = walk
+	Excludes          []string // glob patterns. see matchesAnyPattern()
+	Gitignore         bool
+	Skeletonignore    bool // skip entries matching patterns in .skeletonignore files encountered along the walk (see skeletonignoreName)
+	NoHidden          bool
+	MaxDepth          int // -1 = unlimited
+	OneFileSystem     bool
+	FollowSymlinks    bool      // record symlinks as their targets, and walk linked dirs. each dir is walked only once, which protects against loops
+	MinSize           int64     // files smaller than this are skipped
+	MaxSize           int64     // files larger than this are skipped. -1 = no limit
+	NewerThan         time.Time // files modified before this are skipped. zero = no limit
+	OlderThan         time.Time // files modified after this are skipped. zero = no limit
+	Extensions        []string  // if given, files with other extensions are skipped. with or without the dot, case-insensitive
+	ExcludeExtensions []string  // files with these extensions are skipped
+	DirsOnly          bool      // skip everything except directories
+	PruneEmptyDirs    bool
+	WithSizes         bool   // record each directory's subtree totals (file count and bytes)
+	WithCounts        bool   // record each directory's number of children and descendants
+	WithAllocation    bool   // record files' on-disk allocation (where the platform has it), which tells sparse files apart. not for tar formats
+	SkipErrors        bool   // leave out entries that can't be read (recorded in Stats.Errors) instead of failing
+	RelativeTo        string // "" = each root's parent, so that several roots stay apart (see Disambiguate)
+	Prefix            string // prepended to entry names
+	Disambiguate      bool
+	StripComponents   int       // drop this many leading components from names (before Prefix is added). entries with no more components are left out
+	NameHash          bool      // replace each path component with a short hash of it, to redact the names
+	NormalizeUnicode  bool      // store names in Unicode NFC, so that archives made on macOS (NFD) compare equal to others
+	CheckPortability  bool      // report names that Windows (or FAT) can't have, counted in Stats.Unportable
+	SanitizeNames     bool      // rewrite names that Windows can't have to ones it can (illegal characters => "_" etc.)
+	KeepExtensions    bool      // with NameHash, keep files' extensions
+	NameMap           io.Writer // with NameHash or SanitizeNames, a mapping (JSON lines of new and original names) is written here. nil = don't write
+	Hash              string    // "" = no hashing
+	FillByte          string    // hex
+	DetectType        bool      // detect each file's MIME type from the start of its real content
+	SampleBytes       int       // keep this many bytes of each file's real content at the start of its entry (only for FormatZip). 0 = none
+	KeepContentBelow  int64     // files smaller than this are stored with their real content, making a partial archive (only for FormatZip). 0 = none
+	Xattrs            bool
+	FileAttrs         bool // record Linux inode flags (immutable, append-only etc.), which restore reapplies. only for FormatZip
+	AllTimes          bool // also record access and change times (where the platform has them), e.g. for forensic timelines
+	Owners            bool
+	Concurrency       int
+	Top               int   // report this many of the largest files in Stats.Largest
+	LimitEntries      int   // stop the walk after this many entries (Stats.Truncated tells if it happened). 0 = no limit
+	Threads           int   // read files' content (for Hash, DetectType and SampleBytes) with this many goroutines. entries are still visited in walk order
+	MaxReadRate       int64 // read files' content at most this many bytes per second (over all Threads). 0 = unlimited
+	MaxEntriesRate    int   // walk at most this many entries per second (over all Concurrency). 0 = unlimited
+	Reproducible      bool
+	Password          []byte                                  // non-nil = encrypt the archive
+	Append            string                                  // existing skeleton (zip) whose entries are kept, with the roots' entries added after them. only for Archive()
+	Since             []string                                // previous skeleton (all volumes of it, zip). if given, only entries that are new or changed since it are archived (see manifestIncremental)
+	SincePassword     func() ([]byte, error)                  // asked only if the previous skeleton is encrypted
+	Fingerprint       bool                                    // compute a Merkle root of the tree (see fingerprinter) into Stats, the manifest and the zip comment. only for FormatZip and tar formats
+	DryRun            bool                                    // walk (with filters applied) and list, but write nothing. content isn't read
+	ReadmeName        string                                  // of the README entry. "" = README-this-archive-is-special.txt
+	ReadmeContent     []byte                                  // replaces the generated README text. nil = generated
+	NoReadme          bool                                    // leave the README out (the manifest still describes the archive)
+	RestoreScript     bool                                    // also write a shell script (only for FormatZip and tar formats, and not with SplitSize) that recreates the skeleton
+	SplitSize         int64                                   // start a new volume (only for FormatZip) before the current one would exceed this. 0 = don't split
+	NextVolume        func(number int) (io.Writer, error)     // with SplitSize, asked for volumes after the first one (which is the writer given to Archive())
+	SplitByTopLevel   bool                                    // write each of the root's subdirectories to an archive of its own (only for FormatZip, with one root). the rest goes to the writer given to Archive()
+	TopLevelOutput    func(subdir string) (io.Writer, error)  // with SplitByTopLevel, asked for each subdirectory's archive (by its name). closed (if an io.Closer) once its archive is finished
+	StateFile         string                                  // record the walk's progress here, so that an interrupted walk can be resumed (see resumeState). removed once the walk completes
+	Resume            string                                  // with StateFile of an interrupted walk, its partial archive (zip or tar). its entries that were done are kept, and not walked again
+	Progress          string                                  // ProgressNone, ProgressLine or ProgressBar. status line is drawn on stderr
+	CountFirst        bool                                    // walk once (metadata only) before the real pass, so that progress can show a percentage and an ETA
+	Logger            *log.Logger                             // per-path listing and warnings. nil = discard
+	EntryLog          func(path string, fileInfo fs.FileInfo) // if set, each entry is listed by calling this instead of via Logger (e.g. for structured logs)
+	Quiet             bool                                    // don't list each path (warnings and errors are still logged)
+
+	fill        []byte         // parsed from FillByte
+	roots       []string       // as given, for the manifest
+	resume      *resumeTracker // with StateFile, for the walk to skip what was done earlier
+	sourceOrder bool           // entries come in the source archive's order (Skeletonize), which needn't keep a subtree together
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Format:           FormatZip,
+		Compression:      CompressionDeflate,
+		CompressionLevel: CompressionLevelDefault,
+		MaxDepth:         -1,
+		MaxSize:          -1,
+		FillByte:         "00",
+		Concurrency:      1,
+		Threads:          1,
+		Progress:         ProgressNone,
+	}
+}
+
+const readmeName = "README-this-archive-is-special.txt"
+
+func (o Options) readmeEntryName() string {
+	if o.ReadmeName != "" {
+		return o.ReadmeName
+	}
+
+	return readmeName
+}
+
+var errEntryLimitReached = errors.New("entry limit reached")
+
+// Archive writes a skeleton archive of the roots (or with FilesFrom, of the listed paths) to w.
+//
+// If ctx is canceled mid-walk, the output is still finalized so that it's valid (but incomplete).
+// in that case the returned Stats has Interrupted set, along with the error. with StateFile the
+// same goes for errors of the walk, so that it can be resumed.
+func Archive(ctx context.Context, w io.Writer, roots []string, opts Options) (Stats, error) {
+	walkRoots, err := osWalkRoots(roots, opts)
+	if err != nil {
+		return Stats{}, err
+	}
+	opts.roots = roots
+
+	source := walkSource(walkRoots)
+	if opts.Append != "" {
+		existingRoots, err := appendedRoots(opts.Append)
+		if err != nil {
+			return Stats{}, fmt.Errorf("%s: %w", opts.Append, err)
+		}
+
+		opts.roots = append(existingRoots, roots...)
+		source = appendSource(opts.Append, source)
+	}
+
+	return archive(ctx, w, source, opts)
+}
+
+func osWalkRoots(roots []string, opts Options) ([]walkRoot, error) {
+	switch {
+	case opts.FilesFrom == "" && len(roots) == 0:
+		return nil, errors.New("no directories given (and no --files-from)")
+	case opts.FilesFrom != "" && len(roots) > 0:
+		return nil, errors.New("directories can't be given with --files-from")
+	}
+
+	// the extended-length prefix (if the user gave it) must not end up in the names
+	plainRoots := []string{}
+	for _, root := range roots {
+		plainRoots = append(plainRoots, stripExtendedLengthPrefix(root))
+	}
+
+	names, err := rootArchiveNames(plainRoots, opts.RelativeTo, opts.Disambiguate, rootArchiveName)
+	if err != nil {
+		return nil, err
+	}
+
+	walkRoots := []walkRoot{}
+	for i, root := range roots {
+		fsys := newOSDirFS(extendedLengthPath(root))
+		fsys.followSymlinks = opts.FollowSymlinks
+
+		walkRoots = append(walkRoots, walkRoot{fsys: fsys, dir: ".", name: names[i]})
+	}
+
+	return walkRoots, nil
+}
+
+// ArchiveFS is like Archive(), but the roots are dirs (or files) in fsys, e.g. an embed.FS or
+// fstest.MapFS. use "." for the whole fsys.
+//
+// symlink targets are recorded only if fsys has a ReadLink(name) method, and xattrs, owners and
+// hardlinks only for the OS filesystem. FilesFrom is not supported.
+func ArchiveFS(ctx context.Context, w io.Writer, fsys fs.FS, roots []string, opts Options) (Stats, error) {
+	switch {
+	case opts.FilesFrom != "":
+		return Stats{}, errors.New("FilesFrom is not supported for fs.FS")
+	case len(roots) == 0:
+		return Stats{}, errors.New("no roots given")
+	}
+
+	for _, root := range roots {
+		if !fs.ValidPath(root) {
+			return Stats{}, &fs.PathError{Op: "archive", Path: root, Err: fs.ErrInvalid}
+		}
+	}
+
+	names, err := rootArchiveNames(roots, opts.RelativeTo, opts.Disambiguate, fsRootArchiveName)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	walkRoots := []walkRoot{}
+	for i, root := range roots {
+		walkRoots = append(walkRoots, walkRoot{fsys: fsys, dir: root, name: names[i]})
+	}
+	opts.roots = roots
+
+	return archive(ctx, w, walkSource(walkRoots), opts)
+}
+
+// produces the entries to archive. visit must not be called concurrently.
+type entrySource func(ctx context.Context, state *walkState, opts Options, visit func(entry) error) error
+
+func walkSource(roots []walkRoot) entrySource {
+	return func(ctx context.Context, state *walkState, opts Options, visit func(entry) error) error {
+		if opts.FilesFrom != "" {
+			return walkFilesFrom(ctx, opts.FilesFrom, state, opts, visit)
+		} else {
+			return walk(ctx, roots, state, opts, visit)
+		}
+	}
+}
+
+func archive(ctx context.Context, w io.Writer, source entrySource, opts Options) (Stats, error) {
+	if opts.Logger == nil {
+		opts.Logger = logex.Discard
+	}
+
+	if opts.DryRun { // nothing would be stored of these
+		opts.Hash, opts.DetectType, opts.SampleBytes, opts.KeepContentBelow = "", false, 0, 0
+		opts.SplitSize, opts.Password, opts.RestoreScript, opts.Fingerprint = 0, nil, false, false
+	}
+
+	if err := validatePatterns(opts.Excludes); err != nil {
+		return Stats{}, err
+	}
+
+	fill, err := parseFillPattern(opts.FillByte)
+	if err != nil {
+		return Stats{}, err
+	}
+	opts.fill = fill
+
+	switch opts.Hash {
+	case "", HashSHA256:
+	default:
+		return Stats{}, fmt.Errorf("unsupported hash: %s", opts.Hash)
+	}
+
+	switch opts.Compression {
+	case CompressionDeflate:
+	case CompressionZstd:
+		if opts.Format != FormatZip {
+			return Stats{}, errors.New("compression is only supported for zip format")
+		}
+	default:
+		return Stats{}, fmt.Errorf("unsupported compression: %s", opts.Compression)
+	}
+
+	if err := validateCompressionLevel(opts.Compression, opts.CompressionLevel); err != nil {
+		return Stats{}, err
+	}
+
+	if opts.CompressionLevel != CompressionLevelDefault && opts.Format != FormatZip {
+		return Stats{}, errors.New("compression level is only supported for zip format")
+	}
+
+	if opts.SampleBytes < 0 || (opts.SampleBytes > 0 && opts.Format != FormatZip) {
+		return Stats{}, errors.New("content samples need a positive size, and are only supported for zip format")
+	}
+
+	if opts.KeepContentBelow < 0 || opts.KeepContentBelow > math.MaxUint32 || (opts.KeepContentBelow > 0 && opts.Format != FormatZip) {
+		return Stats{}, errors.New("keeping content needs a threshold of 0-4 GiB, and is only supported for zip format")
+	}
+
+	if (opts.Format == FormatTar || opts.Format == FormatTarGz) && (opts.Hash != "" || opts.DetectType || opts.WithSizes || opts.WithCounts || opts.FileAttrs || opts.WithAllocation) {
+		return Stats{}, errors.New("hashes, content types, subtree sizes and counts, file attributes and allocation aren't supported for tar formats")
+	}
+
+	if opts.StripComponents < 0 || opts.LimitEntries < 0 || opts.Top < 0 {
+		return Stats{}, errors.New("StripComponents, LimitEntries and Top can't be negative")
+	}
+
+	if opts.KeepExtensions && !opts.NameHash {
+		return Stats{}, errors.New("KeepExtensions needs NameHash")
+	}
+
+	if opts.NameMap != nil && !opts.NameHash && !opts.SanitizeNames {
+		return Stats{}, errors.New("NameMap needs NameHash or SanitizeNames")
+	}
+
+	if opts.SanitizeNames && opts.NameHash {
+		return Stats{}, errors.New("SanitizeNames isn't needed with NameHash, as hashed names are portable")
+	}
+
+	if opts.NoReadme && (opts.ReadmeName != "" || opts.ReadmeContent != nil) {
+		return Stats{}, errors.New("NoReadme can't be combined with ReadmeName or ReadmeContent")
+	}
+
+	// at the top level, and not clashing with the other trailer entries
+	if name := opts.ReadmeName; name != "" && (strings.ContainsAny(name, `/\`) || name == "." || name == ".." || name == manifestName || name == restoreScriptName) {
+		return Stats{}, fmt.Errorf("invalid README name: %s", name)
+	}
+
+	opts.Prefix, err = normalizeArchivePrefix(opts.Prefix)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	if opts.SplitSize > 0 && (opts.Format != FormatZip || opts.NextVolume == nil) {
+		return Stats{}, errors.New("splitting is only supported for zip format, and needs NextVolume")
+	}
+
+	if opts.RestoreScript && (opts.SplitSize > 0 || !(opts.Format == FormatZip || opts.Format == FormatTar || opts.Format == FormatTarGz)) {
+		return Stats{}, errors.New("restore script is only supported for zip and tar formats, and not when splitting")
+	}
+
+	if opts.Fingerprint && !(opts.Format == FormatZip || opts.Format == FormatTar || opts.Format == FormatTarGz) {
+		return Stats{}, errors.New("fingerprint is only supported for zip and tar formats")
+	}
+
+	if opts.SplitByTopLevel {
+		switch {
+		case opts.Format != FormatZip || opts.TopLevelOutput == nil:
+			return Stats{}, errors.New("splitting by top-level directory is only supported for zip format, and needs TopLevelOutput")
+		case len(opts.roots) != 1 || opts.FilesFrom != "":
+			return Stats{}, errors.New("splitting by top-level directory needs exactly one root")
+		case opts.SplitSize > 0 || len(opts.Since) > 0 || opts.Append != "":
+			return Stats{}, errors.New("splitting by top-level directory can't be combined with splitting by size, incremental or appending")
+		}
+	}
+
+	if opts.StateFile != "" {
+		switch {
+		case !(opts.Format == FormatZip || opts.Format == FormatTar || opts.Format == FormatTarGz) || opts.DryRun || opts.Password != nil:
+			return Stats{}, errors.New("state file is only supported for unencrypted zip and tar formats (that can be read back), and not with dry run")
+		case opts.FilesFrom != "" || opts.Append != "" || len(opts.Since) > 0 || opts.SplitSize > 0 || opts.SplitByTopLevel:
+			return Stats{}, errors.New("state file can't be combined with FilesFrom, appending, incremental or splitting")
+		case opts.Concurrency > 1 || opts.Reproducible || opts.PruneEmptyDirs || opts.WithSizes || opts.WithCounts:
+			// the progress is tracked by the order in which entries get written
+			return Stats{}, errors.New("state file needs entries written in walk order: not with concurrency, reproducible, pruning or subtree sizes and counts")
+		}
+	} else if opts.Resume != "" {
+		return Stats{}, errors.New("Resume needs StateFile")
+	}
+
+	// the deletions are known only at the end, so they'd not fit the pessimistic estimate of the last volume
+	if len(opts.Since) > 0 && (opts.SplitSize > 0 || !(opts.Format == FormatZip || opts.Format == FormatTar || opts.Format == FormatTarGz)) {
+		return Stats{}, errors.New("incremental archives are only supported for zip and tar formats, and not when splitting")
+	}
+
+	switch { // rather than silently leaving out what was asked for
+	case opts.AllTimes && !fileTimesSupported:
+		return Stats{}, errors.New("AllTimes is only supported on Linux")
+	case opts.FileAttrs && !fileAttrsSupported:
+		return Stats{}, errors.New("FileAttrs is only supported on Linux")
+	case (opts.Owners || opts.WithAllocation) && !fileIDsSupported:
+		return Stats{}, errors.New("Owners and WithAllocation are not supported on this platform")
+	}
+
+	if opts.OneFileSystem && !fileIDsSupported {
+		logex.Levels(opts.Logger).Info.Println("--one-file-system not supported on this platform. ignoring.")
+	}
+
+	if opts.FollowSymlinks && !fileIDsSupported {
+		logex.Levels(opts.Logger).Info.Println("--follow-symlinks: loops can't be detected on this platform, so only symlinks to files are followed.")
+	}
+
+	stats, err := writeArchive(ctx, source, w, opts)
+	if stats == nil {
+		return Stats{}, err
+	}
+
+	return *stats, err
+}
+
+func writeArchive(ctx context.Context, source entrySource, file io.Writer, opts Options) (*Stats, error) {
+	pathsLogger := opts.Logger
+	if opts.Quiet {
+		pathsLogger = logex.Discard
+	}
+
+	progress, err := newProgressReporter(opts.Progress, pathsLogger, os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	if !opts.Quiet {
+		progress.entryLog = opts.EntryLog
+	}
+
+	vols, err := newVolumes(file, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.CountFirst && progress.status != nil { // the total would have nowhere to be shown
+		if err := countFirst(ctx, source, opts, progress); err != nil {
+			return nil, err
+		}
+	}
+
+	state := newWalkState(progress, opts.Logger)
+
+	var output sink
+	if opts.SplitByTopLevel && !opts.DryRun {
+		output, err = newTopLevelSink(vols, state, opts)
+	} else {
+		output, err = newSink(opts, vols, state)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// walk order is lexical only within a directory (and roots are in order given), so for
+	// reproducibility we need to see all entries before writing them in sorted order.
+	// likewise a directory can only be known to be empty (or its size) once the whole walk is done.
+	collectFirst := opts.Reproducible || opts.PruneEmptyDirs || opts.WithSizes || opts.WithCounts
+	collected := []entry{}
+	visit := output.Entry
+
+	// innermost, so that only what got written counts as done
+	var resume *resumeTracker
+	if opts.StateFile != "" {
+		resume, err = newResumeTracker(opts.StateFile, opts.roots)
+		if err != nil {
+			return nil, err
+		}
+
+		if resume.resuming() {
+			if opts.Resume == "" {
+				return nil, fmt.Errorf("%s records an interrupted walk, but its partial archive isn't there to resume from. remove the state file to start over", opts.StateFile)
+			}
+
+			if err := resume.keep(ctx, opts.Resume, state, output.Entry); err != nil {
+				return nil, err
+			}
+		}
+
+		opts.resume = resume
+
+		unrecorded := visit
+		visit = func(e entry) error {
+			return resume.visit(e.position, func() error { return unrecorded(e) })
+		}
+	}
+
+	if collectFirst {
+		visit = func(e entry) error {
+			collected = append(collected, e)
+			return nil
+		}
+	}
+
+	// just sorting can spill to disk, so memory stays bounded for huge trees. pruning and the
+	// directories' totals need all the entries at hand.
+	var sorter *entrySorter
+	if opts.Reproducible && !opts.PruneEmptyDirs && !opts.WithSizes && !opts.WithCounts {
+		sorter = &entrySorter{}
+		defer sorter.close()
+
+		visit = sorter.add
+	}
+
+	// inside of the limit, so that only archived entries count. bounded, so memory use stays flat.
+	if opts.Top > 0 {
+		untracked := visit
+		visit = func(e entry) error {
+			if e.fileInfo.Mode().IsRegular() && e.hardlinkTarget == "" { // hardlinks known upfront (from a source archive) would duplicate their target
+				state.stats.Largest = keepLargest(state.stats.Largest, FileSize{Path: e.path, Size: e.fileInfo.Size()}, opts.Top)
+			}
+
+			return untracked(e)
+		}
+	}
+
+	// innermost (of the filtering ones), so that entries left out by the other wrappers don't count
+	if opts.LimitEntries > 0 {
+		unlimited := visit
+		visited := 0
+		visit = func(e entry) error {
+			if visited == opts.LimitEntries {
+				state.stats.uncount(e.fileInfo)
+				return errEntryLimitReached // stops the walk
+			}
+			visited++
+
+			return unlimited(e)
+		}
+	}
+
+	// outside of the limit, so that unchanged entries don't count towards it
+	var incremental *incrementalState
+	if len(opts.Since) > 0 {
+		incremental, err = newIncrementalState(opts)
+		if err != nil {
+			return nil, fmt.Errorf("previous skeleton: %w", err)
+		}
+
+		changed := visit
+		visit = func(e entry) error {
+			if incremental.unchanged(e) {
+				state.stats.uncount(e.fileInfo)
+				state.stats.Skipped[skippedByUnchanged]++
+				return nil
+			}
+
+			return changed(e)
+		}
+	}
+
+	if opts.NameHash { // before collecting, so that sorting only sees the hashed names
+		hasher := newNameHasher(opts)
+		unredacted := visit
+		visit = func(e entry) error {
+			e, err := hasher.redact(e)
+			if err != nil {
+				return err
+			}
+
+			return unredacted(e)
+		}
+	}
+
+	if opts.CheckPortability || opts.SanitizeNames {
+		checker := newPortabilityChecker(opts, state)
+		unchecked := visit
+		visit = func(e entry) error {
+			e, err := checker.visit(e)
+			if err != nil {
+				return err
+			}
+
+			return unchecked(e)
+		}
+	}
+
+	// outside of hashing, so that a name hashes the same regardless of how the source stores it
+	if opts.NormalizeUnicode {
+		unnormalized := visit
+		visit = func(e entry) error {
+			return unnormalized(normalizeEntryNames(e))
+		}
+	}
+
+	// outermost, so that the names get hashed only after stripping
+	if opts.StripComponents > 0 {
+		unstripped := visit
+		visit = func(e entry) error {
+			stripped, ok := stripEntryComponents(e, opts.StripComponents, opts.Prefix)
+			if !ok {
+				state.logl.Debug.Printf("%s: nothing left after stripping components. leaving out.", e.path)
+				state.stats.uncount(e.fileInfo)
+				state.stats.Skipped[skippedByStripping]++
+				return nil
+			}
+
+			return unstripped(stripped)
+		}
+	}
+
+	walkErr := source(ctx, state, opts, visit)
+	if errors.Is(walkErr, errEntryLimitReached) {
+		state.stats.Truncated = true
+		walkErr = nil // Truncated tells the caller
+	} else if walkErr != nil {
+		if ctx.Err() == nil && resume == nil { // a genuine error
+			return nil, walkErr
+		}
+
+		// interrupted (or with StateFile, resumable). still finalize the output so that the partial archive is valid.
+		state.stats.Interrupted = true
+	}
+
+	if incremental != nil && !state.stats.Interrupted && !state.stats.Truncated { // the rest of the tree wasn't seen
+		state.stats.Deleted = incremental.deleted()
+	}
+
+	if sorter != nil {
+		if err := sorter.sorted(output.Entry); err != nil {
+			return nil, err
+		}
+	} else if collectFirst {
+		if opts.PruneEmptyDirs {
+			collected = pruneEmptyDirs(collected, &state.stats)
+		}
+
+		if opts.WithSizes {
+			addSubtreeSizes(collected)
+		}
+
+		if opts.WithCounts {
+			addChildCounts(collected)
+		}
+
+		if opts.Reproducible {
+			sortEntriesByName(collected)
+		}
+
+		for _, e := range collected {
+			if err := output.Entry(e); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	progress.Done(state.stats)
+
+	if err := output.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := vols.closeCurrent(); err != nil {
+		return nil, err
+	}
+
+	if resume != nil { // only now the partial archive is complete enough to resume from
+		if err := resume.finish(state.stats.Interrupted); err != nil {
+			return nil, fmt.Errorf("state file: %w", err)
+		}
+	}
+
+	state.stats.ArchiveBytes = vols.doneBytes
+	state.stats.Volumes = vols.number
+
+	if topLevelOutput, isTopLevel := output.(*topLevelSink); isTopLevel {
+		bytes, archives := topLevelOutput.subdirTotals()
+		state.stats.ArchiveBytes += bytes
+		state.stats.Volumes += archives
+	}
+
+	if opts.Format == FormatZip && !opts.DryRun {
+		state.stats.CompressionLevel = effectiveCompressionLevel(opts.Compression, opts.CompressionLevel)
+	}
+
+	if zipOutput, isZip := output.(*zipSink); isZip && opts.Compression != CompressionDeflate {
+		// rest of the archive (headers etc.) would be the same
+		state.stats.DeflateBytes = state.stats.ArchiveBytes - zipOutput.sizes.compressed + zipOutput.sizes.deflated
+	}
+
+	return &state.stats, walkErr
+}
+
+// receives the entries that passed the filters, and renders them in the output format
+type sink interface {
+	Entry(e entry) error
+	Close() error // finalizes the output (but doesn't close the underlying file)
+}
+
+const (
+	FormatZip   = "zip"
+	FormatJSON  = "json"
+	FormatJSONL = "jsonl"
+	FormatYAML  = "yaml"
+	FormatCSV   = "csv"
+	FormatTree  = "tree" // indented text like "$ tree" outputs
+	FormatDot   = "dot"  // Graphviz graph
+	FormatHTML  = "html" // browsable report
+	FormatTar   = "tar"
+	FormatTarGz = "tar.gz"
+)
+
+func newSink(opts Options, vols *volumes, state *walkState) (sink, error) {
+	if opts.DryRun {
+		return discardSink{}, nil
+	}
+
+	switch opts.Format {
+	case FormatZip:
+		scanned, err := archiveTimestamp(opts)
+		if err != nil {
+			return nil, err
+		}
+
+		return newZipSink(vols, state, opts, scanned), nil
+	case FormatTar, FormatTarGz:
+		scanned, err := archiveTimestamp(opts)
+		if err != nil {
+			return nil, err
+		}
+
+		return newTarSink(vols.current.output, state, opts, scanned), nil
+	case FormatJSON:
+		return newJSONSink(vols.current.output, false), nil
+	case FormatJSONL:
+		return newJSONSink(vols.current.output, true), nil
+	case FormatYAML:
+		return newYAMLSink(vols.current.output), nil
+	case FormatCSV:
+		return newCSVSink(vols.current.output)
+	case FormatTree:
+		return newTreeSink(vols.current.output), nil
+	case FormatDot:
+		return newDotSink(vols.current.output), nil
+	case FormatHTML:
+		scanned, err := archiveTimestamp(opts)
+		if err != nil {
+			return nil, err
+		}
+
+		return newHTMLSink(vols.current.output, state, opts, scanned), nil
+	default:
+		return nil, fmt.Errorf("unsupported format: %s", opts.Format)
+	}
+}
+
+// metadata-only pre-pass of source with the same filters, for progress's total. doubles the time
+// spent on reading directories.
+func countFirst(ctx context.Context, source entrySource, opts Options, progress *progressReporter) error {
+	opts.Hash, opts.DetectType, opts.SampleBytes, opts.KeepContentBelow = "", false, 0, 0
+
+	counting, err := newProgressReporter(ProgressNone, logex.Discard, os.Stderr)
+	if err != nil {
+		return err
+	}
+
+	state := newWalkState(counting, logex.Discard) // the real pass logs the warnings
+
+	progress.Printf("counting entries first (--count-first)\n")
+
+	if err := source(ctx, state, opts, func(entry) error { return nil }); err != nil {
+		return fmt.Errorf("counting entries: %w", err)
+	}
+
+	progress.setTotal(state.stats.Files+state.stats.Dirs, state.stats.LogicalBytes)
+
+	return nil
+}
+
+// for DryRun. the walk still counts the entries.
+type discardSink struct{}
+
+func (discardSink) Entry(entry) error { return nil }
+func (discardSink) Close() error      { return nil }
+
+type zipSink struct {
+	zipWriter     *zip.Writer
+	volumes       *volumes
+	splitter      *volumeSplitter // nil if not splitting
+	state         *walkState
+	opts          Options
+	ownerNames    *ownerNameCache
+	scanned       time.Time // for the manifest
+	sizes         compressedSizes
+	restoreScript *restoreScript // nil = not requested
+	fingerprint   *fingerprinter // nil = not requested
+	topLevelText  string         // with SplitByTopLevel, for the README: which part of the set this is
+}
+
+func newZipSink(vols *volumes, state *walkState, opts Options, scanned time.Time) *zipSink {
+	// CompressionLevel defaults to the compression's own default, as the level hardly matters for the
+	// zeroes. here's results from Video + Pictures collection of 163 GB:
+	//
+	// DefaultCompression = 164M
+	// BestCompression = 164M
+	// BestSpeed = 204M
+	// HuffmanOnly = huge file size
+
+	z := &zipSink{
+		volumes:    vols,
+		state:      state,
+		opts:       opts,
+		ownerNames: newOwnerNameCache(),
+		scanned:    scanned,
+	}
+	z.zipWriter = newZipWriter(vols.current.output, opts.Compression, opts.CompressionLevel, &z.sizes)
+
+	if opts.RestoreScript {
+		z.restoreScript = newRestoreScript()
+	}
+
+	if opts.Fingerprint {
+		z.fingerprint = &fingerprinter{}
+	}
+
+	if opts.SplitSize > 0 {
+		z.splitter = newVolumeSplitter(opts.SplitSize, opts.fill, opts.Password != nil, opts.Compression, opts.CompressionLevel)
+	}
+
+	return z
+}
+
+// finalizes the current volume
+func (z *zipSink) Close() error {
+	// works in stdout streaming mode as well, since the comment is buffered until Close() writes
+	// the central directory at the end of the stream
+	if z.fingerprint != nil {
+		z.state.stats.MerkleRoot = z.fingerprint.root()
+	}
+
+	comment, readmeText, manifest := z.trailer(false)
+
+	if err := z.zipWriter.SetComment(comment); err != nil {
+		return err
+	}
+
+	readmeModified, err := archiveTimestamp(z.opts)
+	if err != nil {
+		return err
+	}
+
+	if !z.opts.NoReadme {
+		readme, err := z.zipWriter.CreateHeader(&zip.FileHeader{
+			Name:     z.opts.readmeEntryName(),
+			Modified: readmeModified,
+		})
+		if err != nil {
+			return err
+		}
+
+		if _, err := readme.Write([]byte(readmeText)); err != nil {
+			return err
+		}
+	}
+
+	manifestFile, err := z.zipWriter.CreateHeader(&zip.FileHeader{
+		Name:     manifestName,
+		Modified: readmeModified,
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := manifestFile.Write(manifest); err != nil {
+		return err
+	}
+
+	if z.restoreScript != nil {
+		script, err := z.zipWriter.CreateHeader(&zip.FileHeader{
+			Name:     restoreScriptName,
+			Method:   zipMethod(z.opts.Compression),
+			Modified: readmeModified,
+		})
+		if err != nil {
+			return err
+		}
+
+		if _, err := script.Write(z.restoreScript.bytes()); err != nil {
+			return err
+		}
+	}
+
+	return z.zipWriter.Close()
+}
+
+// archive comment, README content and manifest. pessimistic = as if the walk will end early, for
+// estimating the largest size they can have.
+func (z *zipSink) trailer(pessimistic bool) (string, string, []byte) {
+	stats := z.state.stats
+	if pessimistic {
+		stats.Interrupted = true
+		stats.Truncated = z.opts.LimitEntries > 0
+		if z.fingerprint != nil {
+			stats.MerkleRoot = placeholderMerkleRoot
+		}
+	}
+
+	comment := "written by directory-structure-skeleton-archive"
+	if z.splitter != nil {
+		comment += fmt.Sprintf(" (volume %d)", z.volumes.number)
+	}
+	switch {
+	case stats.Interrupted:
+		comment += " (INCOMPLETE: scan was interrupted)"
+	case stats.Truncated:
+		comment += " (INCOMPLETE: scan stopped at --limit-entries)"
+	}
+	if stats.MerkleRoot != "" {
+		comment += " (merkle root " + stats.MerkleRoot + ")"
+	}
+
+	readmeText := readmeFillText(z.opts.fill)
+
+	if z.opts.SampleBytes > 0 {
+		readmeText += fmt.Sprintf("\n\nEXCEPTION: the first %d bytes of each file are its real content (a sample for detecting file types). Only the rest is filled.", z.opts.SampleBytes)
+	}
+
+	if z.opts.KeepContentBelow > 0 {
+		readmeText += fmt.Sprintf("\n\nEXCEPTION: files smaller than %d bytes are stored with their real content, i.e. they're not skeletons.", z.opts.KeepContentBelow)
+	}
+
+	if z.opts.Compression == CompressionZstd {
+		readmeText += "\n\nThe files are compressed with zstd (zip method 93), which not all unzip tools support."
+	}
+
+	if z.splitter != nil {
+		readmeText += fmt.Sprintf("\n\nThis is volume %d of a split archive. Restore all the volumes together.", z.volumes.number)
+	}
+
+	readmeText += z.topLevelText
+	readmeText += readmeIncompleteText(stats, z.opts)
+	readmeText += readmeIncrementalText(z.opts)
+	readmeText += readmeProvenanceText(z.opts, z.scanned, stats)
+
+	if z.opts.ReadmeContent != nil {
+		readmeText = string(z.opts.ReadmeContent)
+	}
+
+	volume := 0
+	if z.splitter != nil {
+		volume = z.volumes.number
+	}
+
+	return comment, readmeText, newZipManifest(z.opts, z.scanned, stats, volume).marshal()
+}
+
+func readmeFillText(fill []byte) string {
+	if !isZeroFill(fill) {
+		return fmt.Sprintf("This archive contains only metadata about the files. The file contents are filled with the byte pattern 0x%x (instead of the usual null).", fill)
+	}
+
+	return "This archive contains only metadata about the files. The file contents are filled with null."
+}
+
+func readmeIncompleteText(stats Stats, opts Options) string {
+	switch {
+	case stats.Interrupted:
+		return "\n\nNOTE: the scan was interrupted, so this archive is incomplete."
+	case stats.Truncated:
+		return fmt.Sprintf("\n\nNOTE: the scan stopped at the limit of %d entries (--limit-entries), so this archive is incomplete.", opts.LimitEntries)
+	default:
+		return ""
+	}
+}
+
+func readmeIncrementalText(opts Options) string {
+	if len(opts.Since) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("\n\nThis is an INCREMENTAL skeleton: it only has the entries that are new or changed since %s (removed entries are listed in %s). Restore %s first, then this on top of it.", filepath.Base(opts.Since[0]), manifestName, filepath.Base(opts.Since[0]))
+}
+
+// where and when the archive came from. hostname and times vary between runs, so they're left out
+// for reproducibility (SOURCE_DATE_EPOCH too asks for that).
+func readmeProvenanceText(opts Options, scanned time.Time, stats Stats) string {
+	lines := []string{}
+
+	volatile := !opts.Reproducible && os.Getenv("SOURCE_DATE_EPOCH") == ""
+	if volatile {
+		hostname, err := os.Hostname()
+		if err != nil {
+			hostname = "(unknown)"
+		}
+
+		lines = append(lines,
+			"Host: "+hostname,
+			fmt.Sprintf("Scanned: %s - %s", scanned.Format(time.RFC3339), time.Now().UTC().Format(time.RFC3339)))
+	}
+
+	if roots := archivedRoots(opts); len(roots) > 0 {
+		lines = append(lines, "Roots: "+strings.Join(roots, ", "))
+	}
+
+	lines = append(lines,
+		fmt.Sprintf("Entries: %d (%d files, %d directories)", stats.Files+stats.Dirs, stats.Files, stats.Dirs),
+		"Tool version: "+dynversion.Version)
+
+	return "\n\n" + strings.Join(lines, "\n")
+}
+
+// finalizes the current volume and starts the next one
+func (z *zipSink) nextVolume() error {
+	if err := z.Close(); err != nil {
+		return err
+	}
+
+	if err := z.volumes.rotate(); err != nil {
+		return err
+	}
+
+	z.zipWriter = newZipWriter(z.volumes.current.output, z.opts.Compression, z.opts.CompressionLevel, &z.sizes)
+
+	return nil
+}
+
+func (z *zipSink) Entry(e entry) error {
+	fileInfo := e.fileInfo
+
+	withErr := func(err error) error {
+		return fmt.Errorf("%s: %w", e.path, err)
+	}
+
+	if err := z.opts.trailerNames().assertNotClashing(e); err != nil {
+		return err
+	}
+
+	// also records the mode (incl. permission bits) in the Unix part of the external attributes
+	zipInfo, err := zip.FileInfoHeader(fileInfo)
+	if err != nil {
+		return withErr(err)
+	}
+
+	// conventionally (Info-ZIP) symlinks are stored as entries with symlink mode bit, and link target as content
+	symlinkTarget := e.symlinkTarget
+	isSymlink := fileInfo.Mode()&os.ModeSymlink != 0
+
+	switch {
+	case fileInfo.IsDir():
+		// directories have no content. the size given by the OS is meaningless for us.
+		zipInfo.Method = zip.Store
+		zipInfo.UncompressedSize64 = 0
+		zipInfo.UncompressedSize = 0
+	case isSymlink:
+		zipInfo.Method = zip.Store
+		zipInfo.UncompressedSize64 = uint64(len(symlinkTarget))
+		zipInfo.UncompressedSize = uint32(len(symlinkTarget))
+	case isSpecialFile(fileInfo.Mode()):
+		// the type is in the mode bits and device numbers in an extra field. there's no content.
+		zipInfo.Method = zip.Store
+		zipInfo.UncompressedSize64 = 0
+		zipInfo.UncompressedSize = 0
+
+		if major, minor, ok := getDeviceNumber(fileInfo); ok && fileInfo.Mode()&os.ModeDevice != 0 {
+			zipInfo.Extra = appendExtraField(zipInfo.Extra, extraFieldDevice, encodeDeviceExtraField(major, minor))
+		}
+	default:
+		// > If compression is desired, callers should set the FileHeader.Method field; it is unset by default.
+		zipInfo.Method = zipMethod(z.opts.Compression)
+	}
+
+	// > Because fs.FileInfo's Name method returns only the base name of the file it describes, it may be
+	// > necessary to modify the Name field of the returned header to provide the full path name of the file.
+	zipInfo.Name = e.name
+
+	hardlinkTarget := z.state.hardlinkTargetOf(e)
+
+	// opened before writing anything, so that a file gone by now can still be stored as a skeleton
+	realContent := io.ReadCloser(nil)
+	if e.content != nil && hardlinkTarget == "" {
+		realContent, err = e.content()
+		if err != nil {
+			z.state.logl.Error.Printf("%s: can't keep the content, storing as a skeleton: %v", e.path, err)
+			e.content = nil
+		} else {
+			defer realContent.Close()
+		}
+	}
+
+	zipInfo.Extra = appendExtraField(zipInfo.Extra, extraFieldTimes, encodeTimesExtraField(fileInfo.ModTime(), e.accessed, e.changed))
+
+	// authoritative, as the stored content isn't always the file's length (hardlinks have none)
+	if fileInfo.Mode().IsRegular() {
+		zipInfo.Extra = appendExtraField(zipInfo.Extra, extraFieldLogicalSize, encodeLogicalSizeExtraField(fileInfo.Size()))
+	}
+
+	if e.allocated != nil && hardlinkTarget == "" { // belongs to the inode
+		zipInfo.Extra = appendExtraField(zipInfo.Extra, extraFieldAllocated, encodeLogicalSizeExtraField(*e.allocated))
+	}
+
+	if e.sha256 != nil && hardlinkTarget == "" { // for hardlinks it'd be redundant
+		zipInfo.Extra = appendExtraField(zipInfo.Extra, extraFieldSHA256, e.sha256)
+	}
+
+	if e.subtree != nil {
+		zipInfo.Extra = appendExtraField(zipInfo.Extra, extraFieldSubtree, encodeSubtreeExtraField(*e.subtree))
+	}
+
+	if e.childCounts != nil {
+		zipInfo.Extra = appendExtraField(zipInfo.Extra, extraFieldChildCounts, encodeChildCountsExtraField(*e.childCounts))
+	}
+
+	if e.contentType != "" && hardlinkTarget == "" {
+		zipInfo.Extra = appendExtraField(zipInfo.Extra, extraFieldContentType, []byte(e.contentType))
+	}
+
+	if realLen := e.realContentLen(); realLen > 0 && hardlinkTarget == "" {
+		zipInfo.Extra = appendExtraField(zipInfo.Extra, extraFieldSample, encodeSampleExtraField(int(realLen)))
+	}
+
+	if uid, gid, ok := getFileOwner(fileInfo); z.opts.Owners && ok && hardlinkTarget == "" { // owners belong to the inode as well
+		owner := z.ownerNames.resolve(uid, gid)
+
+		zipInfo.Extra = appendExtraField(zipInfo.Extra, extraFieldUnixOwner, encodeUnixOwnerExtraField(owner))
+		zipInfo.Extra = appendExtraField(zipInfo.Extra, extraFieldOwner, encodeOwnerNamesExtraField(owner))
+	}
+
+	if len(e.xattrs) > 0 && hardlinkTarget == "" { // xattrs belong to the inode, so same for hardlinks
+		xattrs, err := encodeXattrsExtraField(e.xattrs)
+		if err != nil {
+			return withErr(err)
+		}
+
+		zipInfo.Extra = appendExtraField(zipInfo.Extra, extraFieldXattrs, xattrs)
+	}
+
+	if e.fileAttrs != 0 && hardlinkTarget == "" { // belong to the inode
+		zipInfo.Extra = appendExtraField(zipInfo.Extra, extraFieldFileAttrs, encodeFileAttrsExtraField(e.fileAttrs))
+	}
+
+	zipInfo.Extra = append(zipInfo.Extra, e.recordedExtra...)
+
+	if hardlinkTarget != "" {
+		zipInfo.Extra = appendExtraField(zipInfo.Extra, extraFieldHardlink, []byte(hardlinkTarget))
+		zipInfo.Method = zip.Store
+		zipInfo.UncompressedSize64 = 0
+		zipInfo.UncompressedSize = 0
+
+		z.state.stats.HardlinksCollapsed++
+	}
+
+	if z.splitter != nil {
+		entrySize := z.splitter.estimateEntrySize(zipInfo.Name, len(zipInfo.Extra), int64(zipInfo.UncompressedSize64), zipInfo.Method != zip.Store)
+		if hardlinkTarget == "" {
+			entrySize += e.realContentLen() // real content is pessimistically assumed incompressible
+		}
+		comment, readmeText, manifest := z.trailer(true) // pessimistic, as we don't know yet if the walk ends early
+		readme := z.opts.readmeEntryName()
+		if z.opts.NoReadme {
+			readme = ""
+		}
+		reserved := z.splitter.estimateTrailerSize(comment, readme, readmeText, manifest)
+
+		if !z.splitter.fits(entrySize, reserved) {
+			if err := z.nextVolume(); err != nil {
+				return withErr(err)
+			}
+
+			z.splitter.fits(entrySize, reserved) // always fits an empty volume
+		}
+
+		if entrySize+reserved > z.opts.SplitSize {
+			z.state.logl.Info.Printf("%s: larger than the split size by itself, so its volume will be as well", e.path)
+		}
+	}
+
+	objectInZip, err := z.zipWriter.CreateHeader(zipInfo)
+	if err != nil {
+		return withErr(err)
+	}
+
+	if z.restoreScript != nil {
+		z.restoreScript.add(e, hardlinkTarget)
+	}
+
+	if z.fingerprint != nil {
+		z.fingerprint.add(e, hardlinkTarget)
+	}
+
+	switch {
+	case fileInfo.IsDir(): // only files have content
+	case isSpecialFile(fileInfo.Mode()):
+	case hardlinkTarget != "": // content is represented by the link target
+	case isSymlink:
+		if _, err := objectInZip.Write([]byte(symlinkTarget)); err != nil {
+			return withErr(err)
+		}
+	default:
+		fileZeroContent := io.LimitReader(newFillReader(z.opts.fill), fileInfo.Size())
+		switch {
+		case realContent != nil: // fill only makes up for a file that shrank after it was stat'd
+			fileZeroContent = io.LimitReader(io.MultiReader(realContent, newFillReader(z.opts.fill)), fileInfo.Size())
+		case len(e.sample) > 0:
+			fileZeroContent = io.MultiReader(bytes.NewReader(e.sample), io.LimitReader(newFillReader(z.opts.fill), fileInfo.Size()-int64(len(e.sample))))
+		}
+
+		content := io.Writer(objectInZip)
+		if z.opts.Compression != CompressionDeflate { // for comparison in the summary
+			deflateCounter := newDeflateCounter(&z.sizes)
+			defer deflateCounter.Close()
+
+			content = io.MultiWriter(objectInZip, deflateCounter)
+		}
+
+		// adding buffered writer (with 1 MB buffer size) does not improve compression ratio.
+		// this implies there's already optimal buffering going on.
+		if _, err := io.Copy(content, fileZeroContent); err != nil {
+			return withErr(err)
+		}
+	}
+
+	return nil
+}
+
+// name is slash-separated
+func archiveName(name string, isDir bool) string {
+	if isDir {
+		// > To write an empty directory you just need to call Create with the directory path with a trailing path separator.
+		// https://stackoverflow.com/a/70482137
+		return name + "/"
+	} else {
+		return name
+	}
+}
+
+// name for root (or with --files-from, any path) in the archive: relative to relativeTo, or if
+// not given, to root's parent (so roots appear by their base name instead of leaking an
+// absolute path). slash-separated.
+func rootArchiveName(root string, relativeTo string) (string, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+
+	// lexically, so that "." stays at the top level
+	base, err := filepath.Abs(filepath.Dir(filepath.Clean(root)))
+	if err != nil {
+		return "", err
+	}
+
+	if relativeTo != "" {
+		base, err = filepath.Abs(relativeTo)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	rel, err := filepath.Rel(base, absRoot)
+	if err != nil {
+		return "", err
+	}
+
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is not under --relative-to %s", root, relativeTo)
+	}
+
+	return filepath.ToSlash(rel), nil
+}
+
+// detects roots that would end up with the same name (e.g. "/a/data" and "/b/data"), because
+// otherwise their entries would silently get mixed together
+func rootArchiveNames(roots []string, relativeTo string, disambiguate bool, nameOf func(root string, relativeTo string) (string, error)) ([]string, error) {
+	names := []string{}
+	rootByName := map[string]string{}
+
+	for _, root := range roots {
+		name, err := nameOf(root, relativeTo)
+		if err != nil {
+			return nil, err
+		}
+
+		if other, collides := rootByName[name]; collides {
+			if !disambiguate || name == "." {
+				return nil, fmt.Errorf("%s and %s would both be named '%s' in the archive (use --disambiguate or --relative-to)", other, root, name)
+			}
+
+			for n := 2; ; n++ {
+				if _, taken := rootByName[fmt.Sprintf("%s-%d", name, n)]; !taken {
+					name = fmt.Sprintf("%s-%d", name, n)
+					break
+				}
+			}
+		}
+
+		rootByName[name] = root
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+// "/backups//2024/" => "backups/2024". "" => "."
+func normalizeArchivePrefix(prefix string) (string, error) {
+	normalized := pathpkg.Clean("/" + filepath.ToSlash(prefix))[1:]
+	if normalized == "" {
+		return ".", nil
+	}
+
+	for _, component := range strings.Split(filepath.ToSlash(prefix), "/") {
+		if component == ".." {
+			return "", fmt.Errorf("--prefix can't contain '..': %s", prefix)
+		}
+	}
+
+	return normalized, nil
+}
+
+// joins slash-separated paths, where "." means empty
+func joinArchivePath(a string, b string) string {
+	switch {
+	case a == ".":
+		return b
+	case b == ".":
+		return a
+	default:
+		return a + "/" + b
+	}
+}
+
+// timestamp for entries we generate ourselves (i.e. not from the filesystem)
+func archiveTimestamp(opts Options) (time.Time, error) {
+	// https://reproducible-builds.org/docs/source-date-epoch/
+	// honored even without Reproducible, as whoever sets it wants timestamps to not vary
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		seconds, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("SOURCE_DATE_EPOCH: %w", err)
+		}
+
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+
+	if !opts.Reproducible {
+		return time.Now().UTC(), nil
+	}
+
+	return time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC), nil // earliest time representable as DOS time
+}
+
+var (
+	// can share this instance, because with a single-byte pattern there's no position to track
+	readAllZeroes = newFillReaderInternal([]byte{0x00})
+)
+
+// fills with a (usually single-byte) repeating pattern forever
+type fillReader struct {
+	// pre-filled with the pattern repeated, so Read() is just copy(). setting each byte in a loop
+	// was the bottleneck for large files (more than the compression!)
+	scratch    []byte
+	singleByte bool // offset is irrelevant, so the instance is safe to share
+	offset     int  // where in scratch the next Read() continues from. mid-pattern if the previous Read() ended there
+}
+
+var _ io.Reader = (*fillReader)(nil)
+
+func newFillReader(pattern []byte) io.Reader {
+	if isZeroFill(pattern) {
+		return readAllZeroes
+	}
+
+	return newFillReaderInternal(pattern)
+}
+
+func newFillReaderInternal(pattern []byte) *fillReader {
+	// length must be a multiple of pattern length so wrapping around keeps the pattern intact
+	const scratchSizeApprox = 32 * 1024
+	repeats := (scratchSizeApprox + len(pattern) - 1) / len(pattern)
+
+	return &fillReader{
+		scratch:    bytes.Repeat(pattern, repeats),
+		singleByte: len(pattern) == 1,
+	}
+}
+
+func (f *fillReader) Read(buf []byte) (int, error) {
+	if f.singleByte {
+		for n := 0; n < len(buf); {
+			n += copy(buf[n:], f.scratch)
+		}
+
+		return len(buf), nil
+	}
+
+	for n := 0; n < len(buf); {
+		copied := copy(buf[n:], f.scratch[f.offset:])
+		n += copied
+		f.offset = (f.offset + copied) % len(f.scratch)
+	}
+
+	return len(buf), nil
+}
+
+// accepts hex like "ff", "0xff" or "deadbeef" (a repeating pattern)
+func parseFillPattern(hexPattern string) ([]byte, error) {
+	pattern, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(hexPattern), "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("fill byte: %w", err)
+	}
+
+	if len(pattern) == 0 || len(pattern) > 16 {
+		return nil, fmt.Errorf("fill byte: pattern must be 1-16 bytes; got %d", len(pattern))
+	}
+
+	return pattern, nil
+}
+
+func isZeroFill(pattern []byte) bool {
+	return len(pattern) == 1 && pattern[0] == 0x00
+}
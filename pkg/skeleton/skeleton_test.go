@@ -0,0 +1,206 @@
+package skeleton
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// a fixed time for test trees, so that their archives are comparable
+var testModTime = time.Date(2021, 3, 4, 5, 6, 7, 890123456, time.UTC)
+
+// archives roots of fsys to a zip in a temp dir, returning its path
+func archiveTestFS(t *testing.T, fsys fs.FS, roots []string, opts Options) (string, Stats) {
+	t.Helper()
+
+	output := bytes.Buffer{}
+	stats, err := ArchiveFS(context.Background(), &output, fsys, roots, opts)
+	if err != nil {
+		t.Fatalf("ArchiveFS: %v", err)
+	}
+
+	archivePath := filepath.Join(t.TempDir(), "out.zip")
+	if err := os.WriteFile(archivePath, output.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return archivePath, stats
+}
+
+// like archiveTestFS, but for roots on disk
+func archiveTestDirs(t *testing.T, roots []string, opts Options) string {
+	t.Helper()
+
+	output := bytes.Buffer{}
+	if _, err := Archive(context.Background(), &output, roots, opts); err != nil {
+		t.Fatalf("Archive: %v", err)
+	}
+
+	archivePath := filepath.Join(t.TempDir(), "out.zip")
+	if err := os.WriteFile(archivePath, output.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return archivePath
+}
+
+func listTestArchive(t *testing.T, archivePaths ...string) []ManifestEntry {
+	t.Helper()
+
+	entries, err := List(archivePaths, nil)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+
+	return entries
+}
+
+func entryPaths(entries []ManifestEntry) []string {
+	paths := []string{}
+	for _, entry := range entries {
+		paths = append(paths, entry.Path)
+	}
+
+	return paths
+}
+
+func entryByPath(t *testing.T, entries []ManifestEntry, path string) ManifestEntry {
+	t.Helper()
+
+	for _, entry := range entries {
+		if entry.Path == path {
+			return entry
+		}
+	}
+
+	t.Fatalf("no entry %s in %v", path, entryPaths(entries))
+	return ManifestEntry{}
+}
+
+func assertEqual(t *testing.T, actual interface{}, expected interface{}) {
+	t.Helper()
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("\nexpected: %v\n     got: %v", expected, actual)
+	}
+}
+
+func assertSameFile(t *testing.T, path string, expectedPath string) {
+	t.Helper()
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := os.ReadFile(expectedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(content, expected) {
+		t.Fatalf("%s differs from %s", path, expectedPath)
+	}
+}
+
+func testFile(content string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(content), Mode: 0o644, ModTime: testModTime}
+}
+
+func testDir() *fstest.MapFile {
+	return &fstest.MapFile{Mode: fs.ModeDir | 0o755, ModTime: testModTime}
+}
+
+func TestArchiveFSListsEntries(t *testing.T) {
+	fsys := fstest.MapFS{
+		"root":          testDir(),
+		"root/a.txt":    testFile("hello"),
+		"root/sub":      testDir(),
+		"root/sub/b.go": testFile("package b"),
+	}
+
+	archivePath, stats := archiveTestFS(t, fsys, []string{"root"}, DefaultOptions())
+
+	assertEqual(t, stats.Files, 2)
+	assertEqual(t, stats.Dirs, 2)
+
+	entries := listTestArchive(t, archivePath)
+	assertEqual(t, entryPaths(entries), []string{"root", "root/a.txt", "root/sub", "root/sub/b.go"})
+	assertEqual(t, entryByPath(t, entries, "root/a.txt").Size, int64(5))
+}
+
+// reads of odd sizes end mid-pattern; the next one must continue from there
+func TestFillReaderKeepsPatternAcrossReads(t *testing.T) {
+	pattern := []byte("abc")
+	reader := newFillReader(pattern)
+
+	content := []byte{}
+	for _, size := range []int{1, 2, 5, 7, 32 * 1024, 11, 64*1024 + 1} {
+		buf := make([]byte, size)
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			t.Fatal(err)
+		}
+		content = append(content, buf...)
+	}
+
+	if expected := bytes.Repeat(pattern, len(content)/len(pattern)+1)[:len(content)]; !bytes.Equal(content, expected) {
+		t.Fatal("pattern broken across reads")
+	}
+}
+
+// the content path for large files, minus the compression
+func benchmarkFillReader(b *testing.B, pattern []byte) {
+	const size = 16 * 1024 * 1024
+
+	reader := newFillReader(pattern)
+
+	b.SetBytes(size)
+	for i := 0; i < b.N; i++ {
+		if _, err := io.Copy(io.Discard, io.LimitReader(reader, size)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFillReaderZeroes(b *testing.B) {
+	benchmarkFillReader(b, []byte{0})
+}
+
+func BenchmarkFillReaderPattern(b *testing.B) {
+	benchmarkFillReader(b, []byte("0123456789abcdef"))
+}
+
+func TestCollidingRootNames(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a/data/from-a.txt": testFile("x"),
+		"b/data/from-b.txt": testFile("x"),
+		"c/data/from-c.txt": testFile("x"),
+	}
+	roots := []string{"a/data", "b/data", "c/data"}
+
+	_, err := ArchiveFS(context.Background(), &bytes.Buffer{}, fsys, roots, DefaultOptions())
+	if err == nil || !strings.Contains(err.Error(), "would both be named 'data'") {
+		t.Fatalf("expected collision error, got %v", err)
+	}
+
+	opts := DefaultOptions()
+	opts.Disambiguate = true
+
+	archivePath, _ := archiveTestFS(t, fsys, roots, opts)
+	assertEqual(t, entryPaths(listTestArchive(t, archivePath)), []string{
+		"data",
+		"data/from-a.txt",
+		"data-2",
+		"data-2/from-b.txt",
+		"data-3",
+		"data-3/from-c.txt",
+	})
+}
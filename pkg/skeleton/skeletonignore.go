@@ -0,0 +1,102 @@
+package skeleton
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// tool-specific ignore file, so that exclusions can travel with the tree. one Excludes-style
+// pattern per line, matched relative to the file's directory and applying only to its subtree.
+// there's no negation, so it can't re-include what Excludes excludes.
+const skeletonignoreName = ".skeletonignore"
+
+// like gitignoreStack, relies on the walk being depth-first
+type skeletonignoreStack struct {
+	fsys   fs.FS
+	levels []skeletonignoreLevel
+}
+
+type skeletonignoreLevel struct {
+	dir      string // in fsys
+	patterns []string
+}
+
+// path is in fsys
+func (s *skeletonignoreStack) Ignored(path string) bool {
+	s.popLevelsNotContaining(path)
+
+	for _, level := range s.levels {
+		if matchesAnyPattern(fsRel(level.dir, path), level.patterns) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reads dir's .skeletonignore (if any) so its patterns apply to dir's subtree
+func (s *skeletonignoreStack) Enter(dir string) error {
+	s.popLevelsNotContaining(dir)
+
+	patterns, err := parseSkeletonignore(s.fsys, path.Join(dir, skeletonignoreName))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+
+		return err
+	}
+
+	s.levels = append(s.levels, skeletonignoreLevel{dir, patterns})
+
+	return nil
+}
+
+func (s *skeletonignoreStack) popLevelsNotContaining(path string) {
+	for len(s.levels) > 0 {
+		if fsIsWithinDir(s.levels[len(s.levels)-1].dir, path) {
+			return
+		}
+
+		s.levels = s.levels[:len(s.levels)-1]
+	}
+}
+
+// for walking a subdirectory separately, because the original's levels keep changing
+func (s *skeletonignoreStack) snapshot() *skeletonignoreStack {
+	return &skeletonignoreStack{fsys: s.fsys, levels: append([]skeletonignoreLevel(nil), s.levels...)}
+}
+
+// blank lines and "#" comments are skipped
+func parseSkeletonignore(fsys fs.FS, name string) ([]string, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	patterns := []string{}
+
+	lines := bufio.NewScanner(file)
+	for lines.Scan() {
+		line := strings.TrimSpace(lines.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		patterns = append(patterns, line)
+	}
+	if err := lines.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := validatePatterns(patterns); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	return patterns, nil
+}
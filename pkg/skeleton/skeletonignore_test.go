@@ -0,0 +1,48 @@
+package skeleton
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestSkeletonignoreAppliesToItsOwnSubtree(t *testing.T) {
+	fsys := fstest.MapFS{
+		"root/.skeletonignore":       testFile("*.tmp\n"),
+		"root/a.tmp":                 testFile("x"),
+		"root/kept.log":              testFile("x"),
+		"root/sub/.skeletonignore":   testFile("# comment\n\n*.log\nbuild\ndeeper/only.txt\n"),
+		"root/sub/x.log":             testFile("x"),
+		"root/sub/y.tmp":             testFile("x"), // by the root's
+		"root/sub/build/out":         testFile("x"),
+		"root/sub/deeper/z.log":      testFile("x"),
+		"root/sub/deeper/only.txt":   testFile("x"),
+		"root/sub/deeper/kept.txt":   testFile("x"),
+		"root/other/w.log":           testFile("x"),
+		"root/other/build/out":       testFile("x"),
+		"root/other/deeper/only.txt": testFile("x"), // relative to sub/, so not this one
+	}
+
+	opts := DefaultOptions()
+	opts.Skeletonignore = true
+
+	archivePath, _ := archiveTestFS(t, fsys, []string{"root"}, opts)
+	assertEqual(t, entryPaths(listTestArchive(t, archivePath)), []string{
+		"root",
+		"root/.skeletonignore",
+		"root/kept.log",
+		"root/other",
+		"root/other/build",
+		"root/other/build/out",
+		"root/other/deeper",
+		"root/other/deeper/only.txt",
+		"root/other/w.log",
+		"root/sub",
+		"root/sub/.skeletonignore",
+		"root/sub/deeper",
+		"root/sub/deeper/kept.txt",
+	})
+
+	// and without
+	archivePath, _ = archiveTestFS(t, fsys, []string{"root"}, DefaultOptions())
+	assertEqual(t, len(listTestArchive(t, archivePath)), len(fsys)+7) // + the directories
+}
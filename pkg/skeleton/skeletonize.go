@@ -0,0 +1,464 @@
+package skeleton
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Skeletonize writes a skeleton archive of an existing .zip or .tar (optionally gzipped) archive
+// at sourcePath, without extracting it. "-" reads a tar from stdin. entries' names, sizes, modes
+// and modification times are preserved, as are directories, symlinks and (for tar) hardlinks.
+//
+// of the filters only Excludes and the metadata ones (sizes, ages) apply, as there's no tree to walk.
+// with Hash the digest is of the source archive's content.
+func Skeletonize(ctx context.Context, w io.Writer, sourcePath string, opts Options) (Stats, error) {
+	if opts.FilesFrom != "" || opts.StateFile != "" {
+		return Stats{}, errors.New("FilesFrom and StateFile are not supported when skeletonizing an archive")
+	}
+
+	opts.roots = []string{sourcePath}
+	opts.sourceOrder = true
+
+	return archive(ctx, w, func(ctx context.Context, state *walkState, opts Options, visit func(entry) error) error {
+		a := &archiveWalker{
+			ctx:        ctx,
+			sourcePath: sourcePath,
+			state:      state,
+			opts:       opts,
+			visit:      visit,
+		}
+
+		if err := a.run(); err != nil {
+			return fmt.Errorf("%s: %w", sourcePath, err)
+		}
+
+		return nil
+	}, opts)
+}
+
+// visits the entries of a source archive
+type archiveWalker struct {
+	ctx        context.Context
+	sourcePath string
+	state      *walkState
+	opts       Options
+	visit      func(entry) error
+	limit      int // stop (with errEntryLimitReached) after visiting this many entries. 0 = no limit
+	visited    int
+}
+
+func (a *archiveWalker) run() error {
+	source := os.Stdin
+	if a.sourcePath != "-" {
+		var err error
+		source, err = os.Open(a.sourcePath)
+		if err != nil {
+			return err
+		}
+		defer source.Close()
+	}
+
+	buffered := bufio.NewReader(source)
+	magic, _ := buffered.Peek(4) // shorter if the file is, which is then taken care of by the tar reader
+
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")) || bytes.HasPrefix(magic, []byte("PK\x05\x06")): // the latter is an empty zip
+		if source == os.Stdin {
+			return errors.New("zip can't be read from stdin, because its directory is at the end")
+		}
+
+		return a.walkZip(source)
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		uncompressed, err := gzip.NewReader(buffered)
+		if err != nil {
+			return err
+		}
+
+		return a.walkTar(uncompressed)
+	default:
+		return a.walkTar(buffered)
+	}
+}
+
+func (a *archiveWalker) walkZip(source *os.File) error {
+	sourceInfo, err := source.Stat()
+	if err != nil {
+		return err
+	}
+
+	zipReader, err := zip.NewReader(source, sourceInfo.Size())
+	if err != nil {
+		return err
+	}
+	registerDecompressors(zipReader)
+
+	// skeletonizing a skeleton. its README and manifest would be out of date, and its content is
+	// fill, so metadata derived from content has to be taken as recorded.
+	trailer, err := findZipTrailer(zipReader.File)
+	if err != nil {
+		return fmt.Errorf("%s: %w", manifestName, err)
+	}
+	isSkeleton := len(trailer.entries) > 0
+
+	sizes := map[string]int64{} // of regular files, for giving hardlinks their target's size
+
+	for _, file := range zipReader.File {
+		if err := a.ctx.Err(); err != nil {
+			return err
+		}
+
+		if trailer.has(file) {
+			continue
+		}
+
+		mode := file.Mode()
+		isDir := strings.HasSuffix(file.Name, "/") || mode.IsDir() // some tools don't set the mode for dirs
+
+		modified, _ := entryModified(file) // more precise if the source is a skeleton itself
+
+		info := archivedFileInfo{
+			name:    file.Name,
+			size:    entryLogicalSize(file),
+			mode:    mode,
+			modTime: modified,
+		}
+		if isDir {
+			info.mode = fs.ModeDir | permissionBits(mode)
+			info.size = 0
+		}
+
+		var open func() (io.ReadCloser, error)
+		if !isDir {
+			open = file.Open
+		}
+
+		hardlinkTarget := ""
+		var recorded func(e *entry) error
+
+		if isSkeleton {
+			file := file
+			recorded = func(e *entry) error { return recordedSkeletonMetadata(file, e) }
+
+			if target, isHardlink := findExtraField(file.Extra, extraFieldHardlink); isHardlink {
+				hardlinkTarget = cleanArchivedName(string(target))
+				info.size = sizes[hardlinkTarget]
+			}
+		}
+
+		if mode.IsRegular() && hardlinkTarget == "" {
+			sizes[cleanArchivedName(file.Name)] = info.size
+		}
+
+		if err := a.visitArchived(file.Name, info, open, "", hardlinkTarget, recorded); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *archiveWalker) walkTar(source io.Reader) error {
+	tarReader := tar.NewReader(source)
+
+	sizes := map[string]int64{} // of regular files, for giving hardlinks their target's size
+	headersRead := 0
+	isSkeleton := false // known only at the end, where the README and manifest are
+	trailer := newTrailerNames(nil)
+
+	// the README comes before the manifest, so it's known to be ours only if the next entry is our
+	// manifest. until then it's held here.
+	var pendingReadme *tar.Header
+	var pendingReadmeContent []byte
+
+	visitPendingReadme := func() error {
+		if pendingReadme == nil {
+			return nil
+		}
+
+		header, content := pendingReadme, pendingReadmeContent
+		pendingReadme, pendingReadmeContent = nil, nil
+
+		return a.visitTarEntry(header, content, bytes.NewReader(nil), sizes)
+	}
+
+	for {
+		if err := a.ctx.Err(); err != nil {
+			return err
+		}
+
+		header, err := tarReader.Next()
+		if err != nil {
+			switch {
+			case err == io.EOF:
+				return visitPendingReadme()
+			case headersRead == 0: // tar is the fallback, so this is likely something else entirely
+				return fmt.Errorf("not a zip or tar archive: %w", err)
+			default:
+				return err
+			}
+		}
+
+		headersRead++
+
+		name := cleanArchivedName(header.Name)
+		if isSkeleton && trailer.has(name) { // rest of the trailer, after our manifest
+			continue
+		}
+
+		// a skeleton whose README was renamed or left out has the manifest first, which tells that
+		var head []byte // if read to find out
+		if (name == manifestName || name == readmeName) && !isSkeleton && header.Typeflag == tar.TypeReg {
+			if head, err = io.ReadAll(io.LimitReader(tarReader, maxManifestLen)); err != nil {
+				return err
+			}
+		}
+
+		if name == manifestName && head != nil {
+			manifest := &zipManifest{}
+			if json.Unmarshal(head, manifest) == nil && isOwnManifest(manifest) {
+				isSkeleton = true
+				trailer = newTrailerNames(manifest)
+
+				if trailer.readme == readmeName { // the README before it was ours
+					pendingReadme, pendingReadmeContent = nil, nil
+				}
+
+				if err := visitPendingReadme(); err != nil {
+					return err
+				}
+
+				continue
+			}
+		}
+
+		if err := visitPendingReadme(); err != nil { // wasn't followed by our manifest
+			return err
+		}
+
+		if name == readmeName && head != nil && len(head) < maxManifestLen {
+			pendingReadme, pendingReadmeContent = header, head
+			continue
+		}
+
+		if err := a.visitTarEntry(header, head, tarReader, sizes); err != nil {
+			return err
+		}
+	}
+}
+
+// head is the start of the content that was already read from rest (if any)
+func (a *archiveWalker) visitTarEntry(header *tar.Header, head []byte, rest io.Reader, sizes map[string]int64) error {
+	info := archivedFileInfo{
+		name:    header.Name,
+		size:    header.Size,
+		mode:    header.FileInfo().Mode(),
+		modTime: header.ModTime,
+	}
+
+	open := func() (io.ReadCloser, error) {
+		return io.NopCloser(io.MultiReader(bytes.NewReader(head), rest)), nil
+	}
+
+	symlinkTarget := ""
+	hardlinkTarget := ""
+
+	switch header.Typeflag {
+	case tar.TypeXGlobalHeader: // metadata for the following entries, not an entry itself
+		return nil
+	case tar.TypeDir:
+		info.size = 0
+		open = nil
+	case tar.TypeSymlink:
+		symlinkTarget = header.Linkname
+		open = nil
+	case tar.TypeLink:
+		hardlinkTarget = cleanArchivedName(header.Linkname)
+		info.mode = permissionBits(header.FileInfo().Mode()) // regular file, like the target
+		info.size = sizes[hardlinkTarget]
+		open = nil
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		open = nil
+	default:
+		sizes[cleanArchivedName(header.Name)] = header.Size
+	}
+
+	return a.visitArchived(header.Name, info, open, symlinkTarget, hardlinkTarget, nil)
+}
+
+// open is nil for entries that don't have content. for symlinks the content (for zip) is the target.
+// recorded (if non-nil) fills in metadata the source already has, instead of deriving it from content.
+func (a *archiveWalker) visitArchived(
+	sourceName string,
+	info archivedFileInfo,
+	open func() (io.ReadCloser, error),
+	symlinkTarget string,
+	hardlinkTarget string,
+	recorded func(e *entry) error,
+) error {
+	name := cleanArchivedName(sourceName)
+	if name == "" { // e.g. tar's "./" for the top level
+		return nil
+	}
+
+	path := a.sourcePath + ":" + sourceName // for messages
+
+	if matchesAnyPattern(name, a.opts.Excludes) {
+		return nil
+	}
+
+	if reason := a.opts.metadataFilter(info); reason != "" {
+		a.state.stats.Skipped[reason]++
+		return nil
+	}
+
+	e := entry{
+		path:          path,
+		name:          archiveName(joinArchivePath(a.opts.Prefix, name), info.IsDir()),
+		fileInfo:      info,
+		symlinkTarget: symlinkTarget,
+	}
+
+	if hardlinkTarget != "" {
+		e.hardlinkTarget = joinArchivePath(a.opts.Prefix, hardlinkTarget)
+	}
+
+	isSymlink := info.Mode()&fs.ModeSymlink != 0
+
+	if recorded != nil {
+		if err := recorded(&e); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		if !isSymlink {
+			open = nil
+		}
+	}
+
+	headLen := a.opts.headLen(info.Size())
+	keepsContent := info.Mode().IsRegular() && a.opts.keepsContent(info.Size())
+
+	if open != nil && (isSymlink || (info.Mode().IsRegular() && (a.opts.Hash == HashSHA256 || headLen > 0 || keepsContent))) {
+		content, err := open()
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		defer content.Close()
+
+		if isSymlink {
+			target, err := io.ReadAll(io.LimitReader(content, 4096)) // PATH_MAX
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+
+			e.symlinkTarget = string(target)
+		} else {
+			head, err := readSample(content, headLen)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+
+			e.setHead(head, a.opts)
+
+			rest := io.MultiReader(bytes.NewReader(head), content) // the head was already consumed from the content
+
+			if keepsContent { // a tar can't be reopened when the entry gets written, so this is the one case that buffers
+				kept, err := io.ReadAll(io.LimitReader(rest, info.Size()))
+				if err != nil {
+					return fmt.Errorf("%s: %w", path, err)
+				}
+
+				e.content = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(kept)), nil }
+				rest = bytes.NewReader(kept)
+			}
+
+			if a.opts.Hash == HashSHA256 {
+				hash := sha256.New()
+				if _, err := io.Copy(hash, rest); err != nil {
+					return fmt.Errorf("%s: %w", path, err)
+				}
+
+				e.sha256 = hash.Sum(nil)
+			}
+		}
+	}
+
+	if a.limit > 0 && a.visited == a.limit {
+		return errEntryLimitReached
+	}
+	a.visited++
+
+	a.state.stats.count(info)
+	a.state.progress.Entry(e.path, info, a.state.stats)
+
+	return a.visit(e)
+}
+
+// hashes, samples etc. of a skeleton's entry. owners, xattrs and device numbers are kept as-is.
+func recordedSkeletonMetadata(file *zip.File, e *entry) error {
+	e.accessed, e.changed, _ = entryAccessChangeTimes(file)
+
+	if digest, found := findExtraField(file.Extra, extraFieldSHA256); found {
+		e.sha256 = append([]byte(nil), digest...)
+	}
+
+	if contentType, found := findExtraField(file.Extra, extraFieldContentType); found {
+		e.contentType = string(contentType)
+	}
+
+	for _, id := range []uint16{extraFieldUnixOwner, extraFieldOwner, extraFieldXattrs, extraFieldDevice, extraFieldFileAttrs, extraFieldAllocated} {
+		if data, found := findExtraField(file.Extra, id); found {
+			e.recordedExtra = appendExtraField(e.recordedExtra, id, data)
+		}
+	}
+
+	if sampleLen := entrySampleLen(file); sampleLen > 0 && sampleLen <= entryLogicalSize(file) {
+		content, err := file.Open()
+		if err != nil {
+			return err
+		}
+		defer content.Close()
+
+		if e.sample, err = readSample(content, sampleLen); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// slash-separated and relative, without the trailing slash for directories. also neutralizes "../"
+// so that names can't point outside of the archive.
+func cleanArchivedName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// metadata of an entry in a source archive
+type archivedFileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+var _ fs.FileInfo = archivedFileInfo{}
+
+func (a archivedFileInfo) Name() string       { return path.Base(a.name) }
+func (a archivedFileInfo) Size() int64        { return a.size }
+func (a archivedFileInfo) Mode() fs.FileMode  { return a.mode }
+func (a archivedFileInfo) ModTime() time.Time { return a.modTime }
+func (a archivedFileInfo) IsDir() bool        { return a.mode.IsDir() }
+func (a archivedFileInfo) Sys() interface{}   { return nil }
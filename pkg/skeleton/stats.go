@@ -0,0 +1,59 @@
+package skeleton
+
+import (
+	"io"
+	"io/fs"
+)
+
+// Stats describes what got archived
+type Stats struct {
+	Files              int
+	Dirs               int
+	LogicalBytes       int64 // sum of file sizes, i.e. what the tree would take without the skeletonization
+	HardlinksCollapsed int
+	ArchiveBytes       int64          // size of the produced archive (all volumes, if split)
+	DeflateBytes       int64          // with other compression than deflate, what ArchiveBytes would've been with deflate. 0 otherwise
+	Volumes            int            // how many files the archive was split into. 1 if not split
+	CompressionLevel   int            // for FormatZip, the level that was used (CompressionLevelDefault resolved)
+	Interrupted        bool           // output was finalized before the walk completed
+	Truncated          bool           // the walk was stopped at Options.LimitEntries
+	MerkleRoot         string         // with Fingerprint, hex. for a split archive, of the volumes so far
+	Largest            []FileSize     // with Options.Top, the largest files archived (by path on disk), largest first
+	Unportable         int            // with CheckPortability, entries whose name Windows can't have
+	Deleted            []string       // with Since, archive names of the previous skeleton's entries that are gone. nil if the walk ended early
+	Skipped            map[string]int // entries left out by filters that need to look at file metadata, by reason
+	Errors             []string       // with SkipErrors, entries left out because of errors
+}
+
+func (s *Stats) count(fileInfo fs.FileInfo) {
+	if fileInfo.IsDir() {
+		s.Dirs++
+	} else {
+		s.Files++
+		s.LogicalBytes += fileInfo.Size()
+	}
+}
+
+// for an entry that got left out after it was counted
+func (s *Stats) uncount(fileInfo fs.FileInfo) {
+	if fileInfo.IsDir() {
+		s.Dirs--
+	} else {
+		s.Files--
+		s.LogicalBytes -= fileInfo.Size()
+	}
+}
+
+// counts bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+var _ io.Writer = (*countingWriter)(nil)
+
+func (c *countingWriter) Write(buf []byte) (int, error) {
+	n, err := c.w.Write(buf)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,49 @@
+package skeleton
+
+import (
+	"strings"
+)
+
+// like "$ tar --strip-components". "" if name has n or fewer components. directories' names end
+// in "/" (and keep it).
+func stripComponents(name string, n int) string {
+	for i := 0; i < n; i++ {
+		slash := strings.Index(name, "/")
+		if slash == -1 || slash == len(name)-1 { // nothing would be left
+			return ""
+		}
+
+		name = name[slash+1:]
+	}
+
+	return name
+}
+
+// with a prefix the name's other components are the ones from the source. prefix "." = none.
+func splitArchivePrefix(name string, prefix string) (string, string) {
+	if prefix == "." || !strings.HasPrefix(name, prefix+"/") {
+		return "", name
+	}
+
+	return prefix + "/", strings.TrimPrefix(name, prefix+"/")
+}
+
+// strips the components from the source's part of the names. false if nothing would be left.
+func stripEntryComponents(e entry, n int, prefix string) (entry, bool) {
+	namePrefix, name := splitArchivePrefix(e.name, prefix)
+
+	stripped := stripComponents(name, n)
+	if stripped == "" {
+		return e, false
+	}
+	e.name = namePrefix + stripped
+
+	if e.hardlinkTarget != "" {
+		targetPrefix, target := splitArchivePrefix(e.hardlinkTarget, prefix)
+		if strippedTarget := stripComponents(target, n); strippedTarget != "" {
+			e.hardlinkTarget = targetPrefix + strippedTarget
+		}
+	}
+
+	return e, true
+}
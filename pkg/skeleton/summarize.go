@@ -0,0 +1,195 @@
+package skeleton
+
+import (
+	"context"
+	"os"
+	"sort"
+
+	"github.com/function61/gokit/log/logex"
+)
+
+// TreeStats are aggregates of a tree, for deciding whether (or how) to archive it
+type TreeStats struct {
+	Files       int // an inode with several hardlinks is one file
+	Dirs        int
+	Hardlinks   int              // further links to files already counted, left out of the other numbers
+	Bytes       int64            // sum of file sizes
+	ByExtension []ExtensionStats // of regular files. largest total size first
+	Largest     []FileSize       // regular files, largest first
+	Histogram   []SizeBucket     // regular files by size. smallest bucket first
+	Allocation  *AllocationStats // of regular files. nil if the platform doesn't tell
+	Interrupted bool
+	Skipped     map[string]int // by reason
+	Errors      []string       // with SkipErrors
+}
+
+type ExtensionStats struct {
+	Extension string // lowercased, incl. the dot. "" for files without one
+	Files     int
+	Bytes     int64
+}
+
+// AllocationStats tell genuinely empty files from sparse ones, which a size alone can't
+type AllocationStats struct {
+	Empty          int   // size 0
+	Dense          int   // allocated at least their size
+	Sparse         int   // allocated less than their size (holes, or compressed by the filesystem)
+	SparseBytes    int64 // sum of sparse files' sizes
+	AllocatedBytes int64 // on disk, of all regular files
+}
+
+func (a *AllocationStats) count(size int64, allocated int64) {
+	a.AllocatedBytes += allocated
+
+	switch {
+	case size == 0:
+		a.Empty++
+	case allocated < size:
+		a.Sparse++
+		a.SparseBytes += size
+	default:
+		a.Dense++
+	}
+}
+
+type SizeBucket struct {
+	Below int64 // exclusive upper bound of sizes. -1 for the last bucket, which has no bound
+	Files int
+	Bytes int64
+}
+
+// 0, < 1 KiB, < 1 MiB, < 1 GiB, >= 1 GiB
+func newSizeHistogram() []SizeBucket {
+	return []SizeBucket{{Below: 1}, {Below: 1 << 10}, {Below: 1 << 20}, {Below: 1 << 30}, {Below: -1}}
+}
+
+func countInHistogram(histogram []SizeBucket, size int64) {
+	for i := range histogram {
+		if histogram[i].Below == -1 || size < histogram[i].Below {
+			histogram[i].Files++
+			histogram[i].Bytes += size
+			return
+		}
+	}
+}
+
+type FileSize struct {
+	Path string // on disk
+	Size int64
+}
+
+// inserts file into largestFiles (sorted, largest first) if it's among the n largest
+func keepLargest(largestFiles []FileSize, file FileSize, n int) []FileSize {
+	if n <= 0 || (len(largestFiles) == n && file.Size <= largestFiles[len(largestFiles)-1].Size) {
+		return largestFiles
+	}
+
+	idx := sort.Search(len(largestFiles), func(i int) bool { return largestFiles[i].Size < file.Size })
+	largestFiles = append(largestFiles[:idx], append([]FileSize{file}, largestFiles[idx:]...)...)
+	if len(largestFiles) > n {
+		largestFiles = largestFiles[:n]
+	}
+
+	return largestFiles
+}
+
+// Summarize walks the roots like Archive() does (with the same filters), but only computes
+// aggregates instead of writing an archive. largest is how many of the largest files to report.
+func Summarize(ctx context.Context, roots []string, opts Options, largest int) (TreeStats, error) {
+	if opts.Logger == nil {
+		opts.Logger = logex.Discard
+	}
+
+	if err := validatePatterns(opts.Excludes); err != nil {
+		return TreeStats{}, err
+	}
+
+	walkRoots, err := osWalkRoots(roots, opts)
+	if err != nil {
+		return TreeStats{}, err
+	}
+
+	pathsLogger := opts.Logger
+	if opts.Quiet {
+		pathsLogger = logex.Discard
+	}
+
+	progress, err := newProgressReporter(opts.Progress, pathsLogger, os.Stderr)
+	if err != nil {
+		return TreeStats{}, err
+	}
+
+	state := newWalkState(progress, opts.Logger)
+
+	byExtension := map[string]*ExtensionStats{}
+	largestFiles := []FileSize{} // sorted, largest first
+	histogram := newSizeHistogram()
+	allocation := &AllocationStats{}
+	allocationKnown := false
+	hardlinks := 0
+
+	walkErr := walkSource(walkRoots)(ctx, state, opts, func(e entry) error {
+		if !e.fileInfo.Mode().IsRegular() { // sizes of others are meaningless here
+			return nil
+		}
+
+		if state.hardlinkTargetOf(e) != "" { // like the archive, which stores the content only once
+			state.stats.uncount(e.fileInfo)
+			hardlinks++
+			return nil
+		}
+
+		ext := fileExtension(e.fileInfo.Name())
+		if _, found := byExtension[ext]; !found {
+			byExtension[ext] = &ExtensionStats{Extension: ext}
+		}
+		byExtension[ext].Files++
+		byExtension[ext].Bytes += e.fileInfo.Size()
+
+		countInHistogram(histogram, e.fileInfo.Size())
+
+		if allocated, ok := getAllocatedBytes(e.fileInfo); ok {
+			allocation.count(e.fileInfo.Size(), allocated)
+			allocationKnown = true
+		}
+
+		largestFiles = keepLargest(largestFiles, FileSize{Path: e.path, Size: e.fileInfo.Size()}, largest)
+
+		return nil
+	})
+	if walkErr != nil && ctx.Err() == nil { // a genuine error, instead of being interrupted
+		return TreeStats{}, walkErr
+	}
+
+	progress.Done(state.stats)
+
+	extensions := []ExtensionStats{}
+	for _, ext := range byExtension {
+		extensions = append(extensions, *ext)
+	}
+	sort.Slice(extensions, func(i, j int) bool {
+		if extensions[i].Bytes != extensions[j].Bytes {
+			return extensions[i].Bytes > extensions[j].Bytes
+		}
+
+		return extensions[i].Extension < extensions[j].Extension
+	})
+
+	if !allocationKnown {
+		allocation = nil
+	}
+
+	return TreeStats{
+		Files:       state.stats.Files,
+		Dirs:        state.stats.Dirs,
+		Hardlinks:   hardlinks,
+		Bytes:       state.stats.LogicalBytes,
+		ByExtension: extensions,
+		Largest:     largestFiles,
+		Histogram:   histogram,
+		Allocation:  allocation,
+		Interrupted: walkErr != nil,
+		Skipped:     state.stats.Skipped,
+		Errors:      state.stats.Errors,
+	}, walkErr
+}
@@ -0,0 +1,39 @@
+package skeleton
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSummarizeCountsHardlinkedFileOnce(t *testing.T) {
+	if !fileIDsSupported {
+		t.Skip("hardlinks aren't detected on this platform")
+	}
+
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "a.bin"), []byte(strings.Repeat("x", 1000)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "other.txt"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, link := range []string{"b.bin", "c.bin"} {
+		if err := os.Link(filepath.Join(root, "a.bin"), filepath.Join(root, link)); err != nil {
+			t.Skipf("can't create hardlinks: %v", err)
+		}
+	}
+
+	treeStats, err := Summarize(context.Background(), []string{root}, DefaultOptions(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEqual(t, treeStats.Files, 2)
+	assertEqual(t, treeStats.Hardlinks, 2)
+	assertEqual(t, treeStats.Bytes, int64(1001))
+	assertEqual(t, len(treeStats.Largest), 2)
+	assertEqual(t, treeStats.ByExtension[0], ExtensionStats{Extension: ".bin", Files: 1, Bytes: 1000})
+}
@@ -0,0 +1,31 @@
+package skeleton
+
+import (
+	"os"
+)
+
+// uniquely identifies a file (inode) within a system
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// FIFOs, sockets and device nodes. these have no content we could (or should try to) read.
+func isSpecialFile(mode os.FileMode) bool {
+	return mode&(os.ModeNamedPipe|os.ModeSocket|os.ModeDevice) != 0
+}
+
+func describeSpecialFile(mode os.FileMode) string {
+	switch {
+	case mode&os.ModeNamedPipe != 0:
+		return "FIFO"
+	case mode&os.ModeSocket != 0:
+		return "socket"
+	case mode&os.ModeCharDevice != 0:
+		return "character device"
+	case mode&os.ModeDevice != 0:
+		return "block device"
+	default:
+		return "special file"
+	}
+}
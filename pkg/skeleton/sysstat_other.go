@@ -0,0 +1,39 @@
+//go:build !(aix || darwin || dragonfly || freebsd || illumos || linux || netbsd || openbsd || solaris)
+
+package skeleton
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// not supported on this platform
+const fileIDsSupported = false
+
+func getFileID(fi fs.FileInfo) (fileID, uint64, bool) {
+	return fileID{}, 0, false
+}
+
+func getFileOwner(fi fs.FileInfo) (uint32, uint32, bool) {
+	return 0, 0, false
+}
+
+func getAllocatedBytes(fi fs.FileInfo) (int64, bool) {
+	return 0, false
+}
+
+func getDeviceNumber(fi fs.FileInfo) (uint32, uint32, bool) {
+	return 0, 0, false
+}
+
+// nothing of fileInfo.Sys() is used on this platform
+type spilledSys struct{}
+
+func spillSys(fi fs.FileInfo) *spilledSys {
+	return nil
+}
+
+func createSpecialFile(path string, mode os.FileMode, major uint32, minor uint32) error {
+	return fmt.Errorf("can't create %s on this platform", describeSpecialFile(mode))
+}
@@ -0,0 +1,76 @@
+//go:build aix || darwin || dragonfly || freebsd || illumos || linux || netbsd || openbsd || solaris
+
+package skeleton
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// as are owners, allocation and device numbers, which come from the same stat
+const fileIDsSupported = true
+
+// returns identity of the file and its hardlink count
+func getFileID(fi fs.FileInfo) (fileID, uint64, bool) {
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, 0, false
+	}
+
+	return fileID{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}, uint64(stat.Nlink), true // widths differ by OS
+}
+
+func getFileOwner(fi fs.FileInfo) (uint32, uint32, bool) {
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+
+	return stat.Uid, stat.Gid, true
+}
+
+// space the file takes on disk, which for sparse (or filesystem-compressed) files is less than
+// its size. st_blocks is in 512-byte units regardless of the filesystem's block size.
+func getAllocatedBytes(fi fs.FileInfo) (int64, bool) {
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+
+	return int64(stat.Blocks) * 512, true
+}
+
+// what of fileInfo.Sys() survives spilling entries to disk (see entrySorter)
+type spilledSys = syscall.Stat_t
+
+func spillSys(fi fs.FileInfo) *spilledSys {
+	stat, _ := fi.Sys().(*syscall.Stat_t)
+	return stat
+}
+
+// for device nodes
+func getDeviceNumber(fi fs.FileInfo) (uint32, uint32, bool) {
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+
+	return unix.Major(uint64(stat.Rdev)), unix.Minor(uint64(stat.Rdev)), true
+}
+
+func createSpecialFile(path string, mode os.FileMode, major uint32, minor uint32) error {
+	switch {
+	case mode&os.ModeNamedPipe != 0:
+		return unix.Mkfifo(path, uint32(mode.Perm()))
+	case mode&os.ModeCharDevice != 0:
+		return unix.Mknod(path, unix.S_IFCHR|uint32(mode.Perm()), mknodDev(major, minor))
+	case mode&os.ModeDevice != 0:
+		return unix.Mknod(path, unix.S_IFBLK|uint32(mode.Perm()), mknodDev(major, minor))
+	default: // sockets only come into existence by a process binding to them
+		return fmt.Errorf("can't create %s", describeSpecialFile(mode))
+	}
+}
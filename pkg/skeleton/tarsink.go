@@ -0,0 +1,196 @@
+package skeleton
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// writes a tar (optionally gzipped) stream. tar has native support for symlinks, hardlinks,
+// device nodes, owners and (as PAX records) xattrs. hashes and content types aren't stored, because
+// e.g. GNU tar warns about every unknown PAX record.
+type tarSink struct {
+	tarWriter     *tar.Writer
+	gzipWriter    *gzip.Writer // nil if not compressed
+	state         *walkState
+	opts          Options
+	ownerNames    *ownerNameCache
+	scanned       time.Time      // for the manifest
+	restoreScript *restoreScript // nil = not requested
+	fingerprint   *fingerprinter // nil = not requested
+}
+
+func newTarSink(output io.Writer, state *walkState, opts Options, scanned time.Time) *tarSink {
+	t := &tarSink{
+		state:      state,
+		opts:       opts,
+		ownerNames: newOwnerNameCache(),
+		scanned:    scanned,
+	}
+
+	if opts.Format == FormatTarGz {
+		t.gzipWriter = gzip.NewWriter(output)
+		output = t.gzipWriter
+	}
+
+	t.tarWriter = tar.NewWriter(output)
+
+	if opts.RestoreScript {
+		t.restoreScript = newRestoreScript()
+	}
+
+	if opts.Fingerprint {
+		t.fingerprint = &fingerprinter{}
+	}
+
+	return t
+}
+
+func (t *tarSink) Entry(e entry) error {
+	fileInfo := e.fileInfo
+
+	withErr := func(err error) error {
+		return fmt.Errorf("%s: %w", e.path, err)
+	}
+
+	if err := t.opts.trailerNames().assertNotClashing(e); err != nil {
+		return err
+	}
+
+	if fileInfo.Mode()&os.ModeSocket != 0 {
+		t.state.logl.Info.Printf("%s: sockets can't be stored in tar. leaving out.", e.path)
+		t.state.stats.uncount(fileInfo)
+		return nil
+	}
+
+	header, err := tar.FileInfoHeader(fileInfo, e.symlinkTarget)
+	if err != nil {
+		return withErr(err)
+	}
+
+	header.Name = e.name
+	header.Format = tar.FormatPAX  // for sub-second mtimes
+	header.AccessTime = e.accessed // zero (= not recorded) unless AllTimes
+	header.ChangeTime = e.changed
+	header.PAXRecords = map[string]string{}
+
+	// FileInfoHeader() fills these in from the OS, but they're recorded only if asked
+	header.Uid, header.Gid, header.Uname, header.Gname = 0, 0, "", ""
+	if uid, gid, ok := getFileOwner(fileInfo); t.opts.Owners && ok {
+		owner := t.ownerNames.resolve(uid, gid)
+
+		header.Uid, header.Gid = int(owner.uid), int(owner.gid)
+		header.Uname, header.Gname = owner.userName, owner.groupName
+	}
+
+	if major, minor, ok := getDeviceNumber(fileInfo); ok && fileInfo.Mode()&os.ModeDevice != 0 {
+		header.Devmajor, header.Devminor = int64(major), int64(minor)
+	}
+
+	for _, attr := range e.xattrs {
+		header.PAXRecords["SCHILY.xattr."+attr.name] = string(attr.value)
+	}
+
+	hardlinkTarget := t.state.hardlinkTargetOf(e)
+	if hardlinkTarget != "" {
+		header.Typeflag = tar.TypeLink
+		header.Linkname = hardlinkTarget
+		header.Size = 0
+
+		t.state.stats.HardlinksCollapsed++
+	}
+
+	if err := t.tarWriter.WriteHeader(header); err != nil {
+		return withErr(err)
+	}
+
+	if t.restoreScript != nil {
+		t.restoreScript.add(e, hardlinkTarget)
+	}
+
+	if t.fingerprint != nil {
+		t.fingerprint.add(e, hardlinkTarget)
+	}
+
+	if header.Typeflag == tar.TypeReg {
+		if _, err := io.Copy(t.tarWriter, io.LimitReader(newFillReader(t.opts.fill), header.Size)); err != nil {
+			return withErr(err)
+		}
+	}
+
+	return nil
+}
+
+func (t *tarSink) Close() error {
+	if t.fingerprint != nil {
+		t.state.stats.MerkleRoot = t.fingerprint.root()
+	}
+
+	readmeText := readmeFillText(t.opts.fill) + readmeIncompleteText(t.state.stats, t.opts) + readmeIncrementalText(t.opts) + readmeProvenanceText(t.opts, t.scanned, t.state.stats)
+	if t.opts.ReadmeContent != nil {
+		readmeText = string(t.opts.ReadmeContent)
+	}
+
+	manifest := newZipManifest(t.opts, t.scanned, t.state.stats, 0).marshal()
+
+	// a reader streaming the tar learns from the manifest that the README isn't the usual one, so it
+	// has to come first then
+	if t.opts.ReadmeName != "" || t.opts.NoReadme {
+		if err := t.writeTrailerEntry(manifestName, manifest); err != nil {
+			return err
+		}
+
+		if !t.opts.NoReadme {
+			if err := t.writeTrailerEntry(t.opts.ReadmeName, []byte(readmeText)); err != nil {
+				return err
+			}
+		}
+	} else {
+		if err := t.writeTrailerEntry(readmeName, []byte(readmeText)); err != nil {
+			return err
+		}
+
+		if err := t.writeTrailerEntry(manifestName, manifest); err != nil {
+			return err
+		}
+	}
+
+	if t.restoreScript != nil {
+		if err := t.writeTrailerEntry(restoreScriptName, t.restoreScript.bytes()); err != nil {
+			return err
+		}
+	}
+
+	if err := t.tarWriter.Close(); err != nil {
+		return err
+	}
+
+	if t.gzipWriter != nil {
+		return t.gzipWriter.Close()
+	}
+
+	return nil
+}
+
+func (t *tarSink) writeTrailerEntry(name string, content []byte) error {
+	modified, err := archiveTimestamp(t.opts)
+	if err != nil {
+		return err
+	}
+
+	if err := t.tarWriter.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     int64(len(content)),
+		Mode:     0644,
+		ModTime:  modified,
+	}); err != nil {
+		return err
+	}
+
+	_, err = t.tarWriter.Write(content)
+	return err
+}
@@ -0,0 +1,106 @@
+package skeleton
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// for being a good neighbor on shared storage: MaxEntriesRate paces the metadata walk and
+// MaxReadRate the reading of files' content. nil limiter = unlimited.
+type throttle struct {
+	entries *rate.Limiter
+	reads   *rate.Limiter
+}
+
+func newThrottle(opts Options) throttle {
+	return throttle{
+		entries: newRateLimiter(int64(opts.MaxEntriesRate)),
+		reads:   newRateLimiter(opts.MaxReadRate),
+	}
+}
+
+// a second's worth of burst, so that a pause doesn't get made up for with a spike
+func newRateLimiter(perSecond int64) *rate.Limiter {
+	if perSecond <= 0 {
+		return nil
+	}
+
+	return rate.NewLimiter(rate.Limit(perSecond), int(perSecond))
+}
+
+// blocks until the next entry may be walked
+func (t throttle) waitEntry(ctx context.Context) error {
+	if t.entries == nil {
+		return nil
+	}
+
+	return waitRate(ctx, t.entries, 1)
+}
+
+// like limiter.WaitN(), but with a deadline this waits until it's reached (instead of failing right
+// away if the wait would go past it), so that a --timeout looks like one
+func waitRate(ctx context.Context, limiter *rate.Limiter, n int) error {
+	reservation := limiter.ReserveN(time.Now(), n)
+	if !reservation.OK() {
+		return fmt.Errorf("rate: %d exceeds the burst %d", n, limiter.Burst())
+	}
+
+	delay := time.NewTimer(reservation.Delay())
+	defer delay.Stop()
+
+	select {
+	case <-delay.C:
+		return nil
+	case <-ctx.Done():
+		reservation.Cancel()
+		return ctx.Err()
+	}
+}
+
+// files opened from the returned FS are read at most at MaxReadRate
+func (t throttle) readFS(ctx context.Context, fsys fs.FS) fs.FS {
+	if t.reads == nil {
+		return fsys
+	}
+
+	return &throttledFS{FS: fsys, ctx: ctx, limiter: t.reads}
+}
+
+type throttledFS struct {
+	fs.FS
+	ctx     context.Context
+	limiter *rate.Limiter
+}
+
+func (t *throttledFS) Open(name string) (fs.File, error) {
+	file, err := t.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &throttledFile{File: file, fsys: t}, nil
+}
+
+type throttledFile struct {
+	fs.File
+	fsys *throttledFS
+}
+
+func (t *throttledFile) Read(p []byte) (int, error) {
+	if burst := t.fsys.limiter.Burst(); len(p) > burst { // can't wait for more than the burst
+		p = p[:burst]
+	}
+
+	n, err := t.File.Read(p)
+	if n > 0 { // paid for afterwards, as only now it's known how much was read
+		if errWait := waitRate(t.fsys.ctx, t.fsys.limiter, n); errWait != nil {
+			return n, errWait
+		}
+	}
+
+	return n, err
+}
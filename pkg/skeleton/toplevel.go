@@ -0,0 +1,240 @@
+package skeleton
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// with SplitByTopLevel, each of the root's immediate subdirectories (with its subtree) goes to an
+// archive of its own, and the rest (the root itself and the files directly in it) to the main one.
+// each archive is complete by itself: its README and manifest cover only its own entries.
+//
+// when the entries come in walk order (a subtree at a time) or sorted by name, a subdirectory's
+// archive is finished as soon as an entry past its subtree is seen, so that a tree with lots of
+// subdirectories doesn't run out of file descriptors. otherwise they're all finished at the end.
+type topLevelSink struct {
+	rootName    string                      // archive name of the root. "" until the root has been seen
+	main        *topLevelArchive            // the root and the files directly in it
+	subdirs     map[string]*topLevelArchive // by subdirectory's name
+	order       []*topLevelArchive          // for closing in a stable order
+	unfinished  []string                    // subdirectories whose archive is still open, if finishing early
+	finishEarly bool
+	state       *walkState // for the totals
+	opts        Options
+	scanned     time.Time
+}
+
+// one of the archives
+type topLevelArchive struct {
+	sink     *zipSink
+	volumes  *volumes
+	output   io.Writer  // as given by TopLevelOutput. nil for the main one
+	state    *walkState // counts only this archive's entries
+	finished bool
+}
+
+func newTopLevelSink(vols *volumes, state *walkState, opts Options) (*topLevelSink, error) {
+	scanned, err := archiveTimestamp(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &topLevelSink{
+		subdirs: map[string]*topLevelArchive{},
+		state:   state,
+		opts:    opts,
+		scanned: scanned,
+		// concurrent walkers interleave the subtrees, as can the entries of a source archive
+		finishEarly: opts.Reproducible || (opts.Concurrency <= 1 && !opts.sourceOrder),
+	}
+	t.main = t.newArchive(vols, "")
+
+	return t, nil
+}
+
+func (t *topLevelSink) newArchive(vols *volumes, subdir string) *topLevelArchive {
+	state := &walkState{
+		progress:   t.state.progress,
+		logl:       t.state.logl,
+		seenInodes: map[fileID]string{}, // so a hardlink never refers to an entry of another archive
+		stats: Stats{
+			Skipped: map[string]int{},
+		},
+	}
+
+	sink := newZipSink(vols, state, t.opts, t.scanned)
+	if subdir != "" { // the main one's is known only once the root has been seen
+		sink.topLevelText = readmeTopLevelText(t.rootName, subdir)
+	}
+
+	archive := &topLevelArchive{sink: sink, volumes: vols, state: state}
+	t.order = append(t.order, archive)
+
+	return archive
+}
+
+func (t *topLevelSink) Entry(e entry) error {
+	if t.rootName == "" {
+		if !e.fileInfo.IsDir() {
+			return fmt.Errorf("%s: splitting by top-level directory needs a directory as the root", e.path)
+		}
+
+		t.rootName = e.name
+		t.main.sink.topLevelText = readmeTopLevelText(t.rootName, "")
+	}
+
+	if t.finishEarly {
+		if err := t.finishPassed(e.name); err != nil {
+			return err
+		}
+	}
+
+	archive, err := t.archiveFor(e.name)
+	if err != nil {
+		return err
+	}
+
+	// a hardlink known upfront (from a source archive) can't refer to an entry of another archive,
+	// so it gets stored as the file itself
+	if e.hardlinkTarget != "" && t.subdirOf(e.hardlinkTarget) != t.subdirOf(e.name) {
+		t.state.logl.Debug.Printf("%s: hardlink target %s is in another archive. storing as a file.", e.path, e.hardlinkTarget)
+		e.hardlinkTarget = ""
+	}
+
+	archive.state.stats.count(e.fileInfo)
+
+	return archive.sink.Entry(e)
+}
+
+// the archive for an entry, opening it if this is the subdirectory itself
+func (t *topLevelSink) archiveFor(name string) (*topLevelArchive, error) {
+	subdir := t.subdirOf(name)
+	if subdir == "" {
+		return t.main, nil
+	}
+
+	if archive, found := t.subdirs[subdir]; found {
+		return archive, nil
+	}
+
+	output, err := t.opts.TopLevelOutput(subdir)
+	if err != nil {
+		return nil, err
+	}
+
+	vols, err := newVolumes(output, t.opts)
+	if err != nil {
+		return nil, err
+	}
+
+	archive := t.newArchive(vols, subdir)
+	archive.output = output
+	t.subdirs[subdir] = archive
+	if t.finishEarly {
+		t.unfinished = append(t.unfinished, subdir)
+	}
+
+	return archive, nil
+}
+
+// finishes the archives of the subdirectories whose subtree the walk is done with. in walk order
+// no entry of a subtree comes after the subtree, and sorted by name neither does one that's past it
+// ("root/b" for "root/a/"), so seeing such an entry means that the subtree is complete.
+func (t *topLevelSink) finishPassed(name string) error {
+	unfinished := t.unfinished[:0]
+	for _, subdir := range t.unfinished {
+		prefix := t.rootName + subdir + "/"
+		if name < prefix || strings.HasPrefix(name, prefix) { // "root/a-b" of "root/a/" still can be followed by "root/a/x"
+			unfinished = append(unfinished, subdir)
+			continue
+		}
+
+		if err := t.finish(t.subdirs[subdir]); err != nil {
+			return err
+		}
+	}
+	t.unfinished = unfinished
+
+	return nil
+}
+
+// finalizes one of the archives. the main one's volumes are closed by the caller, like for a
+// single archive.
+func (t *topLevelSink) finish(archive *topLevelArchive) error {
+	// the walk tells these only to the main state. an archive finished early was complete by then.
+	archive.state.stats.Interrupted = t.state.stats.Interrupted
+	archive.state.stats.Truncated = t.state.stats.Truncated
+
+	if err := archive.sink.Close(); err != nil {
+		return err
+	}
+
+	t.state.stats.HardlinksCollapsed += archive.state.stats.HardlinksCollapsed
+	archive.finished = true
+
+	if archive == t.main {
+		return nil
+	}
+
+	if err := archive.volumes.closeCurrent(); err != nil {
+		return err
+	}
+
+	if closer, isCloser := archive.output.(io.Closer); isCloser {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// "root/sub/file" => "sub". "" for the root itself and the files directly in it.
+func (t *topLevelSink) subdirOf(name string) string {
+	rel := strings.TrimPrefix(name, t.rootName)
+	if rel == name || rel == "" { // not under the root (can't happen for a walked entry), or the root itself
+		return ""
+	}
+
+	slash := strings.IndexByte(rel, '/')
+	if slash == -1 { // a file directly in the root
+		return ""
+	}
+
+	return rel[:slash]
+}
+
+// finalizes all the archives. the totals (in the state that the walk counted to) are kept, except
+// that the archives' bytes and volumes are summed into them.
+func (t *topLevelSink) Close() error {
+	for _, archive := range t.order {
+		if archive.finished {
+			continue
+		}
+
+		if err := t.finish(archive); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// bytes and volumes of the archives other than the main one
+func (t *topLevelSink) subdirTotals() (int64, int) {
+	bytes := int64(0)
+	for _, archive := range t.order[1:] {
+		bytes += archive.volumes.doneBytes
+	}
+
+	return bytes, len(t.order) - 1
+}
+
+// for the README of one of the archives
+func readmeTopLevelText(rootName string, subdir string) string {
+	if subdir == "" {
+		return fmt.Sprintf("\n\nThis archive is one of a set split by top-level directory. It holds %s and the files directly in it, while each of its subdirectories is in an archive of its own.", rootName)
+	}
+
+	return fmt.Sprintf("\n\nThis archive is one of a set split by top-level directory. It holds only %s%s/.", rootName, subdir)
+}
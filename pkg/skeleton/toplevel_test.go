@@ -0,0 +1,114 @@
+package skeleton
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+// like a process with a small limit on open files
+type limitedFiles struct {
+	dir   string
+	limit int
+	open  int
+	names map[string]string // subdir => archive's path
+}
+
+func (l *limitedFiles) create(subdir string) (*limitedFile, error) {
+	if l.open == l.limit {
+		return nil, fmt.Errorf("%s: too many open files", subdir)
+	}
+
+	file, err := os.Create(filepath.Join(l.dir, subdir+".zip"))
+	if err != nil {
+		return nil, err
+	}
+
+	l.open++
+	l.names[subdir] = file.Name()
+
+	return &limitedFile{File: file, files: l}, nil
+}
+
+type limitedFile struct {
+	*os.File
+	files *limitedFiles
+}
+
+func (f *limitedFile) Close() error {
+	f.files.open--
+	return f.File.Close()
+}
+
+func TestSplitByTopLevelClosesFinishedArchives(t *testing.T) {
+	fsys := fstest.MapFS{
+		"root/file.txt": testFile("in the main archive"),
+	}
+	for i := 0; i < 100; i++ {
+		fsys[fmt.Sprintf("root/d%03d/file.txt", i)] = testFile("content")
+		// sorted by name, "root/d000-b/" comes between "root/d000/" and "root/d000/file.txt"
+		fsys[fmt.Sprintf("root/d%03d-b/file.txt", i)] = testFile("content")
+	}
+
+	for _, tc := range []struct {
+		name string
+		opts func(*Options)
+	}{
+		{"walk order", func(opts *Options) {}},
+		{"reproducible", func(opts *Options) { opts.Reproducible = true }},
+		{"reproducible and concurrent", func(opts *Options) {
+			opts.Reproducible = true
+			opts.Concurrency = 4
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			files := &limitedFiles{dir: t.TempDir(), limit: 8, names: map[string]string{}}
+
+			opts := DefaultOptions()
+			opts.SplitByTopLevel = true
+			opts.TopLevelOutput = func(subdir string) (io.Writer, error) { return files.create(subdir) }
+			tc.opts(&opts)
+
+			mainArchive, stats := archiveTestFS(t, fsys, []string{"root"}, opts)
+
+			assertEqual(t, files.open, 0)
+			assertEqual(t, len(files.names), 200)
+			assertEqual(t, stats.Volumes, 201)
+			assertEqual(t, entryPaths(listTestArchive(t, mainArchive)), []string{"root", "root/file.txt"})
+
+			for _, subdir := range []string{"d000", "d000-b", "d099"} {
+				assertEqual(t, entryPaths(listTestArchive(t, files.names[subdir])), []string{
+					"root/" + subdir,
+					"root/" + subdir + "/file.txt",
+				})
+			}
+		})
+	}
+}
+
+func TestSplitByTopLevelFinishesInterleavedArchivesAtTheEnd(t *testing.T) {
+	fsys := fstest.MapFS{
+		"root/a/file.txt": testFile("content"),
+		"root/b/file.txt": testFile("content"),
+	}
+
+	files := &limitedFiles{dir: t.TempDir(), limit: 2, names: map[string]string{}}
+
+	opts := DefaultOptions()
+	opts.SplitByTopLevel = true
+	opts.TopLevelOutput = func(subdir string) (io.Writer, error) { return files.create(subdir) }
+	opts.Concurrency = 4 // subtrees can interleave, so none can be finished before the end
+
+	output := bytes.Buffer{}
+	if _, err := ArchiveFS(context.Background(), &output, fsys, []string{"root"}, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	assertEqual(t, files.open, 0)
+	assertEqual(t, entryPaths(listTestArchive(t, files.names["b"])), []string{"root/b", "root/b/file.txt"})
+}
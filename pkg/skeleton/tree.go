@@ -0,0 +1,107 @@
+package skeleton
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// WriteTree renders entries as an indented tree like "$ tree" does, with the counts at the bottom.
+// parent directories that are missing from entries are shown anyway.
+func WriteTree(output io.Writer, entries []ManifestEntry) error {
+	root := newTreeNode(".")
+
+	for _, e := range entries {
+		node := root
+		for _, component := range strings.Split(e.Path, "/") {
+			child, found := node.children[component]
+			if !found {
+				child = newTreeNode(component)
+				node.children[component] = child
+			}
+
+			node = child
+		}
+
+		node.isDir = e.IsDir
+	}
+
+	buffered := bufio.NewWriter(output)
+
+	fmt.Fprintln(buffered, root.name)
+	dirs, files := root.write(buffered, "")
+
+	fmt.Fprintf(buffered, "\n%d directories, %d files\n", dirs, files)
+
+	return buffered.Flush()
+}
+
+type treeNode struct {
+	name     string
+	isDir    bool
+	children map[string]*treeNode
+}
+
+func newTreeNode(name string) *treeNode {
+	return &treeNode{
+		name:     name,
+		isDir:    true, // until seen otherwise, because it's someone's parent
+		children: map[string]*treeNode{},
+	}
+}
+
+// writes the children (not the node itself), returning how many dirs and files there were
+func (t *treeNode) write(output io.Writer, indent string) (int, int) {
+	names := []string{}
+	for name := range t.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	dirs, files := 0, 0
+
+	for i, name := range names {
+		child := t.children[name]
+
+		branch, childIndent := "├── ", "│   "
+		if i == len(names)-1 {
+			branch, childIndent = "└── ", "    "
+		}
+
+		fmt.Fprintf(output, "%s%s%s\n", indent, branch, child.name)
+
+		if !child.isDir {
+			files++
+			continue
+		}
+
+		dirs++
+		childDirs, childFiles := child.write(output, indent+childIndent)
+		dirs += childDirs
+		files += childFiles
+	}
+
+	return dirs, files
+}
+
+// renders the tree once all entries are known, because with concurrency (or multiple roots) the
+// entries can arrive in any order
+type treeSink struct {
+	output  io.Writer
+	entries []ManifestEntry
+}
+
+func newTreeSink(output io.Writer) *treeSink {
+	return &treeSink{output: output}
+}
+
+func (t *treeSink) Entry(e entry) error {
+	t.entries = append(t.entries, newManifestEntry(e))
+	return nil
+}
+
+func (t *treeSink) Close() error {
+	return WriteTree(t.output, t.entries)
+}
@@ -0,0 +1,229 @@
+package skeleton
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// VerifyOptions controls Verify(). naming options have to be the same as when archiving.
+type VerifyOptions struct {
+	HashContent bool                   // also compare content hashes (archive must have been made with Hash)
+	Password    func() ([]byte, error) // asked only if the archive is encrypted
+	RelativeTo  string
+	Prefix      string
+}
+
+// Verify compares a skeleton archive (all volumes of it, if split) to a live directory
+func Verify(ctx context.Context, archivePaths []string, dir string, opts VerifyOptions) (Differences, error) {
+	prefix, err := normalizeArchivePrefix(opts.Prefix)
+	if err != nil {
+		return Differences{}, err
+	}
+
+	archived, err := readArchiveMetadata(archivePaths, opts.Password)
+	if err != nil {
+		return Differences{}, err
+	}
+
+	live, err := readDirMetadata(ctx, dir, opts.RelativeTo, prefix, opts.HashContent)
+	if err != nil {
+		return Differences{}, err
+	}
+
+	return compareMetadata(archived, live), nil
+}
+
+// the metadata we compare between two representations of a tree
+type entryMetadata struct {
+	size            int64
+	mode            os.FileMode
+	modified        time.Time
+	modifiedPrecise bool   // false if only second-precision is known
+	sha256          string // hex. empty if not known
+}
+
+func readArchiveMetadata(archivePaths []string, password func() ([]byte, error)) (map[string]entryMetadata, error) {
+	archive, err := openArchives(archivePaths, password)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	metadatas := map[string]entryMetadata{}
+	hardlinkTargets := map[string]string{}
+
+	for _, entry := range archive.File {
+		if archive.trailer.has(entry) { // not part of the skeleton
+			continue
+		}
+
+		if target, isHardlink := findExtraField(entry.Extra, extraFieldHardlink); isHardlink {
+			hardlinkTargets[entry.Name] = string(target)
+		}
+
+		digest, _ := findExtraField(entry.Extra, extraFieldSHA256)
+		modified, modifiedPrecise := entryModified(entry)
+
+		metadatas[entry.Name] = entryMetadata{
+			size:            entryLogicalSize(entry),
+			mode:            entry.Mode(),
+			modified:        modified,
+			modifiedPrecise: modifiedPrecise,
+			sha256:          hex.EncodeToString(digest),
+		}
+	}
+
+	// hardlinks don't have content of their own, so their content is their target's
+	for name, target := range hardlinkTargets {
+		hardlink := metadatas[name]
+		hardlink.size = metadatas[target].size
+		hardlink.sha256 = metadatas[target].sha256
+		metadatas[name] = hardlink
+	}
+
+	return metadatas, nil
+}
+
+// entries are named the same way as when archiving
+func readDirMetadata(ctx context.Context, dir string, relativeTo string, prefix string, hashContent bool) (map[string]entryMetadata, error) {
+	metadatas := map[string]entryMetadata{}
+
+	rootName, err := rootArchiveName(dir, relativeTo)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := filepath.WalkDir(dir, func(path string, dirEntry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+			// continue
+		}
+
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		name := joinArchivePath(rootName, filepath.ToSlash(relPath))
+		if name == "." { // not recorded when archiving either
+			return nil
+		}
+
+		fileInfo, err := dirEntry.Info()
+		if err != nil {
+			return err
+		}
+
+		size := fileInfo.Size()
+		if fileInfo.IsDir() {
+			size = 0
+		}
+
+		digest := ""
+		if hashContent && fileInfo.Mode().IsRegular() {
+			digestRaw, err := hashFileSHA256(newOSDirFS(""), path)
+			if err != nil {
+				return err
+			}
+			digest = hex.EncodeToString(digestRaw)
+		}
+
+		metadatas[archiveName(joinArchivePath(prefix, name), fileInfo.IsDir())] = entryMetadata{
+			size:            size,
+			mode:            fileInfo.Mode(),
+			modified:        fileInfo.ModTime(),
+			modifiedPrecise: true,
+			sha256:          digest,
+		}
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return metadatas, nil
+}
+
+// Differences between two representations of a tree. names are as in the archive.
+type Differences struct {
+	Removed []string `json:"removed"` // only in old
+	Added   []string `json:"added"`   // only in new
+	Changed []Delta  `json:"changed"`
+}
+
+type Delta struct {
+	Path  string `json:"path"`
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+func compareMetadata(old map[string]entryMetadata, new map[string]entryMetadata) Differences {
+	diffs := Differences{
+		Removed: []string{},
+		Added:   []string{},
+		Changed: []Delta{},
+	}
+
+	for name, oldMetadata := range old {
+		newMetadata, found := new[name]
+		if !found {
+			diffs.Removed = append(diffs.Removed, name)
+			continue
+		}
+
+		if oldMetadata.size != newMetadata.size {
+			diffs.Changed = append(diffs.Changed, Delta{name, "size", fmt.Sprint(oldMetadata.size), fmt.Sprint(newMetadata.size)})
+		}
+
+		if oldMetadata.mode != newMetadata.mode {
+			diffs.Changed = append(diffs.Changed, Delta{name, "mode", oldMetadata.mode.String(), newMetadata.mode.String()})
+		}
+
+		if !modifiedEqual(oldMetadata, newMetadata) {
+			diffs.Changed = append(diffs.Changed, Delta{name, "modified", oldMetadata.modified.UTC().Format(time.RFC3339Nano), newMetadata.modified.UTC().Format(time.RFC3339Nano)})
+		}
+
+		// only comparable if both sides know the hash
+		if oldMetadata.sha256 != "" && newMetadata.sha256 != "" && oldMetadata.sha256 != newMetadata.sha256 {
+			diffs.Changed = append(diffs.Changed, Delta{name, "sha256", oldMetadata.sha256, newMetadata.sha256})
+		}
+	}
+
+	for name := range new {
+		if _, found := old[name]; !found {
+			diffs.Added = append(diffs.Added, name)
+		}
+	}
+
+	sort.Strings(diffs.Removed)
+	sort.Strings(diffs.Added)
+	sort.SliceStable(diffs.Changed, func(i, j int) bool { return diffs.Changed[i].Path < diffs.Changed[j].Path })
+
+	return diffs
+}
+
+// compares at the precision both sides have
+func modifiedEqual(a entryMetadata, b entryMetadata) bool {
+	if a.modifiedPrecise && b.modifiedPrecise {
+		return a.modified.Equal(b.modified)
+	}
+
+	return a.modified.Unix() == b.modified.Unix()
+}
+
+func (m Differences) Count() int {
+	return len(m.Removed) + len(m.Added) + len(m.Changed)
+}
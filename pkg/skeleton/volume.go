@@ -0,0 +1,170 @@
+package skeleton
+
+import (
+	"compress/flate"
+	"io"
+)
+
+// the output file(s). with splitting, once the current volume is full the next one is asked for.
+type volumes struct {
+	password  []byte
+	next      func(number int) (io.Writer, error)
+	current   volume
+	number    int   // of the current volume. 1-based
+	doneBytes int64 // size of the finished volumes
+}
+
+// one output file, wrapped for counting and (optionally) encryption
+type volume struct {
+	counted   *countingWriter
+	encrypter *encryptingWriter // nil if not encrypting
+	output    io.Writer         // where the archive format gets written
+}
+
+func newVolumes(first io.Writer, opts Options) (*volumes, error) {
+	v := &volumes{
+		password: opts.Password,
+		next:     opts.NextVolume,
+		number:   1,
+	}
+
+	return v, v.open(first)
+}
+
+func (v *volumes) open(file io.Writer) error {
+	v.current = volume{counted: &countingWriter{w: file}}
+	v.current.output = v.current.counted
+
+	if v.password != nil { // each volume is a separate envelope, so they can be decrypted independently
+		encrypter, err := newEncryptingWriter(v.current.counted, v.password)
+		if err != nil {
+			return err
+		}
+
+		v.current.encrypter = encrypter
+		v.current.output = encrypter
+	}
+
+	return nil
+}
+
+// the archive format must have been finalized first
+func (v *volumes) closeCurrent() error {
+	if v.current.encrypter != nil {
+		if err := v.current.encrypter.Close(); err != nil {
+			return err
+		}
+	}
+
+	v.doneBytes += v.current.counted.n
+
+	return nil
+}
+
+func (v *volumes) rotate() error {
+	if err := v.closeCurrent(); err != nil {
+		return err
+	}
+
+	v.number++
+
+	file, err := v.next(v.number)
+	if err != nil {
+		return err
+	}
+
+	return v.open(file)
+}
+
+// with splitting. the sizes are estimated upfront (instead of measuring what got written),
+// because zip.Writer finalizes an entry only once the next one is started, and by then it'd be
+// too late to move the entry to the next volume. the content is synthetic, so its compressed
+// size is predictable.
+type volumeSplitter struct {
+	limit           int64
+	used            int64 // estimate for the entries in the current volume
+	entries         int   // in the current volume
+	compressedPerMB int64 // how much deflated fill takes per MiB of content
+}
+
+func newVolumeSplitter(limit int64, fill []byte, encrypted bool, compression string, level int) *volumeSplitter {
+	if encrypted { // envelope header + a GCM tag per chunk
+		limit -= int64(encryptedHeaderLen) + (limit/encryptedChunkSize+1)*encryptedChunkOverheadSize
+	}
+
+	const mib = 1024 * 1024
+
+	compressed := &countingWriter{w: io.Discard}
+	var compressor io.WriteCloser
+	if compression == CompressionZstd {
+		compressor, _ = newZstdCompressor(compressed, level) // error only for invalid options
+	} else {
+		compressor, _ = flate.NewWriter(compressed, effectiveCompressionLevel(compression, level)) // error only for invalid level
+	}
+	_, _ = io.Copy(compressor, io.LimitReader(newFillReader(fill), mib))
+	_ = compressor.Close()
+
+	return &volumeSplitter{
+		limit:           limit,
+		compressedPerMB: compressed.n,
+	}
+}
+
+// whether the entry still fits the current volume. if not, the caller starts a new volume and
+// calls this again. an entry that alone is over the limit gets a volume of its own, because
+// entries are never split across volumes.
+func (v *volumeSplitter) fits(entrySize int64, reserved int64) bool {
+	if v.entries > 0 && v.used+entrySize+reserved > v.limit {
+		v.used = 0
+		v.entries = 0
+		return false
+	}
+
+	v.used += entrySize
+	v.entries++
+
+	return true
+}
+
+// local header + data descriptor + central directory record + content. zip64 extras (which
+// archive/zip adds for large entries) and deflate block overhead are accounted for pessimistically.
+func (v *volumeSplitter) estimateEntrySize(name string, extraLen int, size int64, compressed bool) int64 {
+	const (
+		localHeaderLen     = 30
+		dataDescriptorLen  = 24
+		centralDirEntryLen = 46
+		zip64ExtraLen      = 28
+	)
+
+	content := size
+	if compressed {
+		// deflate falls back to stored blocks (5 bytes of overhead per 64 KiB) for small content.
+		// zstd's raw blocks have less, but its frame header and checksum add some.
+		stored := size + 5*(size/65535+1) + 32
+		extrapolated := (size/(1024*1024)+1)*v.compressedPerMB + 64
+
+		content = extrapolated
+		if stored < extrapolated {
+			content = stored
+		}
+	}
+
+	return localHeaderLen + dataDescriptorLen + centralDirEntryLen + 2*(int64(len(name)+extraLen)+zip64ExtraLen) + content
+}
+
+// end of central directory records (incl. the zip64 ones) + comment + README + manifest.
+// readme is the README's name, "" if there's none.
+func (v *volumeSplitter) estimateTrailerSize(comment string, readme string, readmeText string, manifest []byte) int64 {
+	const (
+		endOfCentralDirLen = 22 + 56 + 20
+		countsGrowth       = 64 // the manifest's counts get more digits as entries get added
+	)
+
+	readmeSize := int64(0)
+	if readme != "" {
+		readmeSize = v.estimateEntrySize(readme, 0, int64(len(readmeText)), false)
+	}
+
+	return endOfCentralDirLen + int64(len(comment)) + readmeSize +
+		v.estimateEntrySize(manifestName, 0, int64(len(manifest)+countsGrowth), false)
+}
@@ -0,0 +1,809 @@
+package skeleton
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/function61/gokit/log/logex"
+)
+
+// mutable state shared across all roots of one archive
+type walkState struct {
+	progress   *progressReporter
+	logl       *logex.Leveled
+	seenInodes map[fileID]string // for detecting hardlinks. values are names of first-seen entries.
+	stats      Stats
+}
+
+func newWalkState(progress *progressReporter, logger *log.Logger) *walkState {
+	return &walkState{
+		progress:   progress,
+		logl:       logex.Levels(logger),
+		seenInodes: map[fileID]string{},
+		stats: Stats{
+			Skipped: map[string]int{},
+		},
+	}
+}
+
+// filesystem entry that passed the filters
+type entry struct {
+	path           string // on disk
+	name           string // in the archive
+	fileInfo       fs.FileInfo
+	sha256         []byte                        // digest of the real content, if requested
+	sample         []byte                        // start of the real content, if requested
+	content        func() (io.ReadCloser, error) // with KeepContentBelow, the whole real content. opened only when writing. nil = fill
+	contentType    string                        // detected from the real content, if requested
+	subtree        *SubtreeSize                  // for directories, if requested
+	childCounts    *ChildCounts                  // for directories, if requested
+	xattrs         []extendedAttribute           // if requested
+	symlinkTarget  string
+	hardlinkTarget string       // archive name, if the source already knows this is a hardlink (e.g. a tar)
+	accessed       time.Time    // with AllTimes, if the platform has it. zero otherwise
+	changed        time.Time    // inode change time. set along with accessed
+	fileAttrs      uint32       // with FileAttrs, Linux inode flags. see fileAttrsRecorded
+	allocated      *int64       // with WithAllocation, for regular files, bytes allocated on disk. nil if the platform doesn't tell
+	recordedExtra  []byte       // extra fields copied as-is, when the source is a skeleton itself
+	position       walkPosition // with StateFile, where in the walk this is
+}
+
+// 2nd (and subsequent) paths pointing to the same inode are recorded as references to the first
+// path. returns the first path's name, or "" if this is not such.
+func (w *walkState) hardlinkTargetOf(e entry) string {
+	if e.hardlinkTarget != "" || !e.fileInfo.Mode().IsRegular() {
+		return e.hardlinkTarget
+	}
+
+	if id, linkCount, ok := getFileID(e.fileInfo); ok && linkCount > 1 {
+		if firstName, seen := w.seenInodes[id]; seen {
+			return firstName
+		}
+
+		w.seenInodes[id] = e.name
+	}
+
+	return ""
+}
+
+// like "$ tar --files-from", visits only the listed paths (+ their parent directories) without walking
+func walkFilesFrom(ctx context.Context, listPath string, state *walkState, opts Options, visit func(entry) error) error {
+	list := os.Stdin
+	if listPath != "-" {
+		var err error
+		list, err = os.Open(listPath)
+		if err != nil {
+			return err
+		}
+		defer list.Close()
+	}
+
+	archiveCtx := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w := &walker{
+		ctx:        ctx,
+		cancel:     cancel,
+		archiveCtx: archiveCtx,
+		state:      state,
+		opts:       opts,
+		visit:      visit,
+		throttle:   newThrottle(opts),
+	}
+
+	w.startContentReaders()
+
+	// names are OS paths as given in the list
+	fsys := newOSDirFS("")
+
+	visited := map[string]bool{}
+	toldAboutStripping := false
+
+	visitOnce := func(path string, isParent bool) error {
+		if visited[path] {
+			return nil
+		}
+		visited[path] = true
+
+		name := filesFromArchiveName(path)
+		if opts.RelativeTo == "" && name != filepath.ToSlash(stripExtendedLengthPrefix(path)) && !toldAboutStripping {
+			w.state.logl.Info.Printf("removing leading \"/\" and \"../\" from names (like tar), so that restoring stays in the destination")
+			toldAboutStripping = true
+		}
+		if name == "" { // "/" or ".." itself
+			return nil
+		}
+
+		if opts.RelativeTo != "" {
+			var err error
+			if name, err = rootArchiveName(path, opts.RelativeTo); err != nil {
+				if isParent { // parents above the base are expected for absolute paths
+					return nil
+				}
+
+				return err
+			}
+
+			if name == "." { // the base itself
+				return nil
+			}
+		}
+
+		fileInfo, err := os.Lstat(path)
+		if err != nil {
+			return w.entryError(fmt.Errorf("%s: %w", path, err))
+		}
+
+		if opts.FollowSymlinks && fileInfo.Mode()&os.ModeSymlink != 0 {
+			if target, err := os.Stat(path); err == nil { // broken ones are recorded as-is
+				fileInfo = target
+			}
+		}
+
+		return w.visitPath(fsys, path, name, fileInfo, walkPosition{})
+	}
+
+	if err := w.visitList(bufio.NewScanner(list), visitOnce); err != nil {
+		w.setErr(err)
+	}
+
+	w.finishContentReaders()
+
+	return w.err
+}
+
+// like tar, a name doesn't keep what would make restoring it escape the destination: a leading
+// "/" (or volume name) and leading ".." components, which are the only ones left in a cleaned path
+func filesFromArchiveName(path string) string {
+	path = stripExtendedLengthPrefix(path)
+	name := strings.TrimLeft(filepath.ToSlash(strings.TrimPrefix(path, filepath.VolumeName(path))), "/")
+
+	for name == ".." || strings.HasPrefix(name, "../") {
+		name = strings.TrimPrefix(strings.TrimPrefix(name, ".."), "/")
+	}
+
+	return name
+}
+
+func (w *walker) visitList(lines *bufio.Scanner, visitOnce func(path string, isParent bool) error) error {
+	for lines.Scan() {
+		select {
+		case <-w.ctx.Done():
+			return w.ctx.Err()
+		default:
+			// continue
+		}
+
+		if err := w.throttle.waitEntry(w.ctx); err != nil {
+			return err
+		}
+
+		line := strings.TrimRight(lines.Text(), "\r")
+		if line == "" {
+			continue
+		}
+
+		path := filepath.Clean(line)
+
+		// parents first, so the hierarchy is represented even if the list doesn't mention them
+		for _, parent := range parentDirs(path) {
+			if err := visitOnce(parent, true); err != nil {
+				return err
+			}
+		}
+
+		if err := visitOnce(path, false); err != nil {
+			return err
+		}
+	}
+
+	return lines.Err()
+}
+
+// "a/b/c" => ["a", "a/b"]
+func parentDirs(path string) []string {
+	parents := []string{}
+
+	for parent := filepath.Dir(path); parent != "." && parent != filepath.Dir(parent); parent = filepath.Dir(parent) {
+		parents = append([]string{parent}, parents...)
+	}
+
+	return parents
+}
+
+// walks all roots, calling visit for each entry that passed the filters. visit is never called
+// concurrently, even with concurrency > 1.
+func walk(ctx context.Context, roots []walkRoot, state *walkState, opts Options, visit func(entry) error) error {
+	archiveCtx := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w := &walker{
+		ctx:        ctx,
+		cancel:     cancel,
+		archiveCtx: archiveCtx,
+		state:      state,
+		opts:       opts,
+		visit:      visit,
+		throttle:   newThrottle(opts),
+	}
+
+	// the calling goroutine is a walker as well
+	if opts.Concurrency > 1 {
+		w.handOffSlots = make(chan struct{}, opts.Concurrency-1)
+	}
+
+	w.startContentReaders()
+
+	for i, root := range roots {
+		root.index = i
+
+		if w.opts.resume != nil && w.opts.resume.rootDone(i) { // by an earlier walk
+			continue
+		}
+
+		job := walkJob{root: root, dir: root.dir, ancestors: &ancestorDirs{}}
+		if opts.Gitignore {
+			job.gitignores = &gitignoreStack{fsys: root.fsys}
+		}
+		if opts.Skeletonignore {
+			job.skeletonignores = &skeletonignoreStack{fsys: root.fsys}
+		}
+
+		if err := w.run(job); err != nil {
+			w.setErr(err)
+			break
+		}
+	}
+
+	w.handedOff.Wait()
+
+	w.finishContentReaders()
+
+	if w.err != nil {
+		return fmt.Errorf("walk: %w", w.err)
+	}
+
+	return nil
+}
+
+type walker struct {
+	ctx        context.Context
+	cancel     context.CancelFunc
+	archiveCtx context.Context // outlives ctx, for reading content after the walk (see entry.content)
+	state      *walkState
+	opts       Options
+	visit      func(entry) error
+
+	throttle throttle
+
+	// with concurrency, a walker can hand off a subdirectory to another goroutine if there's a free slot.
+	// with work split dynamically like this large subtrees don't leave other goroutines idle.
+	handOffSlots chan struct{} // nil if no concurrency
+	handedOff    sync.WaitGroup
+
+	readers *contentReaders // nil if content is read by the walking goroutine(s)
+
+	walkedDirsMu sync.Mutex
+	walkedDirs   map[fileID]bool // with FollowSymlinks, for walking each directory only once
+
+	visitMu sync.Mutex // guards state and visit
+
+	errMu sync.Mutex
+	err   error // first error encountered
+}
+
+// one of the roots to archive
+type walkRoot struct {
+	fsys fs.FS
+	dir  string // in fsys
+	name string // in the archive. "." if at top level
+
+	index int // among the roots
+}
+
+// walks one (sub)tree with fs.WalkDir()
+type walkJob struct {
+	root            walkRoot             // root that this job's dir is under
+	dir             string               // where to start walking (in root's fsys). if != root's dir, the dir itself has already been visited by the job that handed it off
+	gitignores      *gitignoreStack      // rules active for dir. nil if not using .gitignore
+	skeletonignores *skeletonignoreStack // patterns active for dir. nil if not using .skeletonignore
+	rootDevice      *uint64              // for --one-file-system. nil if not yet known
+	ancestors       *ancestorDirs        // of the current entry, for detecting cycles
+}
+
+func (w *walker) run(job walkJob) error {
+	return fs.WalkDir(job.root.fsys, job.dir, func(fsPath string, dirEntry fs.DirEntry, err error) error {
+		path := displayPath(job.root.fsys, fsPath)
+
+		withErr := func(err error) error {
+			return w.entryError(fmt.Errorf("%s: %w", path, err))
+		}
+
+		skip := func() error {
+			if dirEntry.IsDir() {
+				return fs.SkipDir // prunes the whole subtree
+			} else {
+				return nil
+			}
+		}
+
+		if err != nil {
+			if fsPath == job.root.dir { // a root that doesn't exist is not something that vanished during the scan
+				return fmt.Errorf("%s: %w", path, err)
+			}
+
+			return withErr(err)
+		}
+
+		select {
+		case <-w.ctx.Done():
+			return w.ctx.Err()
+		default:
+			// continue
+		}
+
+		if fsPath == job.dir && job.dir != job.root.dir { // already visited and filtered by the job that handed this off
+			return nil
+		}
+
+		if err := w.throttle.waitEntry(w.ctx); err != nil {
+			return err
+		}
+
+		relPath := fsRel(job.root.dir, fsPath) // slash-separated, like fs.FS paths are
+
+		// root's immediate children are at depth 0
+		depth := -1
+
+		position := walkPosition{root: job.root.index}
+		if relPath != "." {
+			position.child = strings.SplitN(relPath, "/", 2)[0]
+		}
+
+		// written by an earlier walk. the root itself was, but its children maybe not.
+		writtenEarlier := w.opts.resume != nil && w.opts.resume.doneEarlier(position)
+		if writtenEarlier && position.child != "" {
+			return skip()
+		}
+
+		if fsPath != job.root.dir { // root itself is never excluded
+			depth = strings.Count(relPath, "/")
+			if w.opts.MaxDepth >= 0 && depth > w.opts.MaxDepth {
+				return skip()
+			}
+
+			if matchesAnyPattern(relPath, w.opts.Excludes) {
+				return skip()
+			}
+
+			if w.opts.NoHidden && strings.HasPrefix(dirEntry.Name(), ".") {
+				return skip()
+			}
+
+			// git doesn't track its own metadata dir either
+			if job.gitignores != nil && ((dirEntry.IsDir() && dirEntry.Name() == ".git") || job.gitignores.Ignored(fsPath, dirEntry.IsDir())) {
+				return skip()
+			}
+
+			if job.skeletonignores != nil && job.skeletonignores.Ignored(fsPath) {
+				return skip()
+			}
+		}
+
+		if job.gitignores != nil && dirEntry.IsDir() {
+			if err := job.gitignores.Enter(fsPath); err != nil {
+				return withErr(err)
+			}
+		}
+
+		if job.skeletonignores != nil && dirEntry.IsDir() {
+			if err := job.skeletonignores.Enter(fsPath); err != nil {
+				return withErr(err)
+			}
+		}
+
+		fileInfo, err := dirEntry.Info()
+		if err != nil {
+			if err := withErr(err); err != nil {
+				return err
+			}
+
+			return skip() // a directory that vanished would otherwise get reported again when listing it fails
+		}
+
+		followedDir := false // a symlink to a directory, which fs.WalkDir() doesn't descend into by itself
+		if w.opts.FollowSymlinks && fileInfo.Mode()&os.ModeSymlink != 0 {
+			fileInfo, followedDir = w.follow(job.root.fsys, fsPath, fileInfo)
+
+			if followedDir && job.gitignores != nil {
+				if err := job.gitignores.Enter(fsPath); err != nil {
+					return withErr(err)
+				}
+			}
+
+			if followedDir && job.skeletonignores != nil {
+				if err := job.skeletonignores.Enter(fsPath); err != nil {
+					return withErr(err)
+				}
+			}
+		}
+
+		// record the directory, but its content only once
+		alreadyWalked := false
+		if fileInfo.IsDir() && !followedDir {
+			if w.opts.FollowSymlinks { // reached earlier through a symlink
+				alreadyWalked = w.markWalked(fileInfo)
+			} else if ancestor, isCycle := job.ancestors.enter(fsPath, fileInfo); isCycle { // e.g. bind-mounted inside itself
+				w.warn("%s: same directory as its ancestor %s (a bind mount loop?). not descending into it.", path, displayPath(job.root.fsys, ancestor))
+				alreadyWalked = true
+			}
+		}
+
+		// like with "$ tar --one-file-system", the mount point is recorded but not its content
+		crossesFilesystem := false
+		if fileInfo.IsDir() && w.opts.OneFileSystem {
+			if id, _, ok := getFileID(fileInfo); ok {
+				if job.rootDevice == nil {
+					job.rootDevice = &id.dev
+				} else if id.dev != *job.rootDevice {
+					crossesFilesystem = true
+				}
+			}
+		}
+
+		if name := joinArchivePath(job.root.name, relPath); name != "." && !writtenEarlier { // "." would produce a nonsensical "./" entry
+			if err := w.visitPath(job.root.fsys, fsPath, name, fileInfo, position); err != nil {
+				return err
+			}
+		}
+
+		if !fileInfo.IsDir() {
+			return nil
+		}
+
+		// to fs.WalkDir() a followed directory is a symlink, for which SkipDir would mean skipping the rest of its parent
+		skipChildren := fs.SkipDir
+		if followedDir {
+			skipChildren = nil
+		}
+
+		// record the directory itself, but its children would be too deep
+		if w.opts.MaxDepth >= 0 && depth == w.opts.MaxDepth {
+			return skipChildren
+		}
+
+		if crossesFilesystem {
+			return skipChildren
+		}
+
+		if alreadyWalked {
+			return skipChildren
+		}
+
+		if followedDir {
+			if !w.tryHandOff(job, fsPath) {
+				if err := w.run(newChildJob(job, fsPath)); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}
+
+		if fsPath != job.dir && w.tryHandOff(job, fsPath) {
+			return fs.SkipDir // the other goroutine takes care of the subtree
+		}
+
+		return nil
+	})
+}
+
+// name is slash-separated and without the trailing slash for directories
+func (w *walker) visitPath(fsys fs.FS, fsPath string, name string, fileInfo fs.FileInfo, position walkPosition) error {
+	path := displayPath(fsys, fsPath)
+
+	if reason := w.opts.metadataFilter(fileInfo); reason != "" {
+		w.visitMu.Lock()
+		defer w.visitMu.Unlock()
+
+		w.state.stats.Skipped[reason]++
+		return nil
+	}
+
+	e := entry{
+		path:     path,
+		name:     archiveName(joinArchivePath(w.opts.Prefix, name), fileInfo.IsDir()),
+		fileInfo: fileInfo,
+		position: position,
+	}
+
+	if fileInfo.Mode().IsRegular() && w.opts.keepsContent(fileInfo.Size()) {
+		contentFS := w.throttle.readFS(w.archiveCtx, fsys)
+		e.content = func() (io.ReadCloser, error) { return contentFS.Open(fsPath) }
+	}
+
+	// read here (and not in sink) so a vanished link is tolerated like other entries
+	if linkFS, ok := fsys.(readLinkFS); ok && fileInfo.Mode()&os.ModeSymlink != 0 {
+		var err error
+		e.symlinkTarget, err = linkFS.ReadLink(fsPath) // works for broken symlinks as well
+		if err != nil {
+			return w.entryError(fmt.Errorf("%s: %w", path, err))
+		}
+	}
+
+	if w.opts.AllTimes {
+		e.accessed, e.changed, _ = getFileTimes(fileInfo)
+	}
+
+	if allocated, ok := getAllocatedBytes(fileInfo); w.opts.WithAllocation && ok && fileInfo.Mode().IsRegular() {
+		e.allocated = &allocated
+	}
+
+	if osFS, ok := fsys.(*osDirFS); ok && w.opts.Xattrs {
+		var err error
+		e.xattrs, err = readXattrs(osFS.osPath(fsPath))
+		if err != nil {
+			return w.entryError(fmt.Errorf("%s: %w", path, err))
+		}
+
+		if w.opts.Format == FormatZip {
+			if err := checkXattrsFitExtraField(e.xattrs); err != nil {
+				return w.entryError(fmt.Errorf("%s: %w", path, err))
+			}
+		}
+	}
+
+	if osFS, ok := fsys.(*osDirFS); ok && w.opts.FileAttrs && (fileInfo.Mode().IsRegular() || fileInfo.IsDir()) {
+		var err error
+		e.fileAttrs, err = readFileAttrs(osFS.osPath(fsPath))
+		if err != nil {
+			return w.entryError(fmt.Errorf("%s: file attributes: %w", path, err))
+		}
+	}
+
+	readsContent := fileInfo.Mode().IsRegular() && (w.opts.Hash == HashSHA256 || w.opts.headLen(fileInfo.Size()) > 0)
+
+	if w.readers != nil {
+		w.readers.submit(e, readsContent, func(e *entry) error { return w.readContent(fsys, fsPath, e) })
+		return nil
+	}
+
+	// done outside of visitEntry() so that with concurrency the slow part runs in parallel
+	if readsContent {
+		if err := w.readContent(fsys, fsPath, &e); err != nil {
+			return w.entryError(err)
+		}
+	}
+
+	return w.visitEntry(e)
+}
+
+// hashing and sampling, i.e. the slow part
+func (w *walker) readContent(fsys fs.FS, fsPath string, e *entry) error {
+	fsys = w.throttle.readFS(w.ctx, fsys)
+
+	if w.opts.Hash == HashSHA256 {
+		var err error
+		e.sha256, err = hashFileSHA256(fsys, fsPath)
+		if err != nil {
+			return fmt.Errorf("%s: %w", e.path, err)
+		}
+	}
+
+	if n := w.opts.headLen(e.fileInfo.Size()); n > 0 {
+		head, err := sampleFile(fsys, fsPath, n)
+		if err != nil {
+			return fmt.Errorf("%s: %w", e.path, err)
+		}
+
+		e.setHead(head, w.opts)
+	}
+
+	return nil
+}
+
+func (w *walker) visitEntry(e entry) error {
+	w.visitMu.Lock()
+	defer w.visitMu.Unlock()
+
+	w.state.stats.count(e.fileInfo)
+	w.state.progress.Entry(e.path, e.fileInfo, w.state.stats)
+
+	return w.visit(e)
+}
+
+// with --skip-errors, logs and records the error so the entry gets left out and the walk goes on.
+// entries that vanished mid-scan are always tolerated.
+func (w *walker) entryError(err error) error {
+	vanished := errors.Is(err, fs.ErrNotExist)
+
+	if !vanished && !w.opts.SkipErrors {
+		return err
+	}
+
+	w.visitMu.Lock()
+	defer w.visitMu.Unlock()
+
+	w.state.progress.clear()
+
+	if vanished { // deleted after its parent directory was listed. normal when scanning a live tree.
+		w.state.logl.Info.Printf("vanished during scan: %v", err)
+		w.state.stats.Skipped[skippedByVanishing]++
+		return nil
+	}
+	w.state.logl.Error.Println(err.Error())
+	w.state.stats.Errors = append(w.state.stats.Errors, err.Error())
+
+	return nil
+}
+
+func (w *walker) tryHandOff(parent walkJob, dir string) bool {
+	if w.handOffSlots == nil {
+		return false
+	}
+
+	select {
+	case w.handOffSlots <- struct{}{}:
+	default: // all goroutines busy
+		return false
+	}
+
+	child := newChildJob(parent, dir)
+
+	w.handedOff.Add(1)
+	go func() {
+		defer w.handedOff.Done()
+		defer func() { <-w.handOffSlots }()
+
+		if err := w.run(child); err != nil {
+			w.setErr(err)
+		}
+	}()
+
+	return true
+}
+
+// for walking a subdirectory (that has already been visited) separately
+func newChildJob(parent walkJob, dir string) walkJob {
+	child := walkJob{
+		root:       parent.root,
+		dir:        dir,
+		rootDevice: parent.rootDevice,
+		ancestors:  &ancestorDirs{append([]ancestorDir(nil), parent.ancestors.dirs...)}, // snapshot, like gitignores
+	}
+	if parent.gitignores != nil { // snapshot, because the parent's stack keeps changing
+		child.gitignores = &gitignoreStack{fsys: parent.gitignores.fsys, levels: append([]gitignoreLevel(nil), parent.gitignores.levels...)}
+	}
+	if parent.skeletonignores != nil {
+		child.skeletonignores = parent.skeletonignores.snapshot()
+	}
+
+	return child
+}
+
+// with FollowSymlinks, returns the link target's metadata (to be recorded under the link's name)
+// and whether it's a directory to walk. the link is recorded as-is if it's broken, or if its target
+// directory has already been walked (which is what protects against loops).
+func (w *walker) follow(fsys fs.FS, fsPath string, link fs.FileInfo) (fs.FileInfo, bool) {
+	osFS, ok := fsys.(*osDirFS)
+	if !ok {
+		return link, false
+	}
+
+	target, err := os.Stat(osFS.osPath(fsPath))
+	if err != nil {
+		w.state.logl.Debug.Printf("%s: not following: %v", osFS.osPath(fsPath), err)
+		return link, false
+	}
+
+	if !target.IsDir() {
+		return target, false
+	}
+
+	if !fileIDsSupported { // can't detect loops
+		return link, false
+	}
+
+	if w.markWalked(target) {
+		w.warn("%s: not following, as its target has already been walked (a loop, or another link to it)", osFS.osPath(fsPath))
+		return link, false
+	}
+
+	return target, true
+}
+
+// records that dir is walked. returns true if it already was. directories whose identity isn't
+// known are never considered walked.
+func (w *walker) markWalked(dir fs.FileInfo) bool {
+	id, _, ok := getFileID(dir)
+	if !ok {
+		return false
+	}
+
+	w.walkedDirsMu.Lock()
+	defer w.walkedDirsMu.Unlock()
+
+	if w.walkedDirs == nil {
+		w.walkedDirs = map[fileID]bool{}
+	}
+
+	if w.walkedDirs[id] {
+		return true
+	}
+
+	w.walkedDirs[id] = true
+
+	return false
+}
+
+func (w *walker) warn(format string, args ...interface{}) {
+	w.visitMu.Lock()
+	defer w.visitMu.Unlock()
+
+	w.state.progress.clear()
+	w.state.logl.Info.Printf(format, args...)
+}
+
+// the directories that the walk is currently inside of. like gitignoreStack, relies on the walk
+// being depth-first.
+type ancestorDirs struct {
+	dirs []ancestorDir
+}
+
+type ancestorDir struct {
+	path string // in fsys
+	id   fileID
+}
+
+// if dir is the same directory as one of its ancestors, returns the ancestor's path
+func (a *ancestorDirs) enter(dir string, fileInfo fs.FileInfo) (string, bool) {
+	id, _, ok := getFileID(fileInfo)
+	if !ok {
+		return "", false
+	}
+
+	for len(a.dirs) > 0 && !fsIsWithinDir(a.dirs[len(a.dirs)-1].path, dir) {
+		a.dirs = a.dirs[:len(a.dirs)-1]
+	}
+
+	for _, ancestor := range a.dirs {
+		if ancestor.id == id {
+			return ancestor.path, true
+		}
+	}
+
+	a.dirs = append(a.dirs, ancestorDir{dir, id})
+
+	return "", false
+}
+
+func (w *walker) setErr(err error) {
+	w.errMu.Lock()
+	defer w.errMu.Unlock()
+
+	if w.err == nil {
+		w.err = err
+		w.cancel() // stop the other goroutines as well
+	}
+}
+
+// for messages. OS path if possible, because that's what the user gave us.
+func displayPath(fsys fs.FS, name string) string {
+	if osFS, ok := fsys.(*osDirFS); ok {
+		return stripExtendedLengthPrefix(osFS.osPath(name))
+	}
+
+	return name
+}
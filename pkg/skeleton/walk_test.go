@@ -0,0 +1,368 @@
+package skeleton
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestFilesFromArchiveName(t *testing.T) {
+	for _, tc := range []struct {
+		path string
+		name string
+	}{
+		{"dir/file", "dir/file"},
+		{"/tmp/x", "tmp/x"},
+		{"../x", "x"},
+		{"../../a/b", "a/b"},
+		{"..", ""},
+		{"/", ""},
+		{"..foo/bar", "..foo/bar"},
+	} {
+		assertEqual(t, filesFromArchiveName(filepath.FromSlash(tc.path)), tc.name)
+	}
+}
+
+// absolute and "../" paths must not end up as names that restore refuses as escaping
+func TestFilesFromAbsoluteAndParentPaths(t *testing.T) {
+	base := t.TempDir()
+	workDir := filepath.Join(base, "work")
+	for _, dir := range []string{workDir, filepath.Join(base, "sibling")} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{filepath.Join(workDir, "abs.txt"), filepath.Join(base, "sibling", "up.txt")} {
+		if err := os.WriteFile(file, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	previousDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(workDir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(previousDir) })
+
+	absPath := filepath.Join(workDir, "abs.txt")
+	list := filepath.Join(base, "list.txt")
+	if err := os.WriteFile(list, []byte(absPath+"\n../sibling/up.txt\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := DefaultOptions()
+	opts.FilesFrom = list
+
+	output := bytes.Buffer{}
+	if _, err := Archive(context.Background(), &output, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	archivePath := filepath.Join(base, "out.zip")
+	if err := os.WriteFile(archivePath, output.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range entryPaths(listTestArchive(t, archivePath)) {
+		if strings.HasPrefix(name, "/") || name == ".." || strings.HasPrefix(name, "../") {
+			t.Fatalf("name escapes: %s", name)
+		}
+	}
+
+	destDir := filepath.Join(base, "restored")
+	if err := Restore(context.Background(), []string{archivePath}, destDir, RestoreOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, restored := range []string{filesFromArchiveName(absPath), "sibling/up.txt"} {
+		if _, err := os.Stat(filepath.Join(destDir, filepath.FromSlash(restored))); err != nil {
+			t.Fatalf("not restored: %v", err)
+		}
+	}
+}
+
+// a tree wide and deep enough for subtrees to be handed off to other walkers
+func concurrencyTestFS() fstest.MapFS {
+	fsys := fstest.MapFS{}
+	for i := 0; i < 20; i++ {
+		for j := 0; j < 10; j++ {
+			fsys[fmt.Sprintf("root/dir%02d/sub%d/file%d.txt", i, j%3, j)] = testFile(strings.Repeat("x", i*j))
+		}
+	}
+
+	return fsys
+}
+
+func TestConcurrencyGivesSameEntriesAsSequentialWalk(t *testing.T) {
+	fsys := concurrencyTestFS()
+
+	sequentialPath, sequentialStats := archiveTestFS(t, fsys, []string{"root"}, DefaultOptions())
+	sequential := listTestArchive(t, sequentialPath)
+
+	opts := DefaultOptions()
+	opts.Concurrency = 8
+
+	concurrentPath, concurrentStats := archiveTestFS(t, fsys, []string{"root"}, opts)
+	concurrent := listTestArchive(t, concurrentPath)
+
+	// the order may differ (subtrees are written as they're walked), but nothing else
+	sortByPath := func(entries []ManifestEntry) {
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	}
+	sortByPath(sequential)
+	sortByPath(concurrent)
+
+	assertEqual(t, concurrent, sequential)
+	assertEqual(t, concurrentStats.Files, sequentialStats.Files)
+	assertEqual(t, concurrentStats.LogicalBytes, sequentialStats.LogicalBytes)
+
+	// and with Reproducible, the very same bytes
+	opts.Reproducible = true
+	sequentialOpts := DefaultOptions()
+	sequentialOpts.Reproducible = true
+
+	reproducibleSequential, _ := archiveTestFS(t, fsys, []string{"root"}, sequentialOpts)
+	reproducibleConcurrent, _ := archiveTestFS(t, fsys, []string{"root"}, opts)
+	assertSameFile(t, reproducibleConcurrent, reproducibleSequential)
+}
+
+// like a network filesystem, where each listing, stat and open is a round trip
+type slowFS struct {
+	fstest.MapFS
+	latency time.Duration
+}
+
+var _ fs.ReadDirFS = slowFS{}
+
+func (s slowFS) Open(name string) (fs.File, error) {
+	time.Sleep(s.latency)
+	return s.MapFS.Open(name)
+}
+
+func (s slowFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	time.Sleep(s.latency)
+
+	entries, err := s.MapFS.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, entry := range entries {
+		entries[i] = slowDirEntry{entry, s.latency}
+	}
+
+	return entries, nil
+}
+
+type slowDirEntry struct {
+	fs.DirEntry
+	latency time.Duration
+}
+
+func (s slowDirEntry) Info() (fs.FileInfo, error) {
+	time.Sleep(s.latency)
+	return s.DirEntry.Info()
+}
+
+// where the walk waits for the filesystem, the concurrent walkers should overlap the waits
+func BenchmarkArchiveConcurrency(b *testing.B) {
+	fsys := slowFS{MapFS: concurrencyTestFS(), latency: 100 * time.Microsecond}
+
+	for _, concurrency := range []int{1, 8} {
+		b.Run(fmt.Sprintf("%d", concurrency), func(b *testing.B) {
+			opts := DefaultOptions()
+			opts.Concurrency = concurrency
+
+			for i := 0; i < b.N; i++ {
+				if _, err := ArchiveFS(context.Background(), io.Discard, fsys, []string{"root"}, opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// a tree that changes while it's walked: directories list what was there, but stat and open see
+// what's there now
+type racingFS struct {
+	listed  fstest.MapFS
+	current fstest.MapFS
+}
+
+var _ fs.ReadDirFS = racingFS{}
+
+func (r racingFS) Open(name string) (fs.File, error) {
+	return r.current.Open(name)
+}
+
+func (r racingFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := r.listed.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, entry := range entries {
+		entries[i] = racingDirEntry{entry, r, path.Join(name, entry.Name())}
+	}
+
+	return entries, nil
+}
+
+type racingDirEntry struct {
+	fs.DirEntry
+	fsys racingFS
+	path string
+}
+
+func (r racingDirEntry) Info() (fs.FileInfo, error) {
+	if _, err := fs.Stat(r.fsys.current, r.path); err != nil {
+		return nil, err
+	}
+
+	return r.DirEntry.Info() // the size etc. as listed
+}
+
+func TestEntriesVanishingMidScanAreSkipped(t *testing.T) {
+	fsys := racingFS{
+		listed: fstest.MapFS{
+			"root/kept.txt":      testFile("x"),
+			"root/gone.txt":      testFile("x"),
+			"root/gonedir/a.txt": testFile("x"),
+			"root/shrunk.txt":    testFile("0123456789"),
+		},
+		current: fstest.MapFS{
+			"root/kept.txt":   testFile("x"),
+			"root/shrunk.txt": testFile("012"),
+		},
+	}
+
+	opts := DefaultOptions()
+	opts.Hash = HashSHA256 // so that content gets read as well
+
+	archivePath, stats := archiveTestFS(t, fsys, []string{"root"}, opts)
+
+	entries := listTestArchive(t, archivePath)
+	assertEqual(t, entryPaths(entries), []string{"root", "root/kept.txt", "root/shrunk.txt"})
+	assertEqual(t, entryByPath(t, entries, "root/shrunk.txt").Size, int64(10)) // the size when it was stat'd
+	assertEqual(t, stats.Skipped[skippedByVanishing], 2)
+}
+
+func TestSymlinkLoopIsNotFollowedForever(t *testing.T) {
+	if !fileIDsSupported {
+		t.Skip("loops can't be detected on this platform")
+	}
+
+	root := filepath.Join(t.TempDir(), "root")
+	if err := os.MkdirAll(filepath.Join(root, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "sub", "file"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("..", filepath.Join(root, "sub", "loop")); err != nil {
+		t.Skipf("can't create symlinks: %v", err)
+	}
+
+	opts := DefaultOptions()
+	opts.FollowSymlinks = true
+
+	entries := listTestArchive(t, archiveTestDirs(t, []string{root}, opts))
+	assertEqual(t, entryPaths(entries), []string{"root", "root/sub", "root/sub/file", "root/sub/loop"})
+	if !strings.HasPrefix(entryByPath(t, entries, "root/sub/loop").Mode, "L") { // kept as the link
+		t.Fatalf("expected a symlink, got %s", entryByPath(t, entries, "root/sub/loop").Mode)
+	}
+}
+
+// a bind mount of a directory inside itself looks like the directory itself at a deeper path
+func TestAncestorDirsDetectsCycle(t *testing.T) {
+	if !fileIDsSupported {
+		t.Skip("cycles can't be detected on this platform")
+	}
+
+	root := t.TempDir()
+	for _, dir := range []string{"a/b", "c"} {
+		if err := os.MkdirAll(filepath.Join(root, filepath.FromSlash(dir)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stat := func(dir string) fs.FileInfo {
+		info, err := os.Stat(filepath.Join(root, filepath.FromSlash(dir)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info
+	}
+
+	ancestors := &ancestorDirs{}
+	for _, dir := range []string{".", "a", "a/b"} {
+		if _, isCycle := ancestors.enter(dir, stat(dir)); isCycle {
+			t.Fatalf("%s: unexpected cycle", dir)
+		}
+	}
+
+	ancestor, isCycle := ancestors.enter("a/b/mnt", stat("a"))
+	assertEqual(t, isCycle, true)
+	assertEqual(t, ancestor, "a")
+
+	// the same directory elsewhere than inside itself isn't a cycle
+	if _, isCycle := ancestors.enter("c", stat("c")); isCycle {
+		t.Fatal("c: unexpected cycle")
+	}
+	if _, isCycle := ancestors.enter("c/a-again", stat("a")); isCycle {
+		t.Fatal("c/a-again: unexpected cycle")
+	}
+}
+
+func TestAllTimes(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("--all-times is Linux-only")
+	}
+
+	root := filepath.Join(t.TempDir(), "root")
+	if err := os.Mkdir(root, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(root, "file")
+	if err := os.WriteFile(file, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	accessed := time.Date(2019, 1, 2, 3, 4, 5, 123456789, time.UTC)
+	if err := os.Chtimes(file, accessed, testModTime); err != nil {
+		t.Fatal(err)
+	}
+
+	withoutAllTimes := entryByPath(t, listTestArchive(t, archiveTestDirs(t, []string{root}, DefaultOptions())), "root/file")
+	if withoutAllTimes.Accessed != nil || withoutAllTimes.Changed != nil {
+		t.Fatal("times recorded without AllTimes")
+	}
+
+	opts := DefaultOptions()
+	opts.AllTimes = true
+
+	entry := entryByPath(t, listTestArchive(t, archiveTestDirs(t, []string{root}, opts)), "root/file")
+	if entry.Accessed == nil || entry.Changed == nil {
+		t.Fatal("times not recorded")
+	}
+
+	assertEqual(t, entry.Accessed.UTC(), accessed)
+	assertEqual(t, entry.Modified, testModTime)
+	if time.Since(*entry.Changed) > time.Hour { // Chtimes() just changed it
+		t.Fatalf("unexpected change time %v", *entry.Changed)
+	}
+}
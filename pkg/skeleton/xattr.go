@@ -0,0 +1,120 @@
+package skeleton
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"syscall"
+
+	"github.com/pkg/xattr"
+)
+
+type extendedAttribute struct {
+	name  string
+	value []byte
+}
+
+// doesn't follow symlinks. attributes are sorted by name for reproducibility.
+func readXattrs(path string) ([]extendedAttribute, error) {
+	names, err := xattr.LList(path)
+	if err != nil {
+		if isXattrUnsupported(err) { // filesystem (or OS) doesn't support them => there are none
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	sort.Strings(names)
+
+	attrs := []extendedAttribute{}
+	for _, name := range names {
+		value, err := xattr.LGet(path, name)
+		if err != nil {
+			return nil, err
+		}
+
+		attrs = append(attrs, extendedAttribute{name, value})
+	}
+
+	return attrs, nil
+}
+
+func isXattrUnsupported(err error) bool {
+	return !xattr.XATTR_SUPPORTED || errors.Is(err, syscall.ENOTSUP)
+}
+
+// sequence of (name length uint16, name, value length uint16, value). uint16 suffices, because
+// the whole extra field can't be larger than that anyway.
+func encodeXattrsExtraField(attrs []extendedAttribute) ([]byte, error) {
+	if err := checkXattrsFitExtraField(attrs); err != nil {
+		return nil, err
+	}
+
+	data := []byte{}
+
+	for _, attr := range attrs {
+		data = appendLengthPrefixed(data, []byte(attr.name))
+		data = appendLengthPrefixed(data, attr.value)
+	}
+
+	return data, nil
+}
+
+// a zip extra field is at most 64 KiB (tar's PAX records have no such limit). checked already when
+// walking, so that an entry with too large xattrs can be skipped like other unreadable ones.
+func checkXattrsFitExtraField(attrs []extendedAttribute) error {
+	size := 0
+	for _, attr := range attrs {
+		size += 2 + len(attr.name) + 2 + len(attr.value) // see appendLengthPrefixed()
+	}
+
+	// leave room for our other extra fields
+	if size > 0xffff-1024 {
+		return fmt.Errorf("extended attributes too large to store (%d bytes)", size)
+	}
+
+	return nil
+}
+
+func decodeXattrsExtraField(data []byte) ([]extendedAttribute, error) {
+	attrs := []extendedAttribute{}
+
+	for len(data) > 0 {
+		name, rest, err := cutLengthPrefixed(data)
+		if err != nil {
+			return nil, err
+		}
+
+		value, rest, err := cutLengthPrefixed(rest)
+		if err != nil {
+			return nil, err
+		}
+
+		attrs = append(attrs, extendedAttribute{string(name), value})
+		data = rest
+	}
+
+	return attrs, nil
+}
+
+func appendLengthPrefixed(data []byte, item []byte) []byte {
+	length := make([]byte, 2)
+	binary.LittleEndian.PutUint16(length, uint16(len(item)))
+
+	return append(append(data, length...), item...)
+}
+
+func cutLengthPrefixed(data []byte) ([]byte, []byte, error) {
+	if len(data) < 2 {
+		return nil, nil, errors.New("corrupt length-prefixed data")
+	}
+
+	length := int(binary.LittleEndian.Uint16(data[0:2]))
+	if len(data) < 2+length {
+		return nil, nil, errors.New("corrupt length-prefixed data")
+	}
+
+	return data[2 : 2+length], data[2+length:], nil
+}
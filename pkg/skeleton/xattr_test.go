@@ -0,0 +1,71 @@
+package skeleton
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/xattr"
+)
+
+func TestCheckXattrsFitExtraField(t *testing.T) {
+	small := []extendedAttribute{{"user.a", []byte("x")}}
+	if err := checkXattrsFitExtraField(small); err != nil {
+		t.Fatal(err)
+	}
+
+	large := []extendedAttribute{{"user.a", bytes.Repeat([]byte("x"), 40000)}, {"user.b", bytes.Repeat([]byte("x"), 30000)}}
+	if err := checkXattrsFitExtraField(large); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Fatalf("expected too large, got %v", err)
+	}
+
+	if _, err := encodeXattrsExtraField(large); err == nil {
+		t.Fatal("expected encoding to fail")
+	}
+}
+
+// needs a filesystem that takes large xattrs (e.g. tmpfs or XFS, but not ext4). try with TMPDIR=/dev/shm
+func TestTooLargeXattrsAreSkippedWithSkipErrors(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "root")
+	if err := os.Mkdir(root, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"large", "small"} {
+		if err := os.WriteFile(filepath.Join(root, file), []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{"user.a", "user.b", "user.c"} {
+		if err := xattr.Set(filepath.Join(root, "large"), name, bytes.Repeat([]byte("x"), 30000)); err != nil {
+			t.Skipf("filesystem doesn't take large xattrs: %v", err)
+		}
+	}
+
+	opts := DefaultOptions()
+	opts.Xattrs = true
+
+	if _, err := Archive(context.Background(), &bytes.Buffer{}, []string{root}, opts); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Fatalf("expected too large, got %v", err)
+	}
+
+	opts.SkipErrors = true
+
+	output := bytes.Buffer{}
+	stats, err := Archive(context.Background(), &output, []string{root}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEqual(t, len(stats.Errors), 1)
+
+	archivePath := filepath.Join(t.TempDir(), "out.zip")
+	if err := os.WriteFile(archivePath, output.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	assertEqual(t, entryPaths(listTestArchive(t, archivePath)), []string{"root", "root/small"})
+}
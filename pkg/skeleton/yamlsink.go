@@ -0,0 +1,38 @@
+package skeleton
+
+import (
+	"io"
+
+	"gopkg.in/yaml.v3"
+)
+
+// entries as one YAML sequence (the same structure as FormatJSON). each entry is encoded as a
+// sequence of its own, and those concatenated are one sequence, so memory use stays flat.
+type yamlSink struct {
+	file    io.Writer
+	entries int
+}
+
+func newYAMLSink(file io.Writer) *yamlSink {
+	return &yamlSink{file: file}
+}
+
+func (y *yamlSink) Entry(e entry) error {
+	entryYAML, err := yaml.Marshal([]ManifestEntry{newManifestEntry(e)})
+	if err != nil {
+		return err
+	}
+	y.entries++
+
+	_, err = y.file.Write(entryYAML)
+	return err
+}
+
+func (y *yamlSink) Close() error {
+	if y.entries > 0 {
+		return nil
+	}
+
+	_, err := io.WriteString(y.file, "[]\n")
+	return err
+}